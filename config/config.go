@@ -5,12 +5,28 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/gofigure"
 )
 
+// Publishers deltas can be sent to, selected with PUBLISHER.
+const (
+	PublisherKafka  = "kafka"
+	PublisherMemory = "memory"
+	PublisherFile   = "file"
+	PublisherStdout = "stdout"
+)
+
+// Exporters traces can be sent to, selected with TRACING_EXPORTER.
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
 var (
 	cfg                *Config
 	mtx                sync.Mutex
@@ -19,22 +35,43 @@ var (
 
 // Config defines the configuration options for this service.
 type Config struct {
-	BindAddr                string   `env:"BIND_ADDR" flag:"bind-addr" flagDesc:"Bind address"`
-	BrokerAddr              []string `env:"KAFKA_BROKER_ADDR" flag:"broker-addr" flagDesc:"Kafka broker address (Comma separated list if there is more than one address)"`
-	SchemaRegistryURL       string   `env:"SCHEMA_REGISTRY_URL" flag:"schema-registry-url" flagDesc:"URL for Kafka Schema Registry"`
-	OfficerDeltaTopic       string   `env:"OFFICER_DELTA_TOPIC" flag:"officer-delta-topic" flagDesc:"Topic for officer deltas"`
-	OpenApiSpec             string   `env:"OPEN_API_SPEC" flag:"open-api-spec" flagDesc:"OpenAPI schema location"`
-	InsolvencyDeltaTopic    string   `env:"INSOLVENCY_DELTA_TOPIC" flag:"insolvency-delta-topic" flagDesc:"Topic for insolvency deltas"`
-	ChargesDeltaTopic       string   `env:"CHARGES_DELTA_TOPIC" flag:"charges-delta-topic" flagDesc:"Topic for charges deltas"`
-	DisqualifiedDeltaTopic  string   `env:"DISQUALIFIED_OFFICERS_DELTA_TOPIC" flag:"disqualified-officers-delta-topic" flagDesc:"Topic for disqualification deltas"`
-	CompanyDeltaTopic       string   `env:"COMPANY_DELTA_TOPIC" flag:"company-delta-topic" flagDesc:"Topic for company deltas"`
-	ExemptionDeltaTopic     string   `env:"EXEMPTION_DELTA_TOPIC" flag:"exemption-delta-topic" flagDesc:"Topic for exemption deltas"`
-	PscStatementDeltaTopic  string   `env:"PSC_STATEMENT_DELTA_TOPIC" flag:"psc-statement-delta-topic" flagDesc:"Topic for psc statement deltas"`
-	PscDeltaTopic           string   `env:"PSC_DELTA_TOPIC" flag:"psc-delta-topic" flagDesc:"Topic for psc deltas"`
-	FilingHistoryDeltaTopic string   `env:"FILING_HISTORY_DELTA_TOPIC" flag:"filing-history-delta-topic" flagDesc:"Topic for filing history deltas"`
-	DocumentStoreDeltaTopic string   `env:"DOCUMENT_STORE_DELTA_TOPIC" flag:"document-store-delta-topic" flagDesc:"Topic for document store deltas"`
-	RegistersDeltaTopic     string   `env:"REGISTERS_DELTA_TOPIC" flag:"registers-delta-topic" flagDesc:"Topic for registers deltas"`
-	AcspProfileDeltaTopic   string   `env:"ACSP_PROFILE_DELTA_TOPIC" flag:"acsp-profile-delta-topic" flagDesc:"Topic for ACSP profile deltas"`
+	BindAddr                 string   `env:"BIND_ADDR" flag:"bind-addr" flagDesc:"Bind address"`
+	BrokerAddr               []string `env:"KAFKA_BROKER_ADDR" flag:"broker-addr" flagDesc:"Kafka broker address (Comma separated list if there is more than one address)"`
+	SchemaRegistryURL        string   `env:"SCHEMA_REGISTRY_URL" flag:"schema-registry-url" flagDesc:"URL for Kafka Schema Registry"`
+	SchemaVersion            int      `env:"SCHEMA_VERSION" flag:"schema-version" flagDesc:"Version of the chs-delta schema to publish with (0 for the latest)"`
+	SchemaWireFormat         bool     `env:"SCHEMA_WIRE_FORMAT" flag:"schema-wire-format" flagDesc:"Prefix messages with the Confluent wire format magic byte and schema id"`
+	SchemaCacheFile          string   `env:"SCHEMA_CACHE_FILE" flag:"schema-cache-file" flagDesc:"File the chs-delta schema is cached in, for use when the schema registry is unavailable at startup"`
+	SchemaRefreshSecs        int      `env:"SCHEMA_REFRESH_SECS" flag:"schema-refresh-secs" flagDesc:"Seconds between attempts to reach the schema registry after starting with a fallback schema (0 for the default of 60)"`
+	Publisher                string   `env:"PUBLISHER" flag:"publisher" flagDesc:"Where deltas are published: kafka, memory, file or stdout (defaults to kafka)"`
+	PublisherFile            string   `env:"PUBLISHER_FILE" flag:"publisher-file" flagDesc:"File deltas are appended to as newline-delimited JSON when PUBLISHER is file"`
+	ShutdownGraceSecs        int      `env:"SHUTDOWN_GRACE_SECS" flag:"shutdown-grace-secs" flagDesc:"Seconds in-flight requests are given to finish when shutting down (0 for the default of 20)"`
+	ShutdownDelaySecs        int      `env:"SHUTDOWN_DELAY_SECS" flag:"shutdown-delay-secs" flagDesc:"Seconds between failing the healthcheck and refusing new requests when shutting down"`
+	OfficerDeltaTopic        string   `env:"OFFICER_DELTA_TOPIC" flag:"officer-delta-topic" flagDesc:"Topic for officer deltas"`
+	InsolvencyDeltaTopic     string   `env:"INSOLVENCY_DELTA_TOPIC" flag:"insolvency-delta-topic" flagDesc:"Topic for insolvency deltas"`
+	ChargesDeltaTopic        string   `env:"CHARGES_DELTA_TOPIC" flag:"charges-delta-topic" flagDesc:"Topic for charges deltas"`
+	DisqualifiedDeltaTopic   string   `env:"DISQUALIFIED_OFFICERS_DELTA_TOPIC" flag:"disqualified-officers-delta-topic" flagDesc:"Topic for disqualification deltas"`
+	CompanyDeltaTopic        string   `env:"COMPANY_DELTA_TOPIC" flag:"company-delta-topic" flagDesc:"Topic for company deltas"`
+	ExemptionDeltaTopic      string   `env:"EXEMPTION_DELTA_TOPIC" flag:"exemption-delta-topic" flagDesc:"Topic for exemption deltas"`
+	PscStatementDeltaTopic   string   `env:"PSC_STATEMENT_DELTA_TOPIC" flag:"psc-statement-delta-topic" flagDesc:"Topic for psc statement deltas"`
+	PscDeltaTopic            string   `env:"PSC_DELTA_TOPIC" flag:"psc-delta-topic" flagDesc:"Topic for psc deltas"`
+	FilingHistoryDeltaTopic  string   `env:"FILING_HISTORY_DELTA_TOPIC" flag:"filing-history-delta-topic" flagDesc:"Topic for filing history deltas"`
+	DocumentStoreDeltaTopic  string   `env:"DOCUMENT_STORE_DELTA_TOPIC" flag:"document-store-delta-topic" flagDesc:"Topic for document store deltas"`
+	RegistersDeltaTopic      string   `env:"REGISTERS_DELTA_TOPIC" flag:"registers-delta-topic" flagDesc:"Topic for registers deltas"`
+	AcspProfileDeltaTopic    string   `env:"ACSP_PROFILE_DELTA_TOPIC" flag:"acsp-profile-delta-topic" flagDesc:"Topic for ACSP profile deltas"`
+	OpenApiSpec              string   `env:"OPEN_API_SPEC" flag:"open-api-spec" flagDesc:"OpenAPI schema location"`
+	OpenApiSpecReloadSecs    int      `env:"OPEN_API_SPEC_RELOAD_SECS" flag:"open-api-spec-reload-secs" flagDesc:"Interval in seconds between checks for changes to the OpenAPI schema (0 to only reload on SIGHUP)"`
+	OutboxDir                string   `env:"OUTBOX_DIR" flag:"outbox-dir" flagDesc:"Directory of the local outbox deltas are written to before being sent to Kafka (unset to send directly)"`
+	OutboxMaxBytes           int      `env:"OUTBOX_MAX_BYTES" flag:"outbox-max-bytes" flagDesc:"Disk usage limit of the outbox in bytes (0 for the default of 256MiB)"`
+	MaxBodyBytes             int      `env:"MAX_BODY_BYTES" flag:"max-body-bytes" flagDesc:"Largest request body in bytes accepted by delta routes which don't set x-max-body-bytes (0 for the default of 1MiB)"`
+	KafkaVersion             string   `env:"KAFKA_VERSION" flag:"kafka-version" flagDesc:"Kafka protocol version the producer uses, at least 0.11.0.0 to publish record headers (defaults to 0.11.0.0)"`
+	KafkaMaxMessageBytes     int      `env:"KAFKA_MAX_MESSAGE_BYTES" flag:"kafka-max-message-bytes" flagDesc:"Largest message in bytes the producer sends, which must not exceed the broker's message.max.bytes (0 for the default of 1000000)"`
+	KafkaSendAttempts        int      `env:"KAFKA_SEND_ATTEMPTS" flag:"kafka-send-attempts" flagDesc:"Attempts made to send a message to Kafka when it fails with a retriable error (0 for the default of 3)"`
+	KafkaRetryBackoffMs      int      `env:"KAFKA_RETRY_BACKOFF_MS" flag:"kafka-retry-backoff-ms" flagDesc:"Backoff in milliseconds before the first retry, doubling for each retry after (0 for the default of 100)"`
+	KafkaBreakerThreshold    int      `env:"KAFKA_BREAKER_THRESHOLD" flag:"kafka-breaker-threshold" flagDesc:"Consecutive failed sends which open the Kafka circuit breaker (0 for the default of 5)"`
+	KafkaBreakerCooldownSecs int      `env:"KAFKA_BREAKER_COOLDOWN_SECS" flag:"kafka-breaker-cooldown-secs" flagDesc:"Seconds the Kafka circuit breaker stays open before trying again (0 for the default of 30)"`
+	DeadLetterTopic          string   `env:"DEAD_LETTER_TOPIC" flag:"dead-letter-topic" flagDesc:"Kafka topic deltas which can't be published are sent to"`
+	DeadLetterDir            string   `env:"DEAD_LETTER_DIR" flag:"dead-letter-dir" flagDesc:"Directory deltas which can't be published are written to when they can't be sent to the dead-letter topic"`
+	TracingExporter          string   `env:"TRACING_EXPORTER" flag:"tracing-exporter" flagDesc:"Where OpenTelemetry spans are exported: none, otlp or stdout (defaults to none)"`
 }
 
 // Get returns a pointer to a Config instance populated with values from environment or command-line flags
@@ -70,64 +107,34 @@ func validateConfigs(cfg *Config) error {
 		mandatoryElementMissing = true
 	}
 
-	if len(cfg.BrokerAddr) == 0 {
-		log.Info("KAFKA_BROKER_ADDR not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.SchemaRegistryURL == "" {
-		log.Info("SCHEMA_REGISTRY_URL not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.OfficerDeltaTopic == "" {
-		log.Info("OFFICER_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.InsolvencyDeltaTopic == "" {
-		log.Info("INSOLVENCY_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.ChargesDeltaTopic == "" {
-		log.Info("CHARGES_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.DisqualifiedDeltaTopic == "" {
-		log.Info("DISQUALIFIED_OFFICERS_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.PscStatementDeltaTopic == "" {
-		log.Info("PSC_STATEMENT_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.PscDeltaTopic == "" {
-		log.Info("PSC_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.FilingHistoryDeltaTopic == "" {
-		log.Info("FILING_HISTORY_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.DocumentStoreDeltaTopic == "" {
-		log.Info("DOCUMENT_STORE_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.RegistersDeltaTopic == "" {
-		log.Info("REGISTERS_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
-	}
-
-	if cfg.AcspProfileDeltaTopic == "" {
-		log.Info("ACSP_PROFILE_DELTA_TOPIC not set in environment")
-		mandatoryElementMissing = true
+	// Kafka is only needed when deltas are published to it.
+	switch cfg.Publisher {
+	case "", PublisherKafka:
+		if len(cfg.BrokerAddr) == 0 {
+			log.Info("KAFKA_BROKER_ADDR not set in environment")
+			mandatoryElementMissing = true
+		}
+
+		if cfg.SchemaRegistryURL == "" {
+			log.Info("SCHEMA_REGISTRY_URL not set in environment")
+			mandatoryElementMissing = true
+		}
+	case PublisherFile:
+		if cfg.PublisherFile == "" {
+			log.Info("PUBLISHER_FILE not set in environment")
+			mandatoryElementMissing = true
+		}
+	case PublisherMemory, PublisherStdout:
+	default:
+		return fmt.Errorf("unknown publisher %q, expected one of %s, %s, %s or %s", cfg.Publisher,
+			PublisherKafka, PublisherMemory, PublisherFile, PublisherStdout)
+	}
+
+	switch cfg.TracingExporter {
+	case "", TracingExporterNone, TracingExporterOTLP, TracingExporterStdout:
+	default:
+		return fmt.Errorf("unknown tracing exporter %q, expected one of %s, %s or %s", cfg.TracingExporter,
+			TracingExporterNone, TracingExporterOTLP, TracingExporterStdout)
 	}
 
 	if cfg.OpenApiSpec == "" {
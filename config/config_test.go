@@ -43,3 +43,122 @@ func TestUnitGetNoErrors(t *testing.T) {
 	})
 	os.Clearenv()
 }
+
+func TestUnitValidateConfigsPublishers(t *testing.T) {
+	Convey("Given a config without Kafka settings", t, func() {
+		cfg := &Config{BindAddr: "bind_addr", OpenApiSpec: "open_api_spec"}
+
+		Convey("When deltas are published to Kafka, then it is invalid", func() {
+			So(validateConfigs(cfg), ShouldNotBeNil)
+			cfg.Publisher = PublisherKafka
+			So(validateConfigs(cfg), ShouldNotBeNil)
+		})
+
+		Convey("When deltas are published to memory or stdout, then it is valid", func() {
+			cfg.Publisher = PublisherMemory
+			So(validateConfigs(cfg), ShouldBeNil)
+			cfg.Publisher = PublisherStdout
+			So(validateConfigs(cfg), ShouldBeNil)
+		})
+
+		Convey("When deltas are published to a file, then the file must be set", func() {
+			cfg.Publisher = PublisherFile
+			So(validateConfigs(cfg), ShouldNotBeNil)
+			cfg.PublisherFile = "deltas.ndjson"
+			So(validateConfigs(cfg), ShouldBeNil)
+		})
+
+		Convey("When the publisher is unknown, then it is invalid", func() {
+			cfg.Publisher = "carrier-pigeon"
+			So(validateConfigs(cfg), ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitValidateConfigsTracingExporters asserts that only known tracing exporters are valid.
+func TestUnitValidateConfigsTracingExporters(t *testing.T) {
+	Convey("Given an otherwise valid config", t, func() {
+		cfg := &Config{BindAddr: "bind_addr", OpenApiSpec: "open_api_spec", Publisher: PublisherMemory}
+
+		Convey("When a known exporter or none is chosen, then it is valid", func() {
+			for _, e := range []string{"", TracingExporterNone, TracingExporterOTLP, TracingExporterStdout} {
+				cfg.TracingExporter = e
+				So(validateConfigs(cfg), ShouldBeNil)
+			}
+		})
+
+		Convey("When the exporter is unknown, then it is invalid", func() {
+			cfg.TracingExporter = "jaeger"
+			So(validateConfigs(cfg), ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitDeltaGetDeleteName asserts that delete routes keep their legacy names, and are otherwise suffixed with -delete.
+func TestUnitDeltaGetDeleteName(t *testing.T) {
+	Convey("Given registered deltas", t, func() {
+		officers, _ := FindDelta("/delta/officers")
+		filingHistory, _ := FindDelta("/delta/filing-history")
+		pscs, _ := FindDelta("/delta/pscs")
+
+		Convey("Then delete routes are named as they were before the registry", func() {
+			So(officers.GetDeleteName(), ShouldEqual, "officer-delta")
+			So(filingHistory.GetDeleteName(), ShouldEqual, "filing-history-delete-delta")
+			So(pscs.GetDeleteName(), ShouldEqual, "psc-delta-delete")
+		})
+
+		Convey("Then a delta without a delete name has its name suffixed with -delete", func() {
+			So(Delta{Name: "example-delta"}.GetDeleteName(), ShouldEqual, "example-delta-delete")
+		})
+	})
+}
+
+// TestUnitDeltaGetDeleteLabel asserts that delete routes are labelled apart from upsert routes.
+func TestUnitDeltaGetDeleteLabel(t *testing.T) {
+	Convey("Given registered deltas", t, func() {
+		officers, _ := FindDelta("/delta/officers")
+		filingHistory, _ := FindDelta("/delta/filing-history")
+		pscs, _ := FindDelta("/delta/pscs")
+
+		Convey("Then a delete route sharing the upsert route's name is labelled with -delete", func() {
+			So(officers.GetDeleteLabel(), ShouldEqual, "officer-delta-delete")
+		})
+
+		Convey("Then a delete route with a name of its own is labelled with it", func() {
+			So(filingHistory.GetDeleteLabel(), ShouldEqual, "filing-history-delete-delta")
+			So(pscs.GetDeleteLabel(), ShouldEqual, "psc-delta-delete")
+		})
+	})
+}
+
+// TestUnitConfigExpandTopic asserts that topics are read from their config fields or the environment, and that only
+// optional topics may be left unset.
+func TestUnitConfigExpandTopic(t *testing.T) {
+	Convey("Given a config with the officer topic set by its flag", t, func() {
+		cfg := &Config{OfficerDeltaTopic: "officers-delta"}
+		t.Setenv("EXAMPLE_DELTA_TOPIC", "example-delta")
+
+		Convey("When I expand a topic with a config field, then its value is used", func() {
+			topic, err := cfg.ExpandTopic("${OFFICER_DELTA_TOPIC}")
+			So(err, ShouldBeNil)
+			So(topic, ShouldEqual, "officers-delta")
+		})
+
+		Convey("When I expand a topic without a config field, then it is read from the environment", func() {
+			topic, err := cfg.ExpandTopic("${EXAMPLE_DELTA_TOPIC}")
+			So(err, ShouldBeNil)
+			So(topic, ShouldEqual, "example-delta")
+		})
+
+		Convey("When an optional topic is not set, then it is expanded to nothing", func() {
+			topic, err := cfg.ExpandTopic("${COMPANY_DELTA_TOPIC}")
+			So(err, ShouldBeNil)
+			So(topic, ShouldBeEmpty)
+		})
+
+		Convey("When a mandatory topic is not set, then an error is returned", func() {
+			_, err := cfg.ExpandTopic("${INSOLVENCY_DELTA_TOPIC}")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Delta describes a delta type handled by the API. The endpoints exposed for a delta, along with their Kafka topic and
+// primary id, are declared in the OpenAPI spec using vendor extensions. Every /delta path in the spec must belong to
+// an entry here, which supplies its route name and authentication requirements.
+type Delta struct {
+	// Name is the route name of the upsert endpoint. Validate routes are suffixed with -validate.
+	Name string
+	// DeleteName is the route name of the delete endpoint, which defaults to Name suffixed with -delete. It keeps the
+	// names delete routes were registered with before routes were built from the registry. Metrics are labelled with
+	// GetDeleteLabel instead, as some delete routes share the upsert route's name.
+	DeleteName string
+	// Path is the base path of the delta, e.g. /delta/officers.
+	Path string
+	// SkipAuth registers the routes outside the API key authentication interceptor.
+	SkipAuth bool
+}
+
+// Deltas is the registry of every delta type handled by the API.
+var Deltas = []Delta{
+	{Name: "officer-delta", DeleteName: "officer-delta", Path: "/delta/officers"},
+	{Name: "insolvency-delta", DeleteName: "insolvency-delta", Path: "/delta/insolvency"},
+	{Name: "charges-delta", DeleteName: "charges-delta", Path: "/delta/charges"},
+	{Name: "disqualified-officer-delta", DeleteName: "disqualified-officer-delta", Path: "/delta/disqualification"},
+	{Name: "company-delta", DeleteName: "company-delta", Path: "/delta/company"},
+	{Name: "exemption-delta", DeleteName: "exemption-delta", Path: "/delta/exemption"},
+	{Name: "psc-statement-delta", DeleteName: "psc-statement-delta", Path: "/delta/psc-statement"},
+	{Name: "psc-delta", Path: "/delta/pscs"},
+	{Name: "filing-history-delta", DeleteName: "filing-history-delete-delta", Path: "/delta/filing-history"},
+	{Name: "registers-delta", Path: "/delta/registers"},
+	{Name: "acsp-profile-delta", Path: "/delta/acsp"},
+	// TODO: remove SkipAuth when CHIPS image-sender service has been updated to allow an API key to be configured to its calls here
+	{Name: "document-store-delta", Path: "/delta/document-store", SkipAuth: true},
+}
+
+// GetDeleteName returns the route name of the delta's delete endpoint.
+func (d Delta) GetDeleteName() string {
+	if d.DeleteName != "" {
+		return d.DeleteName
+	}
+	return d.Name + "-delete"
+}
+
+// GetDeleteLabel returns the name which labels the metrics of the delta's delete endpoint. Unlike its route name, it
+// always differs from the upsert endpoint's, so their metrics can be told apart.
+func (d Delta) GetDeleteLabel() string {
+	if name := d.GetDeleteName(); name != d.Name {
+		return name
+	}
+	return d.Name + "-delete"
+}
+
+// FindDelta returns the registered delta which owns the given path, being either its base path or a single segment
+// below it, e.g. /delta/officers/delete.
+func FindDelta(path string) (Delta, bool) {
+	for _, d := range Deltas {
+		if path == d.Path {
+			return d, true
+		}
+		if rest, ok := strings.CutPrefix(path, d.Path+"/"); ok && !strings.Contains(rest, "/") {
+			return d, true
+		}
+	}
+	return Delta{}, false
+}
+
+// optionalDeltaTopics are the topics which may be left unset, as they were never checked before topics were declared
+// in the spec. Deltas sent to an unset topic fail when they are published.
+var optionalDeltaTopics = map[string]bool{
+	"COMPANY_DELTA_TOPIC":   true,
+	"EXEMPTION_DELTA_TOPIC": true,
+}
+
+// ExpandTopic expands the environment variables in a delta's x-kafka-topic extension, e.g. ${OFFICER_DELTA_TOPIC}.
+// Topics with a config field are read from it, so they can also be set with their flags, and any others are read from
+// the environment. An error is returned if a topic which isn't optional is not set.
+func (c *Config) ExpandTopic(topic string) (string, error) {
+	var missing []string
+	expanded := os.Expand(topic, func(name string) string {
+		value := c.deltaTopic(name)
+		if value == "" && !optionalDeltaTopics[name] {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s not set in environment", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// deltaTopic returns the topic set for the named environment variable.
+func (c *Config) deltaTopic(name string) string {
+	if c != nil {
+		topics := map[string]string{
+			"OFFICER_DELTA_TOPIC":               c.OfficerDeltaTopic,
+			"INSOLVENCY_DELTA_TOPIC":            c.InsolvencyDeltaTopic,
+			"CHARGES_DELTA_TOPIC":               c.ChargesDeltaTopic,
+			"DISQUALIFIED_OFFICERS_DELTA_TOPIC": c.DisqualifiedDeltaTopic,
+			"COMPANY_DELTA_TOPIC":               c.CompanyDeltaTopic,
+			"EXEMPTION_DELTA_TOPIC":             c.ExemptionDeltaTopic,
+			"PSC_STATEMENT_DELTA_TOPIC":         c.PscStatementDeltaTopic,
+			"PSC_DELTA_TOPIC":                   c.PscDeltaTopic,
+			"FILING_HISTORY_DELTA_TOPIC":        c.FilingHistoryDeltaTopic,
+			"DOCUMENT_STORE_DELTA_TOPIC":        c.DocumentStoreDeltaTopic,
+			"REGISTERS_DELTA_TOPIC":             c.RegistersDeltaTopic,
+			"ACSP_PROFILE_DELTA_TOPIC":          c.AcspProfileDeltaTopic,
+		}
+		if topic := topics[name]; topic != "" {
+			return topic
+		}
+	}
+	return os.Getenv(name)
+}
@@ -3,12 +3,54 @@ package config
 // OpenApiSpecKey is the location where open api schema is stored
 const OpenApiSpecKey = "spec_location"
 
+// SpecHashKey is the key to the hash of the active open api spec
+const SpecHashKey = "spec_hash"
+
 // SchemaAbsolutePathKey is the key to get the absolute path to the schema
 const SchemaAbsolutePathKey = "schema_absolute_path"
 
 // TopicKey is the key to the name of the Kafka topic
 const TopicKey = "topic"
 
+// PrimaryIdsKey is the key to the primary ids found in a delta request
+const PrimaryIdsKey = "primary_ids"
+
+// PrimaryIdPathKey is the key to the JSON path used to find the primary ids in a delta request
+const PrimaryIdPathKey = "primary_id_path"
+
+// MessageKeyKey is the key to the Kafka message key used to choose a partition
+const MessageKeyKey = "message_key"
+
+// OutboxDirKey is the key to the directory holding the outbox
+const OutboxDirKey = "outbox_dir"
+
+// OutboxDepthKey is the key to the number of deltas waiting in the outbox
+const OutboxDepthKey = "outbox_depth"
+
+// OutboxBytesKey is the key to the total size of the deltas waiting in the outbox
+const OutboxBytesKey = "outbox_bytes"
+
+// AttemptKey is the key to the attempt number of a message sent to Kafka, counting from 0
+const AttemptKey = "attempt"
+
+// DeadLetterIdKey is the key to the id of a dead-lettered delta
+const DeadLetterIdKey = "dead_letter_id"
+
+// DeadLetterTopicKey is the key to the name of the dead-letter topic
+const DeadLetterTopicKey = "dead_letter_topic"
+
+// SchemaVersionKey is the key to the version of the chs-delta schema messages are published with
+const SchemaVersionKey = "schema_version"
+
+// SchemaIdKey is the key to the schema registry id of the chs-delta schema messages are published with
+const SchemaIdKey = "schema_id"
+
+// ShutdownDelayKey is the key to how long the service waits after failing the healthcheck before it stops accepting requests
+const ShutdownDelayKey = "shutdown_delay"
+
+// DeltaTypeKey is the key for the name of a delta, e.g. officer-delta
+const DeltaTypeKey = "delta_type"
+
 // PartitionKey is the key to get the partition number of the topic
 const PartitionKey = "partition"
 
@@ -17,3 +59,12 @@ const OffsetKey = "offset"
 
 // MessageKey is the key for the error message
 const MessageKey = "message"
+
+// MaxBodyBytesKey is the key for the largest request body a route accepts
+const MaxBodyBytesKey = "max_body_bytes"
+
+// MaxMessageBytesKey is the key for the largest message the producer sends
+const MaxMessageBytesKey = "max_message_bytes"
+
+// KafkaVersionKey is the key for the Kafka protocol version the producer uses
+const KafkaVersionKey = "kafka_version"
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// registerDeadLetters registers the admin endpoints used to list and re-drive dead-lettered deltas, if the Kafka
+// service keeps them in a local store.
+func registerDeadLetters(appRouter *mux.Router, kSvc services.KafkaService) {
+
+	d, ok := kSvc.(services.DeadLetterStorer)
+	if !ok || d.DeadLetterStore() == nil {
+		return
+	}
+	store := d.DeadLetterStore()
+
+	appRouter.HandleFunc("/chs-delta-api/admin/dead-letters", listDeadLetters(store)).Methods(http.MethodGet).Name("dead-letters")
+	appRouter.HandleFunc("/chs-delta-api/admin/dead-letters/{id}/redrive", redriveDeadLetter(store, kSvc)).Methods(http.MethodPost).Name("dead-letter-redrive")
+}
+
+// listDeadLetters returns a handler which lists the dead-lettered deltas as JSON, oldest first.
+func listDeadLetters(store services.DeadLetterStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deadLetters, err := store.List()
+		if err != nil {
+			log.Error(err, log.Data{config.MessageKey: "error listing dead letters"})
+			writeError(w, r, requestId(r), http.StatusInternalServerError, "error listing dead letters")
+			return
+		}
+		writeJSON(w, http.StatusOK, deadLetters)
+	}
+}
+
+// redriveDeadLetter returns a handler which sends a dead-lettered delta to its original topic again, removing it from
+// the store once sent. If it fails again and is dead-lettered afresh, the original is removed and the new id returned.
+func redriveDeadLetter(store services.DeadLetterStore, kSvc services.KafkaService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		id := mux.Vars(r)["id"]
+		dl, err := store.Get(id)
+		if errors.Is(err, services.ErrDeadLetterNotFound) {
+			writeError(w, r, requestId(r), http.StatusNotFound, "dead letter "+id+" not found")
+			return
+		} else if err != nil {
+			log.Error(err, log.Data{config.DeadLetterIdKey: id, config.MessageKey: "error reading dead letter"})
+			writeError(w, r, requestId(r), http.StatusInternalServerError, "error reading dead letter")
+			return
+		}
+
+		contextId := dl.Meta.ContextId
+		sendErr := kSvc.SendMessage(dl.Topic, dl.Data, dl.Meta)
+
+		var dlErr *services.DeadLetteredError
+		var openErr *services.CircuitOpenError
+		switch {
+		case errors.As(sendErr, &openErr):
+			writeServiceUnavailable(w, r, requestId(r), openErr.RetryAfter, "kafka is unavailable, try again later")
+			return
+		case sendErr != nil && !errors.As(sendErr, &dlErr):
+			log.ErrorC(contextId, sendErr, log.Data{config.DeadLetterIdKey: id, config.MessageKey: "error re-driving dead letter"})
+			writeError(w, r, requestId(r), http.StatusBadGateway, "error re-driving dead letter "+id)
+			return
+		}
+
+		if err := store.Delete(id); err != nil {
+			log.ErrorC(contextId, err, log.Data{config.DeadLetterIdKey: id, config.MessageKey: "error removing re-driven dead letter"})
+		}
+
+		if dlErr != nil {
+			log.ErrorC(contextId, sendErr, log.Data{config.DeadLetterIdKey: id, config.MessageKey: "re-driven dead letter failed again"})
+			writeErrorWithValues(w, r, requestId(r), http.StatusBadGateway, dlErr.Err.Error(), map[string]interface{}{"dead_letter_id": dlErr.Id})
+			return
+		}
+
+		log.InfoC(contextId, "Re-drove dead letter", log.Data{config.DeadLetterIdKey: id, config.TopicKey: dl.Topic})
+		w.WriteHeader(http.StatusOK)
+	}
+}
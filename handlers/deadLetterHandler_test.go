@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/companieshouse/chs-delta-api/services/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const deadLetterId = "1700000000000000000-1"
+
+// stubDeadLetterStore holds dead letters in memory.
+type stubDeadLetterStore map[string]services.DeadLetter
+
+func (s stubDeadLetterStore) List() ([]services.DeadLetter, error) {
+	deadLetters := make([]services.DeadLetter, 0, len(s))
+	for _, dl := range s {
+		deadLetters = append(deadLetters, dl)
+	}
+	return deadLetters, nil
+}
+
+func (s stubDeadLetterStore) Get(id string) (services.DeadLetter, error) {
+	dl, ok := s[id]
+	if !ok {
+		return dl, services.ErrDeadLetterNotFound
+	}
+	return dl, nil
+}
+
+func (s stubDeadLetterStore) Delete(id string) error {
+	if _, ok := s[id]; !ok {
+		return services.ErrDeadLetterNotFound
+	}
+	delete(s, id)
+	return nil
+}
+
+// redrive calls the re-drive endpoint for the given id.
+func redrive(store services.DeadLetterStore, kSvc services.KafkaService, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/chs-delta-api/admin/dead-letters/"+id+"/redrive", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	redriveDeadLetter(store, kSvc)(w, req)
+	return w
+}
+
+// TestUnitListDeadLetters asserts that the dead letters endpoint lists the dead letters as JSON.
+func TestUnitListDeadLetters(t *testing.T) {
+	Convey("When I call the dead letters endpoint, then I am given the dead letters", t, func() {
+		store := stubDeadLetterStore{deadLetterId: {Id: deadLetterId, Topic: topic, Data: "{}", Error: "boom", Attempts: 3}}
+		w := httptest.NewRecorder()
+		listDeadLetters(store)(w, nil)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(w.Body.String(), ShouldContainSubstring, `"id":"`+deadLetterId+`"`)
+		So(w.Body.String(), ShouldContainSubstring, `"error":"boom"`)
+	})
+}
+
+// TestUnitRedriveDeadLetter asserts that dead letters are sent to their original topic and removed once sent.
+func TestUnitRedriveDeadLetter(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a dead-lettered delta", t, func() {
+		meta := models.DeltaMetadata{ContextId: contextId, PrimaryIds: []string{"1"}}
+		store := stubDeadLetterStore{deadLetterId: {Id: deadLetterId, Topic: topic, Data: "{}", Meta: meta}}
+		kSvc := mocks.NewMockKafkaService(mockCtrl)
+
+		Convey("When it is re-driven successfully, then 200 is returned and it is removed", func() {
+			kSvc.EXPECT().SendMessage(topic, "{}", meta).Return(nil)
+			w := redrive(store, kSvc, deadLetterId)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(store, ShouldBeEmpty)
+		})
+
+		Convey("When it doesn't exist, then 404 is returned", func() {
+			w := redrive(store, kSvc, "2-2")
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When Kafka is failing, then 503 is returned and it is kept", func() {
+			kSvc.EXPECT().SendMessage(topic, "{}", meta).Return(&services.CircuitOpenError{RetryAfter: 1500 * time.Millisecond})
+			w := redrive(store, kSvc, deadLetterId)
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "2")
+			So(store, ShouldContainKey, deadLetterId)
+		})
+
+		Convey("When sending fails without being dead-lettered, then 502 is returned and it is kept", func() {
+			kSvc.EXPECT().SendMessage(topic, "{}", meta).Return(errors.New("error sending"))
+			w := redrive(store, kSvc, deadLetterId)
+			So(w.Code, ShouldEqual, http.StatusBadGateway)
+			So(w.Body.String(), ShouldContainSubstring, `"error_code":"bad_gateway"`)
+			So(store, ShouldContainKey, deadLetterId)
+		})
+
+		Convey("When it is dead-lettered again, then 502 is returned with the new id and the original is removed", func() {
+			kSvc.EXPECT().SendMessage(topic, "{}", meta).Return(&services.DeadLetteredError{Id: "2-2", Err: sarama.ErrMessageSizeTooLarge})
+			w := redrive(store, kSvc, deadLetterId)
+			So(w.Code, ShouldEqual, http.StatusBadGateway)
+			So(w.Body.String(), ShouldContainSubstring, `"error_code":"bad_gateway"`)
+			So(w.Body.String(), ShouldContainSubstring, `"dead_letter_id":"2-2"`)
+			So(store, ShouldNotContainKey, deadLetterId)
+		})
+	})
+}
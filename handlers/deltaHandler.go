@@ -5,13 +5,25 @@ import (
 	"fmt"
 	"github.com/companieshouse/chs-delta-api/config"
 	"github.com/companieshouse/chs-delta-api/helpers"
+	"github.com/companieshouse/chs-delta-api/models"
 	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/companieshouse/chs-delta-api/tracing"
 	"github.com/companieshouse/chs-delta-api/validation"
 	"github.com/companieshouse/chs.go/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
-	"regexp"
+	"time"
 )
 
+// attributeRoute is the span attribute holding the name labelling a delta route's metrics.
+const attributeRoute = "chs_delta.route"
+
+// DefaultMaxBodyBytes is the largest request body accepted by delta routes when no limit is configured.
+const DefaultMaxBodyBytes = 1 << 20
+
 // DeltaHandler offers a handler by which to publish a chs-delta onto the a chosen delta kafka topic.
 type DeltaHandler struct {
 	kSvc             services.KafkaService
@@ -21,12 +33,15 @@ type DeltaHandler struct {
 	doValidationOnly bool
 	isDelete         bool
 	topic            string
-	primaryId        string
+	primaryId        helpers.IdPath
+	deltaType        string
+	route            string
+	maxBodyBytes     int64
 }
 
 // NewDeltaHandler returns an DeltaHandler.
 func NewDeltaHandler(kSvc services.KafkaService, h helpers.Helper, chv validation.CHValidator,
-	cfg *config.Config, doValidationOnly bool, isDelete bool, topic string, primaryId string) *DeltaHandler {
+	cfg *config.Config, doValidationOnly bool, isDelete bool, topic string, primaryId helpers.IdPath, deltaType string) *DeltaHandler {
 	return &DeltaHandler{
 		kSvc:             kSvc,
 		h:                h,
@@ -36,6 +51,7 @@ func NewDeltaHandler(kSvc services.KafkaService, h helpers.Helper, chv validatio
 		isDelete:         isDelete,
 		topic:            topic,
 		primaryId:        primaryId,
+		deltaType:        deltaType,
 	}
 }
 
@@ -58,17 +74,60 @@ func NewDeltaHandlerValidate(kSvc services.KafkaService, h helpers.Helper, chv v
 // encountered then they will be returned via the ResponseWriter.
 func (kp *DeltaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	// Every delta's metrics are labelled with the name of its route.
+	receivedAt := time.Now()
+	requestsInFlight.WithLabelValues(kp.route).Inc()
+	defer func() {
+		requestsInFlight.WithLabelValues(kp.route).Dec()
+		requestDuration.WithLabelValues(kp.route).Observe(time.Since(receivedAt).Seconds())
+	}()
+	deltasReceived.WithLabelValues(kp.route).Inc()
+	if r.ContentLength >= 0 {
+		payloadSize.WithLabelValues(kp.route).Observe(float64(r.ContentLength))
+	}
+
+	// Trace the request as a child of the caller's span, if it sent a traceparent header.
+	ctx, span := tracing.Tracer().Start(tracing.Extract(r.Context(), r.Header), http.MethodPost+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPRequestMethodPost, semconv.HTTPRoute(r.URL.Path), attribute.String(attributeRoute, kp.route)))
+	defer span.End()
+
 	contextId := kp.h.GetRequestIdFromHeader(r)
 	startMsg := fmt.Sprintf("Starting delta process for: %s", r.URL.Path)
 	log.InfoC(contextId, startMsg, log.Data{"request_id": contextId})
 
-	// Validate against the openAPI 3 spec before progressing any further.
-	errValidation, err := kp.chv.ValidateRequestAgainstOpenApiSpec(r, contextId)
+	// Read the body once, refusing bodies over the route's limit, so every step which follows shares it.
+	body, err := kp.readBody(w, r, contextId)
+	if err != nil {
+		tracing.RecordError(span, err)
+		log.ErrorC(contextId, err, log.Data{config.MaxBodyBytesKey: kp.maxBodyBytes, config.MessageKey: "error getting data from request"})
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, contextId, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not be larger than %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, r, contextId, http.StatusInternalServerError, "error reading request body")
+		return
+	}
+
+	// Validate against the openAPI 3 spec before progressing any further, noting the version of the spec in use.
+	specVersion := kp.chv.GetSpecVersion()
+	validationStart := time.Now()
+	_, validationSpan := tracing.Tracer().Start(ctx, "openapi validation")
+	errValidation, err := kp.chv.ValidateDelta(r, body, contextId)
+	validationDuration.WithLabelValues(kp.route).Observe(time.Since(validationStart).Seconds())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		tracing.RecordError(validationSpan, err)
+		validationSpan.End()
+		tracing.RecordError(span, err)
 		log.ErrorC(contextId, err, log.Data{config.MessageKey: "error occurred while trying to validate request"})
+		status, message := validationErrorStatus(err)
+		writeError(w, r, contextId, status, message)
 		return
 	} else if errValidation != nil {
+		deltasValidationFailed.WithLabelValues(kp.route).Inc()
+		validationSpan.SetStatus(codes.Error, "delta failed validation")
+		validationSpan.End()
 		w.WriteHeader(http.StatusBadRequest)
 		_, err = w.Write(errValidation)
 		if err != nil {
@@ -77,39 +136,112 @@ func (kp *DeltaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
+	validationSpan.End()
 
 	// We only send to Kafka if doValidationOnly is false.
 	if !kp.doValidationOnly {
-		// Get request body and marshal into a string, ready for publishing.
-		data, err := kp.h.GetDataFromRequest(r, contextId)
-		if err != nil {
-			log.ErrorC(contextId, err, log.Data{config.MessageKey: "error getting data from request"})
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
 		deltaMsg := "processing delta"
 		if kp.isDelete == true {
 			deltaMsg = "processing delete delta"
 		}
 
-		regex := regexp.MustCompile(fmt.Sprintf("(?m)%s\"\\s*:\\s*\"([a-zA-Z0-9_-]+)\"", kp.primaryId))
-		if regex.MatchString(data) {
-			group := regex.FindStringSubmatch(data)[1]
-			log.InfoC(contextId, deltaMsg, log.Data{"request_id": contextId, kp.primaryId: group})
+		// Every primary id is published as a header, and the first is used as the message key so deltas for the same
+		// entity are kept in order.
+		ids, err := kp.primaryId.ExtractBody(body)
+		if err != nil {
+			log.ErrorC(contextId, err, log.Data{"request_id": contextId, config.PrimaryIdPathKey: kp.primaryId.String()})
 		} else {
-			log.ErrorC(contextId, errors.New("failed to match regex"), log.Data{"request_id": contextId})
+			log.InfoC(contextId, deltaMsg, log.Data{"request_id": contextId, config.PrimaryIdsKey: ids})
+			span.SetAttributes(attribute.StringSlice(services.AttributePrimaryIds, ids))
+		}
+
+		meta := models.DeltaMetadata{
+			ContextId:   contextId,
+			DeltaType:   kp.deltaType,
+			IsDelete:    kp.isDelete,
+			PrimaryIds:  ids,
+			SpecVersion: specVersion,
+			ReceivedAt:  receivedAt,
 		}
+		meta.TraceParent, meta.TraceState = tracing.TraceContext(ctx)
 
-		// Send data string to Kafka service for publishing.
-		if err := kp.kSvc.SendMessage(kp.topic, data, contextId, kp.isDelete); err != nil {
+		// Send the body, as normalised by validation, to Kafka service for publishing.
+		sendStart := time.Now()
+		err = kp.kSvc.SendMessage(kp.topic, body.String(), meta)
+		kafkaSendDuration.WithLabelValues(kp.route).Observe(time.Since(sendStart).Seconds())
+		if err != nil {
+			deltasPublishFailed.WithLabelValues(kp.route).Inc()
+			tracing.RecordError(span, err)
 			log.ErrorC(contextId, err, log.Data{config.TopicKey: kp.topic, config.MessageKey: "error sending the message to the given kafka topic"})
-			w.WriteHeader(http.StatusInternalServerError)
+
+			// Kafka keeps failing, so tell the caller when it's worth trying again.
+			var openErr *services.CircuitOpenError
+			if errors.As(err, &openErr) {
+				writeServiceUnavailable(w, r, contextId, openErr.RetryAfter, "kafka is unavailable, try again later")
+				return
+			}
+
+			// The outbox has no room until Kafka catches up, so tell the caller to slow down.
+			if errors.Is(err, services.ErrOutboxFull) {
+				writeServiceUnavailable(w, r, contextId, services.OutboxFullRetryAfter, "outbox is full, try again later")
+				return
+			}
+
+			// Kafka would never accept the delta, so the caller needs to send it in smaller parts.
+			if errors.Is(err, services.ErrMessageTooLarge) {
+				writeError(w, r, contextId, http.StatusRequestEntityTooLarge, "delta is larger than the largest message Kafka accepts")
+				return
+			}
+
+			// The delta has been parked as a dead letter, so it can be re-driven rather than sent again.
+			var dlErr *services.DeadLetteredError
+			if errors.As(err, &dlErr) {
+				writeErrorWithValues(w, r, contextId, http.StatusBadGateway, "delta could not be published and was dead-lettered", map[string]interface{}{"dead_letter_id": dlErr.Id})
+				return
+			}
+
+			writeError(w, r, contextId, http.StatusInternalServerError, "error publishing delta")
 
 			return
 		}
+		deltasPublished.WithLabelValues(kp.route).Inc()
 	}
 
 	log.InfoC(contextId, "Successfully processed delta", nil)
 	w.WriteHeader(http.StatusOK)
 }
+
+// readBody reads the request body once, so it can be shared by validation, primary id extraction and publishing. An
+// *http.MaxBytesError is returned if it is larger than the route's limit, and bodies which declare a length over the
+// limit aren't read at all. Routes without a limit read the whole body.
+func (kp *DeltaHandler) readBody(w http.ResponseWriter, r *http.Request, contextId string) (*helpers.DeltaBody, error) {
+
+	if kp.maxBodyBytes > 0 {
+		if r.ContentLength > kp.maxBodyBytes {
+			return nil, &http.MaxBytesError{Limit: kp.maxBodyBytes}
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, kp.maxBodyBytes)
+	}
+
+	data, err := kp.h.GetDataFromRequest(r, contextId)
+	if err != nil {
+		return nil, err
+	}
+
+	return helpers.NewDeltaBody(data), nil
+}
+
+// validationErrorStatus returns the status and message of the response to a request which couldn't be validated. Requests
+// the spec doesn't declare how to validate are the caller's fault, anything else is the service's.
+func validationErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, validation.ErrRouteNotInSpec):
+		return http.StatusNotFound, "route is not declared in the Open API spec"
+	case errors.Is(err, validation.ErrMethodNotInSpec):
+		return http.StatusMethodNotAllowed, "method is not declared in the Open API spec"
+	case errors.Is(err, validation.ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType, "Content-Type must be application/json"
+	default:
+		return http.StatusInternalServerError, "error validating request"
+	}
+}
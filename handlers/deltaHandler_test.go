@@ -2,16 +2,28 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/helpers"
 	hMocks "github.com/companieshouse/chs-delta-api/helpers/mocks"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs-delta-api/services"
 	sMocks "github.com/companieshouse/chs-delta-api/services/mocks"
+	"github.com/companieshouse/chs-delta-api/validation"
 	chvMocks "github.com/companieshouse/chs-delta-api/validation/mocks"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 const (
@@ -22,9 +34,13 @@ const (
 	endPoint         = "/delta/delta"
 	doValidationOnly = true
 	isDelete         = false
-	primaryId        = "primaryId"
+	primaryId        = "$.primaryId"
+	deltaType        = "delta-type"
+	specVersion      = "spec-version"
 )
 
+var primaryIdPath, _ = helpers.ParseIdPath(primaryId)
+
 // TestUnitNewDeltaHandler asserts that the constructor for the DeltaHandler returns a fully configured handler.
 func TestUnitNewDeltaHandler(t *testing.T) {
 
@@ -42,7 +58,7 @@ func TestUnitNewDeltaHandler(t *testing.T) {
 		}
 		cfg, _ := config.Get()
 
-		deltaHandler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryId)
+		deltaHandler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
 
 		So(deltaHandler, ShouldNotBeNil)
 
@@ -80,9 +96,8 @@ func TestUnitDeltaHandlerFailsRequestBodyRetrieval(t *testing.T) {
 			}
 			cfg, _ := config.Get()
 
-			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryId)
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
 
-			chv.EXPECT().ValidateRequestAgainstOpenApiSpec(req, contextId).Return(nil, nil)
 			h.EXPECT().GetDataFromRequest(req, contextId).Return("", errors.New("error converting request body"))
 			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
 
@@ -118,18 +133,139 @@ func TestUnitDeltaHandlerSuccessfullySends(t *testing.T) {
 			}
 			cfg, _ := config.Get()
 
-			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryId)
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
 
-			chv.EXPECT().ValidateRequestAgainstOpenApiSpec(req, contextId).Return(nil, nil)
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
 			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
 			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
-			svc.EXPECT().SendMessage(handler.topic, requestBody, contextId, false).Return(nil)
+			svc.EXPECT().SendMessage(handler.topic, requestBody, gomock.Any()).Return(nil)
+
+			handler.ServeHTTP(resp, req)
+
+			Convey("Then the response should be 200", func() {
+				So(resp.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+// TestUnitDeltaHandlerSendsMetadata asserts that the primary ids found in the request body are sent along with the rest
+// of the delta's metadata.
+func TestUnitDeltaHandlerSendsMetadata(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a HTTP POST request containing the primary id via the delta endpoint", t, func() {
+
+		body := `{"primaryId" : "id-123", "dummy" : "request"}`
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(body)))
+		resp := httptest.NewRecorder()
+
+		Convey("When the request is handled by the router", func() {
+
+			h := hMocks.NewMockHelper(mockCtrl)
+			svc := sMocks.NewMockKafkaService(mockCtrl)
+			chv := chvMocks.NewMockCHValidator(mockCtrl)
+
+			config.CallValidateConfig = func(cfg *config.Config) error {
+				return nil
+			}
+			cfg, _ := config.Get()
+
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(body, nil)
+			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
+			var meta models.DeltaMetadata
+			svc.EXPECT().SendMessage(handler.topic, body, gomock.Any()).DoAndReturn(func(topic, data string, m models.DeltaMetadata) error {
+				meta = m
+				return nil
+			})
 
+			before := time.Now()
 			handler.ServeHTTP(resp, req)
 
 			Convey("Then the response should be 200", func() {
 				So(resp.Code, ShouldEqual, http.StatusOK)
 			})
+
+			Convey("Then the metadata describes the delta", func() {
+				So(meta.ContextId, ShouldEqual, contextId)
+				So(meta.DeltaType, ShouldEqual, deltaType)
+				So(meta.IsDelete, ShouldEqual, isDelete)
+				So(meta.PrimaryIds, ShouldResemble, []string{"id-123"})
+				So(meta.SpecVersion, ShouldEqual, specVersion)
+				So(meta.ReceivedAt, ShouldHappenOnOrBetween, before, time.Now())
+			})
+		})
+	})
+}
+
+// TestUnitDeltaHandlerTracesRequest asserts that requests are traced as children of the caller's span, and that the
+// request's trace context is passed on to the Kafka service.
+func TestUnitDeltaHandlerTracesRequest(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	Convey("Given a HTTP POST request with a traceparent header via the delta endpoint", t, func() {
+
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		resp := httptest.NewRecorder()
+
+		Convey("When the request is handled by the router", func() {
+
+			h := hMocks.NewMockHelper(mockCtrl)
+			svc := sMocks.NewMockKafkaService(mockCtrl)
+			chv := chvMocks.NewMockCHValidator(mockCtrl)
+
+			config.CallValidateConfig = func(cfg *config.Config) error {
+				return nil
+			}
+			cfg, _ := config.Get()
+
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
+			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
+			var meta models.DeltaMetadata
+			svc.EXPECT().SendMessage(handler.topic, requestBody, gomock.Any()).DoAndReturn(func(topic, data string, m models.DeltaMetadata) error {
+				meta = m
+				return nil
+			})
+
+			handler.ServeHTTP(resp, req)
+
+			// The validation span ends before the request's.
+			spans := recorder.Ended()
+			validation, request := spans[len(spans)-2], spans[len(spans)-1]
+
+			Convey("Then the request is traced as a child of the caller's span", func() {
+				So(request.Name(), ShouldEqual, "POST "+endPoint)
+				So(request.SpanKind(), ShouldEqual, trace.SpanKindServer)
+				So(request.Parent().TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+				So(request.Parent().SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+			})
+
+			Convey("Then validation is traced as a child of the request", func() {
+				So(validation.Name(), ShouldEqual, "openapi validation")
+				So(validation.Parent().SpanID(), ShouldEqual, request.SpanContext().SpanID())
+			})
+
+			Convey("Then the request's trace context is passed on with the delta", func() {
+				So(meta.TraceParent, ShouldEqual, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+request.SpanContext().SpanID().String()+"-01")
+			})
 		})
 	})
 }
@@ -156,12 +292,13 @@ func TestUnitDeltaHandlerFailsSend(t *testing.T) {
 			}
 			cfg, _ := config.Get()
 
-			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryId)
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
 
-			chv.EXPECT().ValidateRequestAgainstOpenApiSpec(req, contextId).Return(nil, nil)
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
 			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
 			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
-			svc.EXPECT().SendMessage(handler.topic, requestBody, contextId, false).Return(errors.New("error sending message"))
+			svc.EXPECT().SendMessage(handler.topic, requestBody, gomock.Any()).Return(errors.New("error sending message"))
 
 			handler.ServeHTTP(resp, req)
 
@@ -172,6 +309,228 @@ func TestUnitDeltaHandlerFailsSend(t *testing.T) {
 	})
 }
 
+// TestUnitDeltaHandlerDeadLettered asserts that the DeltaHandler returns 502 with the id of the dead letter when a
+// delta which couldn't be published was dead-lettered, so it can be told apart from one which was lost.
+func TestUnitDeltaHandlerDeadLettered(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a HTTP POST request via the delta endpoint", t, func() {
+
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		resp := httptest.NewRecorder()
+
+		Convey("When the request is handled by the router, but the delta is dead-lettered", func() {
+
+			h := hMocks.NewMockHelper(mockCtrl)
+			svc := sMocks.NewMockKafkaService(mockCtrl)
+			chv := chvMocks.NewMockCHValidator(mockCtrl)
+
+			config.CallValidateConfig = func(cfg *config.Config) error {
+				return nil
+			}
+			cfg, _ := config.Get()
+
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
+			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
+			svc.EXPECT().SendMessage(handler.topic, requestBody, gomock.Any()).Return(&services.DeadLetteredError{Id: "1-1", Err: errors.New("error sending message")})
+
+			handler.ServeHTTP(resp, req)
+
+			Convey("Then the response should be 502 with the id of the dead letter", func() {
+				So(resp.Code, ShouldEqual, http.StatusBadGateway)
+
+				var body models.ErrorResponse
+				So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+				So(body.Errors, ShouldHaveLength, 1)
+				So(body.Errors[0].ErrorCode, ShouldEqual, "bad_gateway")
+				So(body.Errors[0].ErrorValues, ShouldResemble, map[string]interface{}{"dead_letter_id": "1-1"})
+			})
+		})
+	})
+}
+
+// TestUnitDeltaHandlerCircuitOpen asserts that the DeltaHandler returns 503 with a Retry-After header when the Kafka
+// circuit breaker is open.
+func TestUnitDeltaHandlerCircuitOpen(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a HTTP POST request via the delta endpoint", t, func() {
+
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		resp := httptest.NewRecorder()
+
+		Convey("When the request is handled by the router, but the Kafka circuit breaker is open", func() {
+
+			h := hMocks.NewMockHelper(mockCtrl)
+			svc := sMocks.NewMockKafkaService(mockCtrl)
+			chv := chvMocks.NewMockCHValidator(mockCtrl)
+
+			config.CallValidateConfig = func(cfg *config.Config) error {
+				return nil
+			}
+			cfg, _ := config.Get()
+
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
+			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
+			svc.EXPECT().SendMessage(handler.topic, requestBody, gomock.Any()).Return(&services.CircuitOpenError{RetryAfter: 2500 * time.Millisecond})
+
+			handler.ServeHTTP(resp, req)
+
+			Convey("Then the response should be 503 with the seconds until the breaker lets a send through", func() {
+				So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(resp.Header().Get("Retry-After"), ShouldEqual, "3")
+				So(resp.Body.String(), ShouldContainSubstring, `"request_id":"`+contextId+`"`)
+				So(resp.Body.String(), ShouldContainSubstring, `"error_code":"service_unavailable"`)
+			})
+		})
+	})
+}
+
+// TestUnitDeltaHandlerOutboxFull asserts that the DeltaHandler returns 503 with a Retry-After header when the outbox
+// has no room for the delta.
+func TestUnitDeltaHandlerOutboxFull(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a HTTP POST request via the delta endpoint", t, func() {
+
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		resp := httptest.NewRecorder()
+
+		Convey("When the request is handled by the router, but the outbox is full", func() {
+
+			h := hMocks.NewMockHelper(mockCtrl)
+			svc := sMocks.NewMockKafkaService(mockCtrl)
+			chv := chvMocks.NewMockCHValidator(mockCtrl)
+
+			config.CallValidateConfig = func(cfg *config.Config) error {
+				return nil
+			}
+			cfg, _ := config.Get()
+
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
+			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
+			svc.EXPECT().SendMessage(handler.topic, requestBody, gomock.Any()).Return(services.ErrOutboxFull)
+
+			handler.ServeHTTP(resp, req)
+
+			Convey("Then the response should be 503 with the seconds to wait before sending it again", func() {
+				So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(resp.Header().Get("Retry-After"), ShouldEqual, "30")
+				So(resp.Body.String(), ShouldContainSubstring, `"request_id":"`+contextId+`"`)
+				So(resp.Body.String(), ShouldContainSubstring, `"error_code":"service_unavailable"`)
+			})
+		})
+	})
+}
+
+// TestUnitDeltaHandlerMessageTooLarge asserts that the DeltaHandler returns 413 when a delta is too large for Kafka.
+func TestUnitDeltaHandlerMessageTooLarge(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a HTTP POST request via the delta endpoint", t, func() {
+
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		resp := httptest.NewRecorder()
+
+		Convey("When the request is handled by the router, but the delta is larger than Kafka accepts", func() {
+
+			h := hMocks.NewMockHelper(mockCtrl)
+			svc := sMocks.NewMockKafkaService(mockCtrl)
+			chv := chvMocks.NewMockCHValidator(mockCtrl)
+
+			handler := NewDeltaHandler(svc, h, chv, &config.Config{}, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
+			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
+			svc.EXPECT().SendMessage(handler.topic, requestBody, gomock.Any()).Return(fmt.Errorf("%w: 1000001 bytes", services.ErrMessageTooLarge))
+
+			handler.ServeHTTP(resp, req)
+
+			Convey("Then the response should be 413", func() {
+				So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+				So(resp.Body.String(), ShouldContainSubstring, `"error_code":"payload_too_large"`)
+			})
+		})
+	})
+}
+
+// TestUnitDeltaHandlerLimitsBody asserts that request bodies over the route's limit are refused with 413 before they
+// are validated, and that bodies within it can still be read.
+func TestUnitDeltaHandlerLimitsBody(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a handler for a route which accepts bodies of up to 10 bytes", t, func() {
+
+		chv := chvMocks.NewMockCHValidator(mockCtrl)
+		handler := NewDeltaHandler(sMocks.NewMockKafkaService(mockCtrl), helpers.NewHelper(), chv, &config.Config{}, doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+		handler.maxBodyBytes = 10
+		resp := httptest.NewRecorder()
+
+		Convey("When a request declares a longer body, then it is refused without being validated", func() {
+			req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+			req.Header.Set("X-Request-Id", contextId)
+
+			handler.ServeHTTP(resp, req)
+
+			So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+			var body models.ErrorResponse
+			So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Errors, ShouldHaveLength, 1)
+			So(body.Errors[0].ErrorCode, ShouldEqual, "payload_too_large")
+			So(body.Errors[0].Error, ShouldEqual, "request body must not be larger than 10 bytes")
+		})
+
+		Convey("When a request of unknown length has a longer body, then it is refused without being validated", func() {
+			req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+			req.ContentLength = -1
+
+			handler.ServeHTTP(resp, req)
+
+			So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("When a request has a body within the limit, then it is validated with the whole body", func() {
+			req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(`{"a":"b"}`)))
+			req.Header.Set("X-Request-Id", contextId)
+			req.ContentLength = -1
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).DoAndReturn(func(_ *http.Request, body *helpers.DeltaBody, _ string) ([]byte, error) {
+				So(body.String(), ShouldEqual, `{"a":"b"}`)
+				return nil, nil
+			})
+
+			handler.ServeHTTP(resp, req)
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
 // TestUnitDeltaHandlerErrorsCallingValidation asserts that the DeltaHandler returns an internal error status when
 // call to validate the request fails (internal failure such as failure to open schema, not a user validation failure).
 func TestUnitDeltaHandlerErrorsCallingValidation(t *testing.T) {
@@ -195,20 +554,73 @@ func TestUnitDeltaHandlerErrorsCallingValidation(t *testing.T) {
 			}
 			cfg, _ := config.Get()
 
-			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryId)
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
 
-			chv.EXPECT().ValidateRequestAgainstOpenApiSpec(req, contextId).Return(nil, errors.New("error"))
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, errors.New("error"))
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
 			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
 
 			handler.ServeHTTP(resp, req)
 
 			Convey("Then the response should be 500 and an error returned", func() {
 				So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+
+				var body models.ErrorResponse
+				So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+				So(body.RequestId, ShouldEqual, contextId)
+				So(body.Errors, ShouldHaveLength, 1)
+				So(body.Errors[0].ErrorCode, ShouldEqual, "internal_error")
+				So(body.Errors[0].Location, ShouldEqual, endPoint)
 			})
 		})
 	})
 }
 
+// TestUnitDeltaHandlerRequestNotInSpec asserts that requests the spec doesn't declare how to validate are rejected with
+// a client error, rather than a 500.
+func TestUnitDeltaHandlerRequestNotInSpec(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("%w: %s", validation.ErrRouteNotInSpec, endPoint), http.StatusNotFound, "not_found"},
+		{fmt.Errorf("%w: POST %s", validation.ErrMethodNotInSpec, endPoint), http.StatusMethodNotAllowed, "method_not_allowed"},
+		{fmt.Errorf("%w: %q", validation.ErrUnsupportedMediaType, "text/plain"), http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	}
+
+	for _, c := range cases {
+		Convey(fmt.Sprintf("Given the validator can't validate a request because %v", c.err), t, func() {
+
+			req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+			resp := httptest.NewRecorder()
+
+			h := hMocks.NewMockHelper(mockCtrl)
+			chv := chvMocks.NewMockCHValidator(mockCtrl)
+			handler := NewDeltaHandler(sMocks.NewMockKafkaService(mockCtrl), h, chv, &config.Config{}, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, c.err)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
+			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
+
+			handler.ServeHTTP(resp, req)
+
+			Convey(fmt.Sprintf("Then the response should be %d with an error body", c.status), func() {
+				So(resp.Code, ShouldEqual, c.status)
+				So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json")
+				So(resp.Body.String(), ShouldContainSubstring, `"request_id":"`+contextId+`"`)
+				So(resp.Body.String(), ShouldContainSubstring, `"error_code":"`+c.code+`"`)
+			})
+		})
+	}
+}
+
 // TestUnitDeltaHandlerFailsValidation asserts that the DeltaHandler returns a bad request status when validation fails
 func TestUnitDeltaHandlerFailsValidation(t *testing.T) {
 
@@ -231,10 +643,12 @@ func TestUnitDeltaHandlerFailsValidation(t *testing.T) {
 			}
 			cfg, _ := config.Get()
 
-			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryId)
+			handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
 
 			errBytes := []byte("error string")
-			chv.EXPECT().ValidateRequestAgainstOpenApiSpec(req, contextId).Return(errBytes, nil)
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(errBytes, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
 			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
 
 			handler.ServeHTTP(resp, req)
@@ -268,12 +682,13 @@ func TestUnitDeltaHandlerValidatesOnly(t *testing.T) {
 			}
 			cfg, _ := config.Get()
 
-			handler := NewDeltaHandler(svc, h, chv, cfg, doValidationOnly, isDelete, topic, primaryId)
+			handler := NewDeltaHandler(svc, h, chv, cfg, doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
 
 			h.EXPECT().GetRequestIdFromHeader(req).Return(contextId)
-			chv.EXPECT().ValidateRequestAgainstOpenApiSpec(req, contextId).Return(nil, nil)
-			h.EXPECT().GetDataFromRequest(req, contextId).Times(0)
-			svc.EXPECT().SendMessage(handler.topic, requestBody, contextId, false).Times(0)
+			chv.EXPECT().GetSpecVersion().Return(specVersion)
+			chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+			h.EXPECT().GetDataFromRequest(req, contextId).Return(requestBody, nil)
+			svc.EXPECT().SendMessage(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 			handler.ServeHTTP(resp, req)
 
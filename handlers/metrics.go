@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// routeLabel is the label given to every delta metric, holding the name the delta's route is registered with in
+// Register, such as officer-delta or officer-delta-validate.
+const routeLabel = "route"
+
+// sizeBuckets are the upper bounds, in bytes, of histogram buckets suited to payload sizes, from 256B to 16MiB.
+var sizeBuckets = prometheus.ExponentialBuckets(256, 4, 9)
+
+// Metrics recorded for every delta route.
+var (
+	deltasReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chs_delta_api_deltas_received_total",
+		Help: "Deltas received.",
+	}, []string{routeLabel})
+	deltasValidationFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chs_delta_api_deltas_validation_failed_total",
+		Help: "Deltas rejected for failing validation against the OpenAPI spec.",
+	}, []string{routeLabel})
+	deltasPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chs_delta_api_deltas_published_total",
+		Help: "Deltas published to Kafka.",
+	}, []string{routeLabel})
+	deltasPublishFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chs_delta_api_deltas_publish_failed_total",
+		Help: "Deltas which couldn't be published to Kafka.",
+	}, []string{routeLabel})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chs_delta_api_request_duration_seconds",
+		Help:    "Time taken to handle a delta request.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{routeLabel})
+	validationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chs_delta_api_validation_duration_seconds",
+		Help:    "Time taken to validate a delta against the OpenAPI spec.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{routeLabel})
+	kafkaSendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chs_delta_api_kafka_send_duration_seconds",
+		Help:    "Time taken to send a delta to Kafka.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{routeLabel})
+	payloadSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chs_delta_api_payload_size_bytes",
+		Help:    "Size of delta request bodies.",
+		Buckets: sizeBuckets,
+	}, []string{routeLabel})
+	requestsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chs_delta_api_requests_in_flight",
+		Help: "Delta requests being handled.",
+	}, []string{routeLabel})
+)
+
+// registerMetrics registers the endpoint Prometheus scrapes metrics from.
+func registerMetrics(mainRouter *mux.Router) {
+	mainRouter.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet).Name("metrics")
+}
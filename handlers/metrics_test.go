@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/chs-delta-api/config"
+	hMocks "github.com/companieshouse/chs-delta-api/helpers/mocks"
+	sMocks "github.com/companieshouse/chs-delta-api/services/mocks"
+	chvMocks "github.com/companieshouse/chs-delta-api/validation/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// observations returns the number of values observed by the histogram of a route.
+func observations(h *prometheus.HistogramVec, route string) uint64 {
+	m := &dto.Metric{}
+	if err := h.WithLabelValues(route).(prometheus.Histogram).Write(m); err != nil {
+		return 0
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// TestUnitDeltaHandlerMetrics asserts that deltas are counted and timed under the name of their route.
+func TestUnitDeltaHandlerMetrics(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	config.CallValidateConfig = func(cfg *config.Config) error {
+		return nil
+	}
+	cfg, _ := config.Get()
+
+	newHandler := func(route string) (*DeltaHandler, *sMocks.MockKafkaService, *chvMocks.MockCHValidator) {
+		h := hMocks.NewMockHelper(mockCtrl)
+		svc := sMocks.NewMockKafkaService(mockCtrl)
+		chv := chvMocks.NewMockCHValidator(mockCtrl)
+		handler := NewDeltaHandler(svc, h, chv, cfg, !doValidationOnly, isDelete, topic, primaryIdPath, deltaType)
+		handler.route = route
+		h.EXPECT().GetRequestIdFromHeader(gomock.Any()).Return(contextId)
+		h.EXPECT().GetDataFromRequest(gomock.Any(), contextId).Return(requestBody, nil)
+		chv.EXPECT().GetSpecVersion().Return(specVersion)
+		return handler, svc, chv
+	}
+
+	Convey("Given a delta which is published", t, func() {
+		route := "metrics-published-delta"
+		handler, svc, chv := newHandler(route)
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+		svc.EXPECT().SendMessage(topic, requestBody, gomock.Any()).Return(nil)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		Convey("Then it is counted as received and published, and timed", func() {
+			So(testutil.ToFloat64(deltasReceived.WithLabelValues(route)), ShouldEqual, 1)
+			So(testutil.ToFloat64(deltasPublished.WithLabelValues(route)), ShouldEqual, 1)
+			So(testutil.ToFloat64(deltasPublishFailed.WithLabelValues(route)), ShouldEqual, 0)
+			So(testutil.ToFloat64(deltasValidationFailed.WithLabelValues(route)), ShouldEqual, 0)
+			So(observations(requestDuration, route), ShouldEqual, 1)
+			So(observations(validationDuration, route), ShouldEqual, 1)
+			So(observations(kafkaSendDuration, route), ShouldEqual, 1)
+			So(observations(payloadSize, route), ShouldEqual, 1)
+			So(testutil.ToFloat64(requestsInFlight.WithLabelValues(route)), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a delta which fails validation", t, func() {
+		route := "metrics-invalid-delta"
+		handler, _, chv := newHandler(route)
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return([]byte("errors"), nil)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		Convey("Then it is counted as received and failing validation, but not sent", func() {
+			So(testutil.ToFloat64(deltasReceived.WithLabelValues(route)), ShouldEqual, 1)
+			So(testutil.ToFloat64(deltasValidationFailed.WithLabelValues(route)), ShouldEqual, 1)
+			So(testutil.ToFloat64(deltasPublished.WithLabelValues(route)), ShouldEqual, 0)
+			So(observations(kafkaSendDuration, route), ShouldEqual, 0)
+			So(observations(requestDuration, route), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a delta which can't be sent to Kafka", t, func() {
+		route := "metrics-failed-delta"
+		handler, svc, chv := newHandler(route)
+		req := httptest.NewRequest(postMethod, endPoint, bytes.NewBuffer([]byte(requestBody)))
+		chv.EXPECT().ValidateDelta(req, gomock.Any(), contextId).Return(nil, nil)
+		svc.EXPECT().SendMessage(topic, requestBody, gomock.Any()).Return(errors.New("kafka unavailable"))
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		Convey("Then it is counted as failing to publish", func() {
+			So(testutil.ToFloat64(deltasPublishFailed.WithLabelValues(route)), ShouldEqual, 1)
+			So(testutil.ToFloat64(deltasPublished.WithLabelValues(route)), ShouldEqual, 0)
+			So(observations(kafkaSendDuration, route), ShouldEqual, 1)
+		})
+	})
+}
+
+// TestUnitRegisterMetrics asserts that the metrics endpoint exposes the delta metrics to Prometheus.
+func TestUnitRegisterMetrics(t *testing.T) {
+	Convey("When I call the metrics endpoint", t, func() {
+		router := mux.NewRouter()
+		registerMetrics(router)
+		deltasReceived.WithLabelValues("metrics-endpoint-delta").Inc()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		Convey("Then I am given the metrics labelled by route", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+			So(w.Body.String(), ShouldContainSubstring, "# TYPE chs_delta_api_deltas_received_total counter\n")
+			So(w.Body.String(), ShouldContainSubstring, `chs_delta_api_deltas_received_total{route="metrics-endpoint-delta"} 1`+"\n")
+			So(w.Body.String(), ShouldContainSubstring, "# TYPE chs_delta_api_request_duration_seconds histogram\n")
+		})
+	})
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// registerPublished registers the endpoints used to inspect and clear the deltas published, if the publisher keeps
+// them. These are only available when running without Kafka.
+func registerPublished(appRouter *mux.Router, kSvc services.KafkaService) {
+
+	p, ok := kSvc.(services.PublishedLister)
+	if !ok {
+		return
+	}
+
+	appRouter.HandleFunc("/chs-delta-api/published", listPublished(p)).Methods(http.MethodGet).Name("published")
+	appRouter.HandleFunc("/chs-delta-api/published", clearPublished(p)).Methods(http.MethodDelete).Name("published-clear")
+}
+
+// listPublished returns a handler which lists the deltas published as JSON, oldest first. They can be filtered by the
+// topic and context_id query parameters.
+func listPublished(p services.PublishedLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("topic")
+		contextId := r.URL.Query().Get("context_id")
+
+		published := make([]services.PublishedDelta, 0)
+		for _, pd := range p.Published() {
+			if (topic == "" || pd.Topic == topic) && (contextId == "" || pd.ContextId == contextId) {
+				published = append(published, pd)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, published)
+	}
+}
+
+// clearPublished returns a handler which forgets the deltas published, so tests can start from a clean slate.
+func clearPublished(p services.PublishedLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		p.ClearPublished()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
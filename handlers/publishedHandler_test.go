@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestUnitPublished asserts that deltas kept by the memory publisher can be listed, filtered and cleared.
+func TestUnitPublished(t *testing.T) {
+
+	Convey("Given a memory publisher which has published deltas", t, func() {
+		p := services.NewMemoryPublisher()
+		router := mux.NewRouter()
+		registerPublished(router, p)
+		_ = p.SendMessage(topic, "{}", models.DeltaMetadata{ContextId: contextId})
+		_ = p.SendMessage("other-topic", "{}", models.DeltaMetadata{ContextId: "other"})
+
+		serve := func(method, target string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+			return w
+		}
+
+		Convey("When I list them, then all are returned", func() {
+			w := serve(http.MethodGet, "/chs-delta-api/published")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"topic":"topic"`)
+			So(w.Body.String(), ShouldContainSubstring, `"topic":"other-topic"`)
+		})
+
+		Convey("When I filter them by topic and context id, then only those matching are returned", func() {
+			w := serve(http.MethodGet, "/chs-delta-api/published?topic=topic&context_id="+contextId)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"topic":"topic"`)
+			So(w.Body.String(), ShouldNotContainSubstring, `"topic":"other-topic"`)
+
+			w = serve(http.MethodGet, "/chs-delta-api/published?topic=missing")
+			So(w.Body.String(), ShouldEqual, "[]\n")
+		})
+
+		Convey("When I clear them, then none are returned", func() {
+			w := serve(http.MethodDelete, "/chs-delta-api/published")
+			So(w.Code, ShouldEqual, http.StatusNoContent)
+			So(p.Published(), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given a publisher which doesn't keep deltas, then the endpoints aren't registered", t, func() {
+		router := mux.NewRouter()
+		registerPublished(router, &services.KafkaServiceImpl{})
+		So(router.GetRoute("published"), ShouldBeNil)
+	})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/companieshouse/chs-delta-api/validation"
+	"net/http"
+	"os"
+)
+
+// readinessResponse is the body of the readiness probe, describing each check made.
+type readinessResponse struct {
+	Status string                    `json:"status"`
+	Checks []services.ReadinessCheck `json:"checks"`
+}
+
+// liveness returns a handler which reports the process is running. It keeps passing while the service shuts down, so
+// the task isn't killed before it has drained.
+func liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": services.CheckOk})
+	}
+}
+
+// readiness returns a handler which checks the service can accept deltas: that it isn't shutting down, that an OpenAPI
+// spec is loaded and, if the Kafka service supports it, that the brokers, topics and schema are available. It returns
+// 503 if any check is failing, so load balancers stop routing to the task. Degraded checks are reported but don't fail.
+func readiness(kSvc services.KafkaService, chv validation.CHValidator) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+
+		checks := []services.ReadinessCheck{drainingCheck(), specCheck(chv)}
+		if r, ok := kSvc.(services.ReadinessChecker); ok {
+			checks = append(checks, r.ReadinessChecks(deltaTopics(chv))...)
+		}
+
+		resp := readinessResponse{Status: services.CheckOk, Checks: checks}
+		status := http.StatusOK
+		for _, c := range checks {
+			if c.Status == services.CheckFailing {
+				resp.Status = services.CheckFailing
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		writeJSON(w, status, resp)
+	}
+}
+
+// drainingCheck fails once the service has started shutting down.
+func drainingCheck() services.ReadinessCheck {
+	if draining.Load() {
+		return services.ReadinessCheck{Name: "draining", Status: services.CheckFailing, Detail: "shutting down"}
+	}
+	return services.ReadinessCheck{Name: "draining", Status: services.CheckOk}
+}
+
+// specCheck reports the version of the OpenAPI spec requests are validated against. It is degraded if the spec failed
+// to reload, as the previous version is still in use.
+func specCheck(chv validation.CHValidator) services.ReadinessCheck {
+	version := chv.GetSpecVersion()
+	if version == "" {
+		return services.ReadinessCheck{Name: "openapi_spec", Status: services.CheckFailing, Detail: "no spec loaded"}
+	}
+	if r, ok := chv.(validation.ReloadStatuser); ok {
+		if err := r.LastReloadError(); err != nil {
+			return services.ReadinessCheck{Name: "openapi_spec", Status: services.CheckDegraded, Detail: "version " + version + " in use, reload failed: " + err.Error()}
+		}
+	}
+	return services.ReadinessCheck{Name: "openapi_spec", Status: services.CheckOk, Detail: "version " + version}
+}
+
+// deltaTopics returns the Kafka topics deltas are published to, as set in the environment.
+func deltaTopics(chv validation.CHValidator) []string {
+	var topics []string
+	for _, route := range chv.GetDeltaRoutes() {
+		if route.Action == validation.ActionValidate {
+			continue
+		}
+		if topic := os.ExpandEnv(route.Topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
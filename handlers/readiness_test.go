@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/companieshouse/chs-delta-api/validation"
+	chvMocks "github.com/companieshouse/chs-delta-api/validation/mocks"
+	"github.com/golang/mock/gomock"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// checkingKafkaService is a KafkaService returning fixed readiness checks, recording the topics it was asked about.
+type checkingKafkaService struct {
+	services.KafkaService
+	checks []services.ReadinessCheck
+	topics []string
+}
+
+func (c *checkingKafkaService) ReadinessChecks(topics []string) []services.ReadinessCheck {
+	c.topics = topics
+	return c.checks
+}
+
+// TestUnitLiveness asserts that the liveness probe passes, even while shutting down.
+func TestUnitLiveness(t *testing.T) {
+	Convey("When I call the liveness endpoint while shutting down, then I am given a 200 status", t, func() {
+		draining.Store(true)
+		defer draining.Store(false)
+
+		w := httptest.NewRecorder()
+		liveness()(w, nil)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `{"status":"ok"}`+"\n")
+	})
+}
+
+// TestUnitReadiness asserts that the readiness probe describes each check and fails if any check is failing.
+func TestUnitReadiness(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a service with a loaded spec", t, func() {
+		t.Setenv("EXAMPLE_TOPIC", topic)
+		chv := chvMocks.NewMockCHValidator(mockCtrl)
+		chv.EXPECT().GetSpecVersion().Return(specVersion).AnyTimes()
+		chv.EXPECT().GetDeltaRoutes().Return([]validation.DeltaRoute{
+			{Path: "/delta/officers", Action: validation.ActionUpsert, Topic: "${EXAMPLE_TOPIC}"},
+			{Path: "/delta/officers/validate", Action: validation.ActionValidate, Topic: "${UNSET_TOPIC}"},
+		}).AnyTimes()
+		kSvc := &checkingKafkaService{checks: []services.ReadinessCheck{
+			{Name: "kafka_brokers", Status: services.CheckOk},
+			{Name: "schema", Status: services.CheckDegraded, Detail: "fallback"},
+		}}
+
+		check := func() (*httptest.ResponseRecorder, readinessResponse) {
+			w := httptest.NewRecorder()
+			readiness(kSvc, chv)(w, nil)
+			var resp readinessResponse
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			return w, resp
+		}
+
+		Convey("When every check passes or is degraded, then it is ready and the checks are described", func() {
+			w, resp := check()
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(resp.Status, ShouldEqual, services.CheckOk)
+			So(resp.Checks, ShouldResemble, []services.ReadinessCheck{
+				{Name: "draining", Status: services.CheckOk},
+				{Name: "openapi_spec", Status: services.CheckOk, Detail: "version " + specVersion},
+				{Name: "kafka_brokers", Status: services.CheckOk},
+				{Name: "schema", Status: services.CheckDegraded, Detail: "fallback"},
+			})
+			So(kSvc.topics, ShouldResemble, []string{topic})
+		})
+
+		Convey("When a Kafka check fails, then it isn't ready", func() {
+			kSvc.checks[0].Status = services.CheckFailing
+			w, resp := check()
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(resp.Status, ShouldEqual, services.CheckFailing)
+		})
+
+		Convey("When the service is shutting down, then it isn't ready", func() {
+			draining.Store(true)
+			defer draining.Store(false)
+			w, resp := check()
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(resp.Checks[0], ShouldResemble, services.ReadinessCheck{Name: "draining", Status: services.CheckFailing, Detail: "shutting down"})
+		})
+	})
+
+	Convey("Given a service whose spec isn't loaded, then it isn't ready", t, func() {
+		chv := chvMocks.NewMockCHValidator(mockCtrl)
+		chv.EXPECT().GetSpecVersion().Return("")
+		w := httptest.NewRecorder()
+		readiness(services.NewMemoryPublisher(), chv)(w, nil)
+		So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+	})
+}
@@ -2,7 +2,10 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/companieshouse/chs-delta-api/config"
 	"github.com/companieshouse/chs-delta-api/helpers"
@@ -29,6 +32,18 @@ func Register(mainRouter *mux.Router, cfg *config.Config, kSvc services.KafkaSer
 		return err
 	}
 
+	// Check deltas against business rules once they pass schema validation, if the validator supports it.
+	if ru, ok := chv.(validation.RuleUser); ok {
+		ru.UseRules(validation.DefaultRules())
+	}
+
+	// Reload the spec on SIGHUP or when it changes on disk, if the validator supports it, until shutting down.
+	if w, ok := chv.(validation.SpecWatcher); ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		stopBackground = cancel
+		go w.Watch(ctx, time.Duration(cfg.OpenApiSpecReloadSecs)*time.Second)
+	}
+
 	// Init the Kafka service and handle any errors that come back.
 	if err := kSvc.Init(cfg); err != nil {
 		return err
@@ -40,53 +55,125 @@ func Register(mainRouter *mux.Router, cfg *config.Config, kSvc services.KafkaSer
 	}
 
 	// Register endpoints for service.
-	mainRouter.HandleFunc("/chs-delta-api/healthcheck", healthCheck).Methods(http.MethodGet).Name("healthcheck")
+	mainRouter.HandleFunc("/chs-delta-api/healthcheck", healthCheck(kSvc)).Methods(http.MethodGet).Name("healthcheck")
+	mainRouter.HandleFunc("/chs-delta-api/healthcheck/live", liveness()).Methods(http.MethodGet).Name("healthcheck-live")
+	mainRouter.HandleFunc("/chs-delta-api/healthcheck/ready", readiness(kSvc, chv)).Methods(http.MethodGet).Name("healthcheck-ready")
+	registerMetrics(mainRouter)
+	mainRouter.NotFoundHandler = notFound()
+	mainRouter.MethodNotAllowedHandler = methodNotAllowed()
 	mainRouter.Use(log.Handler)
 
 	appRouter := mainRouter.PathPrefix("").Subrouter()
-	appRouter.HandleFunc("/delta/officers", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.OfficerDeltaTopic, "internal_id").ServeHTTP).Methods(http.MethodPost).Name("officer-delta")
-	appRouter.HandleFunc("/delta/officers/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.OfficerDeltaTopic, "internal_id").ServeHTTP).Methods(http.MethodPost).Name("officer-delta")
-	appRouter.HandleFunc("/delta/officers/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.OfficerDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("officer-delta-validate")
-	appRouter.HandleFunc("/delta/insolvency", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.InsolvencyDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("insolvency-delta")
-	appRouter.HandleFunc("/delta/insolvency/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.InsolvencyDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("insolvency-delta")
-	appRouter.HandleFunc("/delta/insolvency/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.InsolvencyDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("insolvency-delta-validate")
-	appRouter.HandleFunc("/delta/charges", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.ChargesDeltaTopic, "id").ServeHTTP).Methods(http.MethodPost).Name("charges-delta")
-	appRouter.HandleFunc("/delta/charges/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.ChargesDeltaTopic, "charges_id").ServeHTTP).Methods(http.MethodPost).Name("charges-delta")
-	appRouter.HandleFunc("/delta/charges/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.ChargesDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("charges-delta-validate")
-	appRouter.HandleFunc("/delta/disqualification", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.DisqualifiedDeltaTopic, "officer_id").ServeHTTP).Methods(http.MethodPost).Name("disqualified-officer-delta")
-	appRouter.HandleFunc("/delta/disqualification/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.DisqualifiedDeltaTopic, "officer_id").ServeHTTP).Methods(http.MethodPost).Name("disqualified-officer-delta")
-	appRouter.HandleFunc("/delta/disqualification/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.DisqualifiedDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("disqualified-officer-delta-validate")
-	appRouter.HandleFunc("/delta/company", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.CompanyDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("company-delta")
-	appRouter.HandleFunc("/delta/company/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.CompanyDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("company-delta")
-	appRouter.HandleFunc("/delta/company/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.CompanyDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("company-delta-validate")
-	appRouter.HandleFunc("/delta/exemption", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.ExemptionDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("exemption-delta")
-	appRouter.HandleFunc("/delta/exemption/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.ExemptionDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("exemption-delta")
-	appRouter.HandleFunc("/delta/exemption/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.ExemptionDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("exemption-delta-validate")
-	appRouter.HandleFunc("/delta/psc-statement", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.PscStatementDeltaTopic, "psc_statement_id").ServeHTTP).Methods(http.MethodPost).Name("psc-statement-delta")
-	appRouter.HandleFunc("/delta/psc-statement/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.PscStatementDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("psc-statement-delta-validate")
-	appRouter.HandleFunc("/delta/psc-statement/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.PscStatementDeltaTopic, "psc_statement_id").ServeHTTP).Methods(http.MethodPost).Name("psc-statement-delta")
-	appRouter.HandleFunc("/delta/pscs", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.PscDeltaTopic, "psc_id").ServeHTTP).Methods(http.MethodPost).Name("psc-delta")
-	appRouter.HandleFunc("/delta/pscs/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.PscDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("psc-delta-validate")
-	appRouter.HandleFunc("/delta/pscs/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.PscDeltaTopic, "psc_id").ServeHTTP).Methods(http.MethodPost).Name("psc-delta-delete")
-	appRouter.HandleFunc("/delta/filing-history", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.FilingHistoryDeltaTopic, "entity_id").ServeHTTP).Methods(http.MethodPost).Name("filing-history-delta")
-	appRouter.HandleFunc("/delta/filing-history/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.FilingHistoryDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("filing-history-delta-validate")
-	appRouter.HandleFunc("/delta/filing-history/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.FilingHistoryDeltaTopic, "entity_id").ServeHTTP).Methods(http.MethodPost).Name("filing-history-delete-delta")
-	// appRouter.HandleFunc("/delta/document-store", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.DocumentStoreDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("document-store-delta")
-	// appRouter.HandleFunc("/delta/document-store/validate", NewDeltaHandler(kSvc, h, chv, cfg, true, false, cfg.DocumentStoreDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("document-store-delta-validate")
-	appRouter.HandleFunc("/delta/registers", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.RegistersDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("registers-delta")
-	appRouter.HandleFunc("/delta/registers/delete", NewDeltaHandler(kSvc, h, chv, cfg, false, true, cfg.RegistersDeltaTopic, "company_number").ServeHTTP).Methods(http.MethodPost).Name("registers-delta-delete")
-	appRouter.HandleFunc("/delta/registers/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.RegistersDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("registers-delta-validate")
-	appRouter.HandleFunc("/delta/acsp", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.AcspProfileDeltaTopic, "acsp_number").ServeHTTP).Methods(http.MethodPost).Name("acsp-profile-delta")
-	appRouter.HandleFunc("/delta/acsp/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.AcspProfileDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("acsp-profile-delta-validate")
+	if err := registerDeltas(mainRouter, appRouter, kSvc, h, chv, cfg); err != nil {
+		return err
+	}
+	registerDeadLetters(appRouter, kSvc)
+	registerPublished(appRouter, kSvc)
+	if o, ok := kSvc.(services.OutboxStatser); ok {
+		appRouter.HandleFunc("/chs-delta-api/outbox", outboxStats(o)).Methods(http.MethodGet).Name("outbox")
+	}
 	appRouter.Use(userAuthInterceptor.UserAuthenticationIntercept)
 
-	// TODO: move these back to appRouter when CHIPS image-sender service has been updated to allow an aPI key to be configured to its calls here
-	mainRouter.HandleFunc("/delta/document-store", NewDeltaHandler(kSvc, h, chv, cfg, false, false, cfg.DocumentStoreDeltaTopic, "transaction_id").ServeHTTP).Methods(http.MethodPost).Name("document-store-delta")
-	mainRouter.HandleFunc("/delta/document-store/validate", NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, cfg.DocumentStoreDeltaTopic).ServeHTTP).Methods(http.MethodPost).Name("document-store-delta-validate")
+	return nil
+}
+
+// registerDeltas registers a handler for every delta route declared in the OpenAPI spec. An error is returned if a
+// spec path has no registered delta, a registered delta has no spec paths, or a delta's Kafka topic is not set.
+func registerDeltas(mainRouter, appRouter *mux.Router, kSvc services.KafkaService, h helpers.Helper,
+	chv validation.CHValidator, cfg *config.Config) error {
+
+	registered := make(map[string]bool, len(config.Deltas))
+
+	for _, route := range chv.GetDeltaRoutes() {
+		d, ok := config.FindDelta(route.Path)
+		if !ok {
+			return fmt.Errorf("no delta registered for spec path %s", route.Path)
+		}
+
+		topic, err := cfg.ExpandTopic(route.Topic)
+		if err != nil && route.Action != validation.ActionValidate {
+			return fmt.Errorf("kafka topic for spec path %s: %w", route.Path, err)
+		}
+
+		var idPath helpers.IdPath
+		if route.Action != validation.ActionValidate {
+			var err error
+			if idPath, err = helpers.ParseIdPath(route.PrimaryId); err != nil {
+				return fmt.Errorf("invalid primary id for spec path %s: %w", route.Path, err)
+			}
+		}
+
+		var handler *DeltaHandler
+		name, label := d.Name, d.Name
+		switch route.Action {
+		case validation.ActionUpsert:
+			handler = NewDeltaHandler(kSvc, h, chv, cfg, false, false, topic, idPath, name)
+		case validation.ActionDelete:
+			name, label = d.GetDeleteName(), d.GetDeleteLabel()
+			handler = NewDeltaHandler(kSvc, h, chv, cfg, false, true, topic, idPath, name)
+		case validation.ActionValidate:
+			handler = NewDeltaHandlerValidate(kSvc, h, chv, cfg, true, false, topic)
+			name += "-validate"
+			label = name
+		default:
+			return fmt.Errorf("no handler for action %s of spec path %s", route.Action, route.Path)
+		}
+
+		handler.route = label
+		handler.maxBodyBytes = maxBodyBytes(route, cfg)
+
+		r := appRouter
+		if d.SkipAuth {
+			r = mainRouter
+		}
+		r.HandleFunc(route.Path, handler.ServeHTTP).Methods(http.MethodPost).Name(name)
+		registered[d.Name] = true
+	}
+
+	for _, d := range config.Deltas {
+		if !registered[d.Name] {
+			return fmt.Errorf("no spec paths declared for delta %s", d.Path)
+		}
+	}
 
 	return nil
 }
 
-func healthCheck(w http.ResponseWriter, _ *http.Request) {
-	w.WriteHeader(http.StatusOK)
+// maxBodyBytes returns the largest request body a delta route accepts: its own limit if the spec sets one, otherwise
+// the configured limit or DefaultMaxBodyBytes.
+func maxBodyBytes(route validation.DeltaRoute, cfg *config.Config) int64 {
+	if route.MaxBodyBytes > 0 {
+		return route.MaxBodyBytes
+	}
+	if cfg != nil && cfg.MaxBodyBytes > 0 {
+		return int64(cfg.MaxBodyBytes)
+	}
+	return DefaultMaxBodyBytes
+}
+
+// healthCheck returns a handler which reports the service is running, or 503 once it is shutting down. If the Kafka
+// service has a circuit breaker, its state is reported too. The service stays healthy while the breaker is open, as
+// restarting it won't fix Kafka.
+func healthCheck(kSvc services.KafkaService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			writeError(w, r, requestId(r), http.StatusServiceUnavailable, "shutting down")
+			return
+		}
+
+		b, ok := kSvc.(services.BreakerStater)
+		if !ok {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"kafka_circuit_breaker": b.BreakerState()})
+	}
+}
+
+// outboxStats returns a handler which reports the depth of the outbox as JSON.
+func outboxStats(o services.OutboxStatser) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, o.OutboxStats())
+	}
 }
@@ -1,28 +1,90 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/services"
 	"github.com/companieshouse/chs-delta-api/services/mocks"
 	"github.com/companieshouse/chs-delta-api/validation"
+	chvMocks "github.com/companieshouse/chs-delta-api/validation/mocks"
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+const apiSpecLocation = "../ecs-image-build/apispec/api-spec.yml"
+
+// breakerKafkaService is a KafkaService reporting a fixed circuit breaker state.
+type breakerKafkaService struct {
+	services.KafkaService
+	state string
+}
+
+func (b breakerKafkaService) BreakerState() string {
+	return b.state
+}
+
 // TestUnitHealthCheck asserts that the healthcheck endpoint correctly returns 200 when called.
 func TestUnitHealthCheck(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
 	Convey("When I call the healthcheck endpoint, then I am given a 200 status", t, func() {
-		w := httptest.ResponseRecorder{}
-		healthCheck(&w, nil)
+		w := httptest.NewRecorder()
+		healthCheck(mocks.NewMockKafkaService(mockCtrl))(w, nil)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldBeEmpty)
+	})
+
+	Convey("When I call the healthcheck endpoint while the Kafka circuit breaker is open", t, func() {
+		w := httptest.NewRecorder()
+		healthCheck(breakerKafkaService{state: services.BreakerOpen})(w, nil)
+
+		Convey("Then I am given a 200 status and the breaker state", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, `{"kafka_circuit_breaker":"open"}`+"\n")
+		})
+	})
+}
+
+// stubOutbox reports fixed outbox stats.
+type stubOutbox struct {
+	stats services.OutboxStats
+}
+
+func (s stubOutbox) OutboxStats() services.OutboxStats {
+	return s.stats
+}
+
+// TestUnitOutboxStats asserts that the outbox endpoint reports the depth of the outbox as JSON.
+func TestUnitOutboxStats(t *testing.T) {
+	Convey("When I call the outbox endpoint, then I am given the outbox stats", t, func() {
+		w := httptest.NewRecorder()
+		outboxStats(stubOutbox{services.OutboxStats{Depth: 2, Bytes: 300, MaxBytes: 1024}})(w, nil)
 		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(w.Body.String(), ShouldEqual, `{"depth":2,"bytes":300,"max_bytes":1024}`+"\n")
 	})
 }
 
+// watchingValidator is a CHValidator which records the context it is asked to watch its spec until.
+type watchingValidator struct {
+	validation.CHValidator
+	watching chan context.Context
+}
+
+func (w watchingValidator) Watch(ctx context.Context, _ time.Duration) {
+	w.watching <- ctx
+}
+
 // TestUnitRegister asserts that all routes are correctly registered and can be called.
 func TestUnitRegister(t *testing.T) {
 
@@ -32,8 +94,18 @@ func TestUnitRegister(t *testing.T) {
 	Convey("When we call the register function then all routes are registered", t, func() {
 		router := mux.NewRouter()
 
+		chv, err := validation.NewCHValidator(apiSpecLocation)
+		So(err, ShouldBeNil)
+		for _, route := range chv.GetDeltaRoutes() {
+			os.Expand(route.Topic, func(name string) string {
+				t.Setenv(name, topic)
+				return topic
+			})
+		}
+
+		watching := make(chan context.Context, 1)
 		callNewCHValidator = func(openApiSpec string) (validation.CHValidator, error) {
-			return &validation.CHValidatorImpl{}, nil
+			return watchingValidator{CHValidator: chv, watching: watching}, nil
 		}
 
 		config.CallValidateConfig = func(cfg *config.Config) error {
@@ -44,8 +116,11 @@ func TestUnitRegister(t *testing.T) {
 
 		kSvc.EXPECT().Init(cfg).Return(nil)
 
-		err := Register(router, cfg, kSvc)
+		err = Register(router, cfg, kSvc)
 		So(router.GetRoute("healthcheck"), ShouldNotBeNil)
+		So(router.GetRoute("healthcheck-live"), ShouldNotBeNil)
+		So(router.GetRoute("healthcheck-ready"), ShouldNotBeNil)
+		So(router.GetRoute("metrics"), ShouldNotBeNil)
 		So(router.GetRoute("officer-delta"), ShouldNotBeNil)
 		So(router.GetRoute("officer-delta-validate"), ShouldNotBeNil)
 		So(router.GetRoute("insolvency-delta"), ShouldNotBeNil)
@@ -58,6 +133,7 @@ func TestUnitRegister(t *testing.T) {
 		So(router.GetRoute("company-delta-validate"), ShouldNotBeNil)
 		So(router.GetRoute("psc-delta"), ShouldNotBeNil)
 		So(router.GetRoute("psc-delta-validate"), ShouldNotBeNil)
+		So(router.GetRoute("psc-delta-delete"), ShouldNotBeNil)
 		So(router.GetRoute("filing-history-delta"), ShouldNotBeNil)
 		So(router.GetRoute("filing-history-delta-validate"), ShouldNotBeNil)
 		So(router.GetRoute("filing-history-delete-delta"), ShouldNotBeNil)
@@ -69,5 +145,88 @@ func TestUnitRegister(t *testing.T) {
 		So(router.GetRoute("acsp-profile-delta"), ShouldNotBeNil)
 		So(router.GetRoute("acsp-profile-delta-validate"), ShouldNotBeNil)
 		So(err, ShouldBeNil)
+
+		// The spec is watched until shutting down.
+		ctx := <-watching
+		So(ctx.Err(), ShouldBeNil)
+		stopBackground()
+		So(ctx.Err(), ShouldEqual, context.Canceled)
+	})
+}
+
+// TestUnitRegisterDeltasFailsFast asserts that registration fails when the spec and the delta registry disagree, or a
+// topic is not set.
+func TestUnitRegisterDeltasFailsFast(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given routes have been read from the spec", t, func() {
+		mainRouter := mux.NewRouter()
+		appRouter := mainRouter.PathPrefix("").Subrouter()
+		chv := chvMocks.NewMockCHValidator(mockCtrl)
+		kSvc := mocks.NewMockKafkaService(mockCtrl)
+		t.Setenv("EXAMPLE_TOPIC", topic)
+
+		Convey("When a spec path has no registered delta, then an error is returned", func() {
+			chv.EXPECT().GetDeltaRoutes().Return([]validation.DeltaRoute{
+				{Path: "/delta/unknown", Action: validation.ActionUpsert, Topic: "${EXAMPLE_TOPIC}", PrimaryId: primaryId},
+			})
+
+			err := registerDeltas(mainRouter, appRouter, kSvc, nil, chv, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a topic is not set in the environment, then an error is returned", func() {
+			chv.EXPECT().GetDeltaRoutes().Return([]validation.DeltaRoute{
+				{Path: "/delta/officers", Action: validation.ActionUpsert, Topic: "${MISSING_TOPIC}", PrimaryId: primaryId},
+			})
+
+			err := registerDeltas(mainRouter, appRouter, kSvc, nil, chv, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a primary id isn't a valid JSON path, then an error is returned", func() {
+			chv.EXPECT().GetDeltaRoutes().Return([]validation.DeltaRoute{
+				{Path: "/delta/officers", Action: validation.ActionUpsert, Topic: "${EXAMPLE_TOPIC}", PrimaryId: "internal_id"},
+			})
+
+			err := registerDeltas(mainRouter, appRouter, kSvc, nil, chv, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a registered delta has no spec paths, then an error is returned", func() {
+			chv.EXPECT().GetDeltaRoutes().Return([]validation.DeltaRoute{
+				{Path: "/delta/officers", Action: validation.ActionUpsert, Topic: "${EXAMPLE_TOPIC}", PrimaryId: primaryId},
+			})
+
+			err := registerDeltas(mainRouter, appRouter, kSvc, nil, chv, nil)
+			So(err, ShouldNotBeNil)
+			So(mainRouter.GetRoute("officer-delta"), ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitMaxBodyBytes asserts that a route's own body limit is preferred over the configured one, which is preferred
+// over the default.
+func TestUnitMaxBodyBytes(t *testing.T) {
+
+	Convey("Given a delta route", t, func() {
+
+		route := validation.DeltaRoute{Path: "/delta/officers", Action: validation.ActionUpsert}
+
+		Convey("When neither the route nor the config set a limit, then the default is used", func() {
+			So(maxBodyBytes(route, nil), ShouldEqual, DefaultMaxBodyBytes)
+			So(maxBodyBytes(route, &config.Config{}), ShouldEqual, DefaultMaxBodyBytes)
+		})
+
+		Convey("When the config sets a limit, then it is used", func() {
+			So(maxBodyBytes(route, &config.Config{MaxBodyBytes: 2048}), ShouldEqual, 2048)
+		})
+
+		Convey("When the route sets a limit, then it is used over the config", func() {
+			route.MaxBodyBytes = 4096
+			So(maxBodyBytes(route, &config.Config{MaxBodyBytes: 2048}), ShouldEqual, 4096)
+		})
 	})
 }
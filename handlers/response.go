@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/helpers"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs.go/log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Values of the CHErrors in error responses, which describe the request as a whole rather than a field of its body.
+const (
+	serviceErrorType     = "ch:service"
+	resourceLocationType = "resource"
+)
+
+// statusErrorCodes maps the status of an error response to the error code it is given.
+var statusErrorCodes = map[int]string{
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "service_unavailable",
+}
+
+// writeError writes an error response with the given status, holding a single CHError located at the request's path.
+func writeError(w http.ResponseWriter, r *http.Request, requestId string, status int, message string) {
+	writeErrorWithValues(w, r, requestId, status, message, map[string]interface{}{})
+}
+
+// writeErrorWithValues writes an error response like writeError, with values describing the error.
+func writeErrorWithValues(w http.ResponseWriter, r *http.Request, requestId string, status int, message string, values map[string]interface{}) {
+	code, ok := statusErrorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeJSON(w, status, models.ErrorResponse{
+		RequestId: requestId,
+		Errors: []models.CHError{{
+			Error:        message,
+			ErrorCode:    code,
+			ErrorValues:  values,
+			Location:     r.URL.Path,
+			LocationType: resourceLocationType,
+			Type:         serviceErrorType,
+		}},
+	})
+}
+
+// writeServiceUnavailable writes a 503 response telling the caller, in whole seconds, when it's worth trying again.
+func writeServiceUnavailable(w http.ResponseWriter, r *http.Request, requestId string, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, r, requestId, http.StatusServiceUnavailable, message)
+}
+
+// requestId returns the id of a request from its X-Request-Id header, generating one if it has none.
+func requestId(r *http.Request) string {
+	return helpers.NewHelper().GetRequestIdFromHeader(r)
+}
+
+// notFound returns a handler writing a 404 error response, for requests which don't match any route.
+func notFound() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, requestId(r), http.StatusNotFound, "no route matches the request")
+	}
+}
+
+// methodNotAllowed returns a handler writing a 405 error response, for requests whose path matches a route but whose
+// method doesn't.
+func methodNotAllowed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, requestId(r), http.StatusMethodNotAllowed, "method "+r.Method+" is not allowed")
+	}
+}
+
+// writeJSON writes a value as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err, log.Data{config.MessageKey: "error writing response"})
+	}
+}
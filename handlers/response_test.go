@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestUnitWriteError asserts that error responses are written as JSON holding the request id and a CHError.
+func TestUnitWriteError(t *testing.T) {
+
+	Convey("When I write an error response", t, func() {
+
+		w := httptest.NewRecorder()
+		writeError(w, httptest.NewRequest(http.MethodPost, "/delta/officers", nil), contextId, http.StatusRequestEntityTooLarge, "too large")
+
+		Convey("Then the status, request id and error are written", func() {
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+
+			var body models.ErrorResponse
+			So(json.Unmarshal(w.Body.Bytes(), &body), ShouldBeNil)
+			So(body, ShouldResemble, models.ErrorResponse{
+				RequestId: contextId,
+				Errors: []models.CHError{{
+					Error:        "too large",
+					ErrorCode:    "payload_too_large",
+					ErrorValues:  map[string]interface{}{},
+					Location:     "/delta/officers",
+					LocationType: resourceLocationType,
+					Type:         serviceErrorType,
+				}},
+			})
+		})
+	})
+}
+
+// TestUnitUnmatchedRoutes asserts that requests which don't match a route are given an error response.
+func TestUnitUnmatchedRoutes(t *testing.T) {
+
+	Convey("Given a router with the error handlers", t, func() {
+
+		router := mux.NewRouter()
+		router.NotFoundHandler = notFound()
+		router.MethodNotAllowedHandler = methodNotAllowed()
+		router.HandleFunc("/delta/officers", func(http.ResponseWriter, *http.Request) {}).Methods(http.MethodPost)
+
+		Convey("When a request matches no route, then it is given a 404 error response", func() {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/delta/unknown", nil)
+			req.Header.Set("X-Request-Id", contextId)
+			router.ServeHTTP(w, req)
+
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+			So(w.Body.String(), ShouldContainSubstring, `"request_id":"`+contextId+`"`)
+			So(w.Body.String(), ShouldContainSubstring, `"error_code":"not_found"`)
+		})
+
+		Convey("When a request matches a route's path but not its method, then it is given a 405 error response", func() {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/delta/officers", nil)
+			req.Header.Set("X-Request-Id", contextId)
+			router.ServeHTTP(w, req)
+
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			So(w.Body.String(), ShouldContainSubstring, `"error_code":"method_not_allowed"`)
+		})
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/companieshouse/chs.go/log"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShutdownGrace is how long in-flight requests are given to finish when shutting down, when no grace period is
+// configured. It leaves time within ECS's default 30 second stop timeout to close the Kafka producer.
+const DefaultShutdownGrace = 20 * time.Second
+
+// draining is set once the service starts shutting down, failing the healthcheck so load balancers stop routing
+// requests to it.
+var draining atomic.Bool
+
+// stopBackground cancels the work Register starts in the background, such as watching the OpenAPI spec for changes,
+// once the service starts shutting down.
+var stopBackground context.CancelFunc = func() {}
+
+// Server is the part of an http.Server needed to shut it down.
+type Server interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Shutdown stops the service in order. Background work stops and the healthcheck starts failing first and, after
+// delay, the server stops accepting requests and waits up to grace for those in flight to finish. Only then is the
+// Kafka service, and any outbox, flushed and closed, so in-flight deltas aren't cut off part way through being sent.
+func Shutdown(srv Server, kSvc services.KafkaService, delay, grace time.Duration) error {
+
+	if grace <= 0 {
+		grace = DefaultShutdownGrace
+	}
+
+	stopBackground()
+	draining.Store(true)
+	log.Info("Shutting down, failing the healthcheck", log.Data{config.ShutdownDelayKey: delay.String()})
+	time.Sleep(delay)
+
+	var errs []error
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error(err, log.Data{config.MessageKey: "failed to shutdown server gracefully"})
+		errs = append(errs, err)
+	} else {
+		log.Info("server shutdown gracefully")
+	}
+
+	if err := kSvc.Close(); err != nil {
+		log.Error(err, log.Data{config.MessageKey: "error closing kafka service"})
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/chs-delta-api/services/mocks"
+	"github.com/golang/mock/gomock"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeServer records the shutdown and the healthcheck status seen while it drains.
+type fakeServer struct {
+	events      *[]string
+	err         error
+	deadline    time.Time
+	healthcheck int
+}
+
+func (f *fakeServer) Shutdown(ctx context.Context) error {
+	*f.events = append(*f.events, "server")
+	f.deadline, _ = ctx.Deadline()
+	w := httptest.NewRecorder()
+	healthCheck(nil)(w, httptest.NewRequest(http.MethodGet, "/chs-delta-api/healthcheck", nil))
+	f.healthcheck = w.Code
+	return f.err
+}
+
+// TestUnitShutdown asserts that shutting down fails the healthcheck, drains the server and then closes the Kafka service.
+func TestUnitShutdown(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a running service", t, func() {
+		defer draining.Store(false)
+
+		var events []string
+		srv := &fakeServer{events: &events}
+		background, cancel := context.WithCancel(context.Background())
+		stopBackground = cancel
+		kSvc := mocks.NewMockKafkaService(mockCtrl)
+
+		Convey("When it shuts down", func() {
+			kSvc.EXPECT().Close().DoAndReturn(func() error {
+				events = append(events, "kafka")
+				return nil
+			})
+			start := time.Now()
+			err := Shutdown(srv, kSvc, 0, 0)
+
+			Convey("Then the healthcheck fails before the server drains, and Kafka is closed last", func() {
+				So(err, ShouldBeNil)
+				So(srv.healthcheck, ShouldEqual, http.StatusServiceUnavailable)
+				So(events, ShouldResemble, []string{"server", "kafka"})
+			})
+
+			Convey("Then background work is stopped", func() {
+				So(background.Err(), ShouldEqual, context.Canceled)
+			})
+
+			Convey("Then the server is given the default grace period to drain", func() {
+				So(srv.deadline, ShouldHappenWithin, time.Second, start.Add(DefaultShutdownGrace))
+			})
+		})
+
+		Convey("When the server can't drain in time, then Kafka is still closed and the error returned", func() {
+			srv.err = context.DeadlineExceeded
+			kSvc.EXPECT().Close().Return(errors.New("error closing"))
+			err := Shutdown(srv, kSvc, 0, time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "error closing")
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package helpers
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// DeltaBody is the body of a delta request. It is read from the request once and decoded at most once, then shared by
+// validation, primary id extraction and publishing, so large deltas aren't read or parsed again at each step. A
+// DeltaBody belongs to a single request and isn't safe for concurrent use.
+type DeltaBody struct {
+	data    string
+	value   interface{}
+	err     error
+	decoded bool
+}
+
+// NewDeltaBody returns the DeltaBody of a request body which has already been read.
+func NewDeltaBody(data string) *DeltaBody {
+	return &DeltaBody{data: data}
+}
+
+// String returns the body as it is to be published, including any default values set by validation.
+func (b *DeltaBody) String() string {
+	return b.data
+}
+
+// Len returns the length of the body in bytes.
+func (b *DeltaBody) Len() int {
+	return len(b.data)
+}
+
+// Decode returns the decoded JSON body, decoding it on the first call. Numbers are decoded as json.Number so they
+// keep their original precision. The value is shared, so callers mustn't change it, other than validation setting
+// default values before calling Encode.
+func (b *DeltaBody) Decode() (interface{}, error) {
+	if !b.decoded {
+		dec := json.NewDecoder(strings.NewReader(b.data))
+		dec.UseNumber()
+		b.err = dec.Decode(&b.value)
+		b.decoded = true
+	}
+	return b.value, b.err
+}
+
+// Encode encodes the decoded body again, once validation has set default values in it, so they are published. The
+// decoded value is kept.
+func (b *DeltaBody) Encode() error {
+	data, err := json.Marshal(b.value)
+	if err != nil {
+		return err
+	}
+	b.data = string(data)
+	return nil
+}
@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestUnitDeltaBody asserts that a delta body is decoded once, and published as it was last encoded.
+func TestUnitDeltaBody(t *testing.T) {
+
+	Convey("Given a delta body", t, func() {
+
+		b := NewDeltaBody(`{"company_number": "00006400", "count": 1234567890123456789}`)
+
+		Convey("When I decode it, then numbers keep their precision", func() {
+			value, err := b.Decode()
+			So(err, ShouldBeNil)
+			So(value.(map[string]interface{})["count"], ShouldEqual, json.Number("1234567890123456789"))
+		})
+
+		Convey("When I decode it twice, then the same value is returned", func() {
+			first, _ := b.Decode()
+			first.(map[string]interface{})["company_number"] = "changed"
+			second, _ := b.Decode()
+			So(second.(map[string]interface{})["company_number"], ShouldEqual, "changed")
+		})
+
+		Convey("When I change its decoded value and encode it, then the new body is published and the value kept", func() {
+			value, _ := b.Decode()
+			value.(map[string]interface{})["kind"] = "officer"
+			So(b.Encode(), ShouldBeNil)
+			So(b.String(), ShouldEqual, `{"company_number":"00006400","count":1234567890123456789,"kind":"officer"}`)
+			So(b.Len(), ShouldEqual, len(b.String()))
+			again, err := b.Decode()
+			So(err, ShouldBeNil)
+			So(again, ShouldResemble, value)
+		})
+	})
+
+	Convey("Given a delta body which isn't valid JSON", t, func() {
+
+		b := NewDeltaBody(`{"company_number": `)
+
+		Convey("When I decode it, then the error is returned every time", func() {
+			_, err := b.Decode()
+			So(err, ShouldNotBeNil)
+			_, err = b.Decode()
+			So(err, ShouldNotBeNil)
+			So(b.String(), ShouldEqual, `{"company_number": `)
+		})
+	})
+}
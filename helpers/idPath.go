@@ -0,0 +1,143 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrIdNotFound is returned when a request body doesn't contain any value at the primary id path.
+var ErrIdNotFound = errors.New("primary id not found in request body")
+
+// IdPath is a parsed JSON path used to find the primary ids in a delta request body. It supports the root ($), child
+// fields (.name), array wildcards ([*]) and array indexes ([0]), e.g. $.officers[*].internal_id.
+type IdPath struct {
+	expr  string
+	steps []idPathStep
+}
+
+// idPathStep is a single step of an IdPath. Exactly one of field, wildcard or a non-negative index applies.
+type idPathStep struct {
+	field    string
+	wildcard bool
+	index    int
+}
+
+// ParseIdPath parses a JSON path expression into an IdPath.
+func ParseIdPath(expr string) (IdPath, error) {
+
+	rest, ok := strings.CutPrefix(expr, "$")
+	if !ok {
+		return IdPath{}, fmt.Errorf("primary id path '%s' must start with $", expr)
+	}
+
+	var steps []idPathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			field := rest[1 : end+1]
+			if field == "" {
+				return IdPath{}, fmt.Errorf("primary id path '%s' has an empty field name", expr)
+			}
+			steps = append(steps, idPathStep{field: field, index: -1})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return IdPath{}, fmt.Errorf("primary id path '%s' has an unclosed [", expr)
+			}
+			selector := rest[1:end]
+			if selector == "*" {
+				steps = append(steps, idPathStep{wildcard: true, index: -1})
+			} else if i, err := strconv.Atoi(selector); err == nil && i >= 0 {
+				steps = append(steps, idPathStep{index: i})
+			} else {
+				return IdPath{}, fmt.Errorf("primary id path '%s' has an unsupported selector [%s]", expr, selector)
+			}
+			rest = rest[end+1:]
+		default:
+			return IdPath{}, fmt.Errorf("primary id path '%s' has an unexpected character '%c'", expr, rest[0])
+		}
+	}
+
+	if len(steps) == 0 {
+		return IdPath{}, fmt.Errorf("primary id path '%s' doesn't select a field", expr)
+	}
+
+	return IdPath{expr: expr, steps: steps}, nil
+}
+
+// String returns the expression the IdPath was parsed from.
+func (p IdPath) String() string {
+	return p.expr
+}
+
+// ExtractBody returns every string or number found at the path in a delta body, in document order, decoding the body
+// if it hasn't been already. ErrIdNotFound is returned if there are none.
+func (p IdPath) ExtractBody(b *DeltaBody) ([]string, error) {
+
+	body, err := b.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.find(body)
+}
+
+// find returns every string or number found at the path in a decoded JSON body.
+func (p IdPath) find(body interface{}) ([]string, error) {
+
+	nodes := []interface{}{body}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, node := range nodes {
+			next = append(next, step.apply(node)...)
+		}
+		nodes = next
+	}
+
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		switch v := node.(type) {
+		case string:
+			if v != "" {
+				ids = append(ids, v)
+			}
+		case json.Number:
+			ids = append(ids, v.String())
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w at %s", ErrIdNotFound, p.expr)
+	}
+
+	return ids, nil
+}
+
+// apply returns the values selected by the step from a decoded JSON node. Nodes of the wrong type select nothing.
+func (s idPathStep) apply(node interface{}) []interface{} {
+	switch {
+	case s.field != "":
+		if obj, ok := node.(map[string]interface{}); ok {
+			if v, ok := obj[s.field]; ok {
+				return []interface{}{v}
+			}
+		}
+	case s.wildcard:
+		if arr, ok := node.([]interface{}); ok {
+			return arr
+		}
+	default:
+		if arr, ok := node.([]interface{}); ok && s.index < len(arr) {
+			return []interface{}{arr[s.index]}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const officersExample = `{"officers": [{"internal_id": "3002276133", "company_number": "00006400"},
+{"internal_id": "3002276134", "company_number": "00006400", "identification": {"internal_id": "nested"}}]}`
+
+// TestUnitParseIdPath asserts that supported JSON paths are parsed and unsupported ones are rejected.
+func TestUnitParseIdPath(t *testing.T) {
+
+	Convey("Given I parse a JSON path", t, func() {
+
+		Convey("When the path is supported, then no error is returned", func() {
+			for _, expr := range []string{"$.charges_id", "$.officers[*].internal_id", "$.officers[0].internal_id", "$.a.b[*][1].c"} {
+				p, err := ParseIdPath(expr)
+				So(err, ShouldBeNil)
+				So(p.String(), ShouldEqual, expr)
+			}
+		})
+
+		Convey("When the path is not supported, then an error is returned", func() {
+			for _, expr := range []string{"", "$", "charges_id", "$..charges_id", "$.officers[", "$.officers[?(@.x)]", "$.officers[-1]", "$ .x"} {
+				_, err := ParseIdPath(expr)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+// TestUnitIdPathExtractBody asserts that every id at a path is returned, and that a missing path is clearly reported.
+func TestUnitIdPathExtractBody(t *testing.T) {
+
+	Convey("Given a delta body containing an array of officers", t, func() {
+
+		Convey("When I extract with a wildcard path, then every id is returned in order", func() {
+			p, _ := ParseIdPath("$.officers[*].internal_id")
+			ids, err := p.ExtractBody(NewDeltaBody(officersExample))
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{"3002276133", "3002276134"})
+		})
+
+		Convey("When I extract with an index, then only that id is returned", func() {
+			p, _ := ParseIdPath("$.officers[1].internal_id")
+			ids, err := p.ExtractBody(NewDeltaBody(officersExample))
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{"3002276134"})
+		})
+
+		Convey("When I extract a top level field, then nested fields of the same name are ignored", func() {
+			p, _ := ParseIdPath("$.internal_id")
+			_, err := p.ExtractBody(NewDeltaBody(officersExample))
+			So(errors.Is(err, ErrIdNotFound), ShouldBeTrue)
+		})
+
+		Convey("When the path doesn't exist, then ErrIdNotFound is returned", func() {
+			p, _ := ParseIdPath("$.officers[*].officer_id")
+			ids, err := p.ExtractBody(NewDeltaBody(officersExample))
+			So(ids, ShouldBeNil)
+			So(errors.Is(err, ErrIdNotFound), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "$.officers[*].officer_id")
+		})
+
+		Convey("When the path selects an object, then ErrIdNotFound is returned", func() {
+			p, _ := ParseIdPath("$.officers[*]")
+			_, err := p.ExtractBody(NewDeltaBody(officersExample))
+			So(errors.Is(err, ErrIdNotFound), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a delta body with ids the regex extraction couldn't match", t, func() {
+
+		Convey("When the id contains other characters or is a number, then it is returned as a string", func() {
+			p, _ := ParseIdPath("$.ids[*]")
+			ids, err := p.ExtractBody(NewDeltaBody(`{"ids": ["AB/12 3", 1234567890123456789, ""]}`))
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{"AB/12 3", "1234567890123456789"})
+		})
+	})
+
+	Convey("Given a delta body which isn't valid JSON", t, func() {
+
+		Convey("When I extract an id, then the parse error is returned", func() {
+			p, _ := ParseIdPath("$.charges_id")
+			_, err := p.ExtractBody(NewDeltaBody(`{"charges_id": `))
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, ErrIdNotFound), ShouldBeFalse)
+		})
+	})
+}
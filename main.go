@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/companieshouse/chs-delta-api/services"
+	"github.com/companieshouse/chs-delta-api/tracing"
 	"net/http"
 	"os"
 	"os/signal"
@@ -29,10 +30,26 @@ func main() {
 		return
 	}
 
+	// Export spans, if an exporter is configured, before any requests are traced.
+	shutdownTracing, err := tracing.Init(cfg)
+	if err != nil {
+		log.Error(fmt.Errorf("error configuring tracing: %s. Exiting", err), nil)
+		os.Exit(1)
+		return
+	}
+
 	// Create router and register endpoints.
 	mainRouter := mux.NewRouter()
-	kSvc := services.NewKafkaService()
-	if err := handlers.Register(mainRouter, cfg, &kSvc); err != nil {
+	svc, err := services.NewPublisher(cfg)
+	if err != nil {
+		log.Error(fmt.Errorf("error creating publisher: %s. Exiting", err), nil)
+		os.Exit(1)
+		return
+	}
+	if cfg.OutboxDir != "" {
+		svc = services.NewOutboxService(svc, cfg.OutboxDir, int64(cfg.OutboxMaxBytes))
+	}
+	if err := handlers.Register(mainRouter, cfg, svc); err != nil {
 		log.Error(fmt.Errorf("error registering routes: %s. Exiting", err), nil)
 		return
 	}
@@ -62,14 +79,19 @@ func main() {
 	<-stop
 
 	log.Info("shutting down server...")
-	timeout := time.Duration(5) * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	delay := time.Duration(cfg.ShutdownDelaySecs) * time.Second
+	grace := time.Duration(cfg.ShutdownGraceSecs) * time.Second
+	err = handlers.Shutdown(h, svc, delay, grace)
+
+	// Flush the spans of the last requests and sends once they have finished.
+	ctx, cancel := context.WithTimeout(context.Background(), tracing.FlushTimeout)
 	defer cancel()
+	if tErr := shutdownTracing(ctx); tErr != nil {
+		log.Error(fmt.Errorf("error flushing spans: %s", tErr))
+	}
 
-	err = h.Shutdown(ctx)
 	if err != nil {
-		log.Error(fmt.Errorf("failed to shutdown server gracefully: [%v]", err))
-	} else {
-		log.Info("server shutdown gracefully")
+		log.Error(fmt.Errorf("failed to shutdown gracefully: [%v]", err))
+		os.Exit(1)
 	}
 }
@@ -5,6 +5,7 @@ import "fmt"
 // CHError is a struct representation of the CH Error object.
 type CHError struct {
 	Error        string                 `json:"error"`
+	ErrorCode    string                 `json:"error_code"`
 	ErrorValues  map[string]interface{} `json:"error_values"`
 	Location     string                 `json:"location"`
 	LocationType string                 `json:"location_type"`
@@ -13,6 +14,6 @@ type CHError struct {
 
 // String provides a formatted string of a CHError.
 func (che CHError) String() string {
-	return fmt.Sprintf("{Error:%s, ErrorValues:%s, Location:%s, LocationType:%s, Type:%s}",
-		che.Error, che.ErrorValues, che.Location, che.LocationType, che.Type)
+	return fmt.Sprintf("{Error:%s, ErrorCode:%s, ErrorValues:%s, Location:%s, LocationType:%s, Type:%s}",
+		che.Error, che.ErrorCode, che.ErrorValues, che.Location, che.LocationType, che.Type)
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// DeltaMetadata describes a delta received by the API. It is published alongside the chs-delta as Kafka record headers
+// so consumers can filter and trace deltas without deserialising them. TraceParent and TraceState hold the W3C trace
+// context of the request's span, so a delta held in the outbox is still traced when it is sent.
+type DeltaMetadata struct {
+	ContextId   string
+	DeltaType   string
+	IsDelete    bool
+	PrimaryIds  []string
+	SpecVersion string
+	ReceivedAt  time.Time
+	TraceParent string `json:",omitempty"`
+	TraceState  string `json:",omitempty"`
+}
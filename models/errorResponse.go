@@ -0,0 +1,8 @@
+package models
+
+// ErrorResponse is the body of every error response other than a validation failure. It holds the id of the request
+// which failed, so it can be found in the logs.
+type ErrorResponse struct {
+	RequestId string    `json:"request_id"`
+	Errors    []CHError `json:"errors"`
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// States of a circuit breaker.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failed sends which open the breaker when none is configured.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long the breaker stays open when no cooldown is configured.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// Used for unit testing. Allows the breaker's clock to be controlled.
+var (
+	callNow = time.Now
+)
+
+// CircuitOpenError is returned instead of sending a message while Kafka is failing. RetryAfter is how long until the
+// breaker lets a send through again.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("kafka circuit breaker is open, retry after %s", e.RetryAfter)
+}
+
+// BreakerStater is implemented by Kafka services which stop sending while Kafka is failing.
+type BreakerStater interface {
+	BreakerState() string
+}
+
+// circuitBreaker fails sends fast once a number of consecutive sends have failed. After a cooldown it lets a single
+// trial send through, closing again if it succeeds or reopening if it fails.
+type circuitBreaker struct {
+	mtx       sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+// newCircuitBreaker returns a closed circuitBreaker, using the defaults for values which aren't greater than zero.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// allow returns a CircuitOpenError if a send shouldn't be attempted.
+func (cb *circuitBreaker) allow() error {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		remaining := cb.cooldown - callNow().Sub(cb.openedAt)
+		if remaining > 0 {
+			return &CircuitOpenError{RetryAfter: remaining}
+		}
+		cb.state = BreakerHalfOpen
+		cb.trial = true
+	case BreakerHalfOpen:
+		if cb.trial {
+			return &CircuitOpenError{RetryAfter: cb.cooldown}
+		}
+		cb.trial = true
+	}
+	return nil
+}
+
+// success records a successful send, closing the breaker.
+func (cb *circuitBreaker) success() {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	cb.state = BreakerClosed
+	cb.failures = 0
+	cb.trial = false
+}
+
+// failure records a send which failed because Kafka is unavailable, opening the breaker if the threshold is reached or
+// the trial send failed.
+func (cb *circuitBreaker) failure() {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	cb.failures++
+	if cb.state == BreakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = BreakerOpen
+		cb.openedAt = callNow()
+	}
+	cb.trial = false
+}
+
+// release ends a trial send whose outcome says nothing about Kafka's availability, letting another send try.
+func (cb *circuitBreaker) release() {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	cb.trial = false
+}
+
+// State returns the current state of the breaker.
+func (cb *circuitBreaker) State() string {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	if cb.state == BreakerOpen && callNow().Sub(cb.openedAt) >= cb.cooldown {
+		return BreakerHalfOpen
+	}
+	return cb.state
+}
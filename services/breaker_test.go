@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// TestUnitCircuitBreaker asserts that the breaker opens after consecutive failures, and lets a single trial through
+// once its cooldown has passed.
+func TestUnitCircuitBreaker(t *testing.T) {
+
+	Convey("Given a closed circuit breaker", t, func() {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		callNow = func() time.Time { return now }
+		defer func() { callNow = time.Now }()
+
+		cb := newCircuitBreaker(3, 10*time.Second)
+		So(cb.State(), ShouldEqual, BreakerClosed)
+
+		Convey("When fewer sends than the threshold fail in a row, then it stays closed", func() {
+			cb.failure()
+			cb.failure()
+			cb.success()
+			cb.failure()
+			cb.failure()
+			So(cb.State(), ShouldEqual, BreakerClosed)
+			So(cb.allow(), ShouldBeNil)
+		})
+
+		Convey("When the threshold is reached", func() {
+			for i := 0; i < 3; i++ {
+				cb.failure()
+			}
+
+			Convey("Then it is open and fails fast with the time left", func() {
+				now = now.Add(4 * time.Second)
+				So(cb.State(), ShouldEqual, BreakerOpen)
+				err := cb.allow()
+				var openErr *CircuitOpenError
+				So(errors.As(err, &openErr), ShouldBeTrue)
+				So(openErr.RetryAfter, ShouldEqual, 6*time.Second)
+			})
+
+			Convey("Then after the cooldown a single trial is allowed", func() {
+				now = now.Add(10 * time.Second)
+				So(cb.State(), ShouldEqual, BreakerHalfOpen)
+				So(cb.allow(), ShouldBeNil)
+				So(cb.allow(), ShouldNotBeNil)
+
+				Convey("When the trial succeeds, then it closes", func() {
+					cb.success()
+					So(cb.State(), ShouldEqual, BreakerClosed)
+					So(cb.allow(), ShouldBeNil)
+				})
+
+				Convey("When the trial fails, then it opens again", func() {
+					cb.failure()
+					So(cb.State(), ShouldEqual, BreakerOpen)
+					So(cb.allow(), ShouldNotBeNil)
+				})
+
+				Convey("When the trial is inconclusive, then another trial is allowed", func() {
+					cb.release()
+					So(cb.allow(), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs.go/log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const deadLetterExt = ".json"
+
+// HeaderError is the name of the Kafka record header holding the error which dead-lettered a delta.
+const HeaderError = "error"
+
+// ErrDeadLetterNotFound is returned when a dead letter doesn't exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// deadLetterIdPattern matches the ids given to dead letters, which are also their file names.
+var deadLetterIdPattern = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+
+// deadLetterSeq distinguishes dead letters recorded in the same nanosecond.
+var deadLetterSeq atomic.Uint64
+
+// DeadLetter is a delta which couldn't be published to its topic.
+type DeadLetter struct {
+	Id       string               `json:"id"`
+	Topic    string               `json:"topic"`
+	Data     string               `json:"data"`
+	Meta     models.DeltaMetadata `json:"meta"`
+	Error    string               `json:"error"`
+	Attempts int                  `json:"attempts"`
+	FailedAt time.Time            `json:"failed_at"`
+}
+
+// DeadLetteredError is returned when a delta couldn't be published and has been dead-lettered.
+type DeadLetteredError struct {
+	Id  string
+	Err error
+}
+
+func (e *DeadLetteredError) Error() string {
+	return fmt.Sprintf("delta dead-lettered as %s: %s", e.Id, e.Err)
+}
+
+func (e *DeadLetteredError) Unwrap() error {
+	return e.Err
+}
+
+// DeadLetterStore lists the dead letters held locally so they can be re-driven.
+type DeadLetterStore interface {
+	List() ([]DeadLetter, error)
+	Get(id string) (DeadLetter, error)
+	Delete(id string) error
+}
+
+// DeadLetterStorer is implemented by Kafka services which keep dead letters in a DeadLetterStore.
+type DeadLetterStorer interface {
+	DeadLetterStore() DeadLetterStore
+}
+
+// newDeadLetter returns a DeadLetter with a new id.
+func newDeadLetter(topic, data string, meta models.DeltaMetadata, err error, attempts int) DeadLetter {
+	now := callNow()
+	return DeadLetter{
+		Id:       fmt.Sprintf("%d-%d", now.UnixNano(), deadLetterSeq.Add(1)),
+		Topic:    topic,
+		Data:     data,
+		Meta:     meta,
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: now,
+	}
+}
+
+// FileDeadLetterSink keeps dead letters as JSON files in a local directory.
+type FileDeadLetterSink struct {
+	dir string
+}
+
+// NewFileDeadLetterSink returns a FileDeadLetterSink keeping dead letters in dir, creating it if needed.
+func NewFileDeadLetterSink(dir string) (*FileDeadLetterSink, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterSink{dir: dir}, nil
+}
+
+// Put durably writes a dead letter.
+func (s *FileDeadLetterSink) Put(dl DeadLetter) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(s.dir, dl.Id+deadLetterExt, b)
+}
+
+// List returns every dead letter, oldest first.
+func (s *FileDeadLetterSink) List() ([]DeadLetter, error) {
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]DeadLetter, 0, len(entries))
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), deadLetterExt)
+		if !ok || !deadLetterIdPattern.MatchString(id) {
+			continue
+		}
+		dl, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, dl)
+	}
+
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].FailedAt.Before(deadLetters[j].FailedAt)
+	})
+
+	return deadLetters, nil
+}
+
+// Get returns a dead letter, or ErrDeadLetterNotFound if it doesn't exist.
+func (s *FileDeadLetterSink) Get(id string) (DeadLetter, error) {
+	var dl DeadLetter
+	if !deadLetterIdPattern.MatchString(id) {
+		return dl, ErrDeadLetterNotFound
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, id+deadLetterExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return dl, ErrDeadLetterNotFound
+	} else if err != nil {
+		return dl, err
+	}
+	err = json.Unmarshal(b, &dl)
+	return dl, err
+}
+
+// Delete removes a dead letter, or returns ErrDeadLetterNotFound if it doesn't exist.
+func (s *FileDeadLetterSink) Delete(id string) error {
+	if !deadLetterIdPattern.MatchString(id) {
+		return ErrDeadLetterNotFound
+	}
+	err := os.Remove(filepath.Join(s.dir, id+deadLetterExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrDeadLetterNotFound
+	}
+	return err
+}
+
+// deadLetter records a delta which couldn't be published. It is sent to the dead-letter topic if one is configured,
+// falling back to the local sink if that fails or no topic is configured. An error is returned if neither worked.
+func (kSvc *KafkaServiceImpl) deadLetter(dl DeadLetter) error {
+
+	logData := log.Data{config.TopicKey: dl.Topic, config.DeadLetterIdKey: dl.Id, config.AttemptKey: dl.Attempts}
+
+	if kSvc.deadLetterTopic != "" {
+		err := kSvc.sendDeadLetter(dl)
+		if err == nil {
+			logData[config.DeadLetterTopicKey] = kSvc.deadLetterTopic
+			log.InfoC(dl.Meta.ContextId, "Dead-lettered delta", logData)
+			return nil
+		}
+		log.ErrorC(dl.Meta.ContextId, err, log.Data{config.DeadLetterTopicKey: kSvc.deadLetterTopic, config.MessageKey: "error sending delta to dead-letter topic"})
+	}
+
+	if kSvc.deadLetters != nil {
+		err := kSvc.deadLetters.Put(dl)
+		if err == nil {
+			log.InfoC(dl.Meta.ContextId, "Dead-lettered delta", logData)
+			return nil
+		}
+		log.ErrorC(dl.Meta.ContextId, err, log.Data{config.MessageKey: "error writing delta to dead-letter sink"})
+	}
+
+	return errors.New("no dead-letter topic or sink available")
+}
+
+// sendDeadLetter makes a single attempt to send a dead letter as JSON to the dead-letter topic, with the same key and
+// headers as the original delta plus the error.
+func (kSvc *KafkaServiceImpl) sendDeadLetter(dl DeadLetter) error {
+
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   kSvc.deadLetterTopic,
+		Value:   sarama.ByteEncoder(b),
+		Headers: append(buildHeaders(dl.Data, dl.Meta), sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(dl.Error)}),
+	}
+	if len(dl.Meta.PrimaryIds) > 0 {
+		msg.Key = sarama.StringEncoder(dl.Meta.PrimaryIds[0])
+	}
+
+	_, _, err = callSend(kSvc, msg)
+	return err
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs-delta-api/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// TestUnitFileDeadLetterSink asserts that dead letters can be written, listed, read and deleted.
+func TestUnitFileDeadLetterSink(t *testing.T) {
+
+	Convey("Given a file dead-letter sink", t, func() {
+		sink, err := NewFileDeadLetterSink(t.TempDir())
+		So(err, ShouldBeNil)
+
+		first := newDeadLetter(Topic, Data, models.DeltaMetadata{ContextId: ContextId}, errors.New("first"), 3)
+		second := newDeadLetter(Topic, Data, models.DeltaMetadata{ContextId: ContextId}, errors.New("second"), 1)
+		second.FailedAt = first.FailedAt.Add(time.Second)
+		So(sink.Put(second), ShouldBeNil)
+		So(sink.Put(first), ShouldBeNil)
+
+		Convey("When I list the dead letters, then they are returned oldest first", func() {
+			deadLetters, err := sink.List()
+			So(err, ShouldBeNil)
+			So(deadLetters, ShouldHaveLength, 2)
+			So(deadLetters[0].Id, ShouldEqual, first.Id)
+			So(deadLetters[0].Error, ShouldEqual, "first")
+			So(deadLetters[0].Attempts, ShouldEqual, 3)
+			So(deadLetters[1].Id, ShouldEqual, second.Id)
+		})
+
+		Convey("When I delete a dead letter, then it can no longer be read", func() {
+			So(sink.Delete(first.Id), ShouldBeNil)
+			_, err := sink.Get(first.Id)
+			So(err, ShouldEqual, ErrDeadLetterNotFound)
+			So(sink.Delete(first.Id), ShouldEqual, ErrDeadLetterNotFound)
+		})
+
+		Convey("When I read an id outside the sink, then it is not found", func() {
+			_, err := sink.Get("../" + first.Id)
+			So(err, ShouldEqual, ErrDeadLetterNotFound)
+			So(sink.Delete("../"+first.Id), ShouldEqual, ErrDeadLetterNotFound)
+		})
+	})
+}
+
+// TestUnitSendMessageDeadLetters asserts that deltas which can't be sent are dead-lettered rather than dropped.
+func TestUnitSendMessageDeadLetters(t *testing.T) {
+
+	Convey("Given a Kafka service with a dead-letter topic and sink", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+		k.retry = newRetryPolicy(2, time.Millisecond)
+		k.deadLetterTopic = "chs-delta-dlt"
+		k.deadLetters, _ = NewFileDeadLetterSink(t.TempDir())
+		callSleep = func(time.Duration) {}
+		defer func() { callSleep = time.Sleep }()
+
+		meta := models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}}
+		var sent []*sarama.ProducerMessage
+		dltErr := error(nil)
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			sent = append(sent, msg)
+			if msg.Topic == "chs-delta-dlt" {
+				return 0, 0, dltErr
+			}
+			return -1, -1, sarama.ErrMessageSizeTooLarge
+		}
+
+		Convey("When the delta can't be sent", func() {
+			err := k.SendMessage(Topic, Data, meta)
+
+			Convey("Then it is sent to the dead-letter topic with the error", func() {
+				var dlErr *DeadLetteredError
+				So(errors.As(err, &dlErr), ShouldBeTrue)
+				So(errors.Is(err, sarama.ErrMessageSizeTooLarge), ShouldBeTrue)
+				So(sent, ShouldHaveLength, 2)
+				So(sent[1].Key, ShouldEqual, sarama.StringEncoder(Key))
+				So(string(sent[1].Headers[len(sent[1].Headers)-1].Key), ShouldEqual, HeaderError)
+
+				deadLetters, _ := k.deadLetters.List()
+				So(deadLetters, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When neither the delta nor the dead letter can be sent", func() {
+			dltErr = sarama.ErrOutOfBrokers
+			err := k.SendMessage(Topic, Data, meta)
+
+			Convey("Then it is written to the sink with the original topic, body, error and attempts", func() {
+				var dlErr *DeadLetteredError
+				So(errors.As(err, &dlErr), ShouldBeTrue)
+
+				dl, err := k.deadLetters.Get(dlErr.Id)
+				So(err, ShouldBeNil)
+				So(dl.Topic, ShouldEqual, Topic)
+				So(dl.Data, ShouldEqual, Data)
+				So(dl.Meta.ContextId, ShouldEqual, ContextId)
+				So(dl.Error, ShouldEqual, sarama.ErrMessageSizeTooLarge.Error())
+				So(dl.Attempts, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the schema can't be marshalled, then the delta is dead-lettered", func() {
+			k.schema = BadSchema
+			err := k.SendMessage(Topic, Data, meta)
+			var dlErr *DeadLetteredError
+			So(errors.As(err, &dlErr), ShouldBeTrue)
+		})
+
+		Convey("When sends fail with retriable errors while they are being held", func() {
+			k.holdRetriableFailures()
+			callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+				sent = append(sent, msg)
+				return -1, -1, sarama.ErrOutOfBrokers
+			}
+			err := k.SendMessage(Topic, Data, meta)
+
+			Convey("Then the delta isn't dead-lettered", func() {
+				So(err, ShouldEqual, sarama.ErrOutOfBrokers)
+				So(sent, ShouldHaveLength, 2)
+				deadLetters, _ := k.deadLetters.List()
+				So(deadLetters, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -1,52 +1,130 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs-delta-api/config"
 	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs-delta-api/tracing"
 	"github.com/companieshouse/chs.go/avro"
 	"github.com/companieshouse/chs.go/avro/schema"
-	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/chs.go/log"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	SchemaName = "chs-delta"
+
+	// DefaultMaxMessageBytes is the largest message the producer sends when no limit is configured, which matches the
+	// default message.max.bytes of the brokers.
+	DefaultMaxMessageBytes = 1000000
+
+	// DefaultKafkaVersion is the Kafka protocol version the producer uses when none is configured.
+	DefaultKafkaVersion = "0.11.0.0"
+
+	// recordOverhead is the most bytes a Kafka record adds to its key, value and headers, as counted by the producer.
+	recordOverhead = 5*binary.MaxVarintLen32 + binary.MaxVarintLen64 + 1
+	// headerOverhead is the most bytes a Kafka record adds to each of its headers.
+	headerOverhead = 2 * binary.MaxVarintLen32
+	// traceParentBytes is the length of a W3C traceparent header value.
+	traceParentBytes = 55
+)
+
+// minKafkaVersion is the oldest Kafka protocol version the producer can use, as older versions can't send the record
+// headers every delta is published with.
+var minKafkaVersion = sarama.V0_11_0_0
+
+// ErrMessageTooLarge is returned when a delta would make a message larger than the producer is allowed to send.
+var ErrMessageTooLarge = errors.New("message is larger than the producer's max message size")
+
+// Names of the Kafka record headers published with every chs-delta.
+const (
+	HeaderContextId     = "context_id"
+	HeaderDeltaType     = "delta_type"
+	HeaderIsDelete      = "is_delete"
+	HeaderPrimaryIds    = "primary_ids"
+	HeaderContentSha256 = "content_sha256"
+	HeaderSpecVersion   = "spec_version"
+	HeaderReceivedAt    = "received_at"
+	HeaderTraceParent   = tracing.HeaderTraceParent
+	HeaderTraceState    = tracing.HeaderTraceState
+)
+
+// Names of the span attributes describing a delta, alongside the OpenTelemetry messaging attributes.
+const (
+	AttributePrimaryIds = "chs_delta.primary_ids"
+	AttributeAttempt    = "chs_delta.attempt"
 )
 
 // Used for unit testing. By Adding variables which link to certain package level functions / methods, we can
 // override them during unit testing and change them to point to mock implementations to assert functionality.
 var (
 	callSchemaGet   = schema.Get
-	callProducerNew = producer.New
+	callProducerNew = sarama.NewSyncProducer
 	callSend        = sendViaProducer
+	callClose       = closeProducer
 )
 
 // KafkaService defines all Methods needed to successfully send a message onto a Kafka topic.
 type KafkaService interface {
 	Init(cfg *config.Config) error
-	SendMessage(topic, data, contextId string, isDelete bool) error
+	SendMessage(topic, data string, meta models.DeltaMetadata) error
+	Close() error
 }
 
 // KafkaServiceImpl is a concrete implementation of the KafkaService interface.
 type KafkaServiceImpl struct {
-	schema string
-	P      *producer.Producer
+	schemaMtx       *sync.RWMutex
+	schema          string
+	schemaId        int
+	schemaSource    string
+	wireFormat      bool
+	maxMessageBytes int
+	P               sarama.SyncProducer
+	retry           retryPolicy
+	breaker         *circuitBreaker
+	deadLetterTopic string
+	deadLetters     *FileDeadLetterSink
+	holdRetriable   bool
+	stopReconcile   chan struct{}
+	brokers         []string
+	clientMtx       *sync.Mutex
+	client          sarama.Client
 }
 
 // NewKafkaService returns a KafkaServiceImpl that isn't configured.
 func NewKafkaService() KafkaServiceImpl {
-	return KafkaServiceImpl{}
+	return KafkaServiceImpl{schemaMtx: &sync.RWMutex{}, clientMtx: &sync.Mutex{}}
 }
 
 // Init initialises a KafkaService using a provided config.
 func (kSvc *KafkaServiceImpl) Init(cfg *config.Config) error {
 
-	// Initialise the avro schema.
+	// Initialise the avro schema, falling back to a cached or embedded copy if the registry is unavailable.
 	sch, err := initSchema(cfg)
-	if err != nil {
+	fallback := ""
+	if errors.Is(err, errIncompatibleSchema) {
 		return err
+	} else if err != nil {
+		var fbErr error
+		if sch, fallback, fbErr = fallbackSchema(cfg); fbErr != nil {
+			log.Error(fmt.Errorf("error finding a fallback %s schema: %s", SchemaName, fbErr))
+			return err
+		}
+		log.Info("Schema registry unavailable, using fallback schema", log.Data{"schema_name": SchemaName, "source": fallback, config.SchemaVersionKey: sch.Version})
+	} else {
+		cacheSchema(cfg, sch)
 	}
 
 	// Initialise the kafka producer.
@@ -55,28 +133,83 @@ func (kSvc *KafkaServiceImpl) Init(cfg *config.Config) error {
 		return err
 	}
 
-	kSvc.schema = sch
+	// Initialise the local dead-letter sink, if one is configured.
+	if cfg.DeadLetterDir != "" {
+		sink, err := NewFileDeadLetterSink(cfg.DeadLetterDir)
+		if err != nil {
+			log.Error(fmt.Errorf("error initialising dead-letter sink: %s", err))
+			return err
+		}
+		kSvc.deadLetters = sink
+	}
+
+	kSvc.schemaMtx.Lock()
+	kSvc.schema = sch.Schema
+	kSvc.schemaId = sch.Id
+	kSvc.schemaSource = fallback
+	kSvc.schemaMtx.Unlock()
+	kSvc.wireFormat = cfg.SchemaWireFormat
+	kSvc.maxMessageBytes = maxMessageBytes(cfg)
 	kSvc.P = p
+	kSvc.brokers = cfg.BrokerAddr
+	kSvc.deadLetterTopic = cfg.DeadLetterTopic
+	kSvc.retry = newRetryPolicy(cfg.KafkaSendAttempts, time.Duration(cfg.KafkaRetryBackoffMs)*time.Millisecond)
+	kSvc.breaker = newCircuitBreaker(cfg.KafkaBreakerThreshold, time.Duration(cfg.KafkaBreakerCooldownSecs)*time.Second)
+
+	// Reconcile the fallback schema with the registry once it can be reached.
+	if fallback != "" {
+		interval := time.Duration(cfg.SchemaRefreshSecs) * time.Second
+		if interval <= 0 {
+			interval = DefaultSchemaRefresh
+		}
+		kSvc.stopReconcile = make(chan struct{})
+		go kSvc.reconcileSchema(cfg, interval, kSvc.stopReconcile)
+	}
 
 	return nil
 }
 
-func initSchema(cfg *config.Config) (string, error) {
-	// Retrieve the generic chs-delta avro schema.
-	log.Trace("Get schema from Avro", log.Data{"schema_name": SchemaName})
-	sch, err := callSchemaGet(cfg.SchemaRegistryURL, SchemaName)
+// initSchema retrieves the chs-delta avro schema. When a version is pinned or the Confluent wire format is used, the
+// schema is fetched from the registry with its id and checked to be compatible with models.ChsDelta.
+func initSchema(cfg *config.Config) (registeredSchema, error) {
+
+	if cfg.SchemaVersion == 0 && !cfg.SchemaWireFormat {
+		// Retrieve the generic chs-delta avro schema.
+		log.Trace("Get schema from Avro", log.Data{"schema_name": SchemaName})
+		sch, err := callSchemaGet(cfg.SchemaRegistryURL, SchemaName)
+		if err != nil {
+			log.Error(fmt.Errorf("error receiving %s schema: %s", SchemaName, err))
+			return registeredSchema{}, err
+		}
+		log.Info("Successfully received schema", log.Data{"schema_name": SchemaName})
+		return registeredSchema{Subject: SchemaName, Schema: sch}, nil
+	}
+
+	log.Trace("Get schema from registry", log.Data{"schema_name": SchemaName, config.SchemaVersionKey: cfg.SchemaVersion})
+	rs, err := callSchemaFetch(cfg.SchemaRegistryURL, SchemaName, cfg.SchemaVersion)
 	if err != nil {
 		log.Error(fmt.Errorf("error receiving %s schema: %s", SchemaName, err))
-		return "", err
+		return rs, err
 	}
-	log.Info("Successfully received schema", log.Data{"schema_name": SchemaName})
-	return sch, nil
+	if err := checkChsDeltaCompatible(rs.Schema); err != nil {
+		err = fmt.Errorf("%s version %d %w: %s", SchemaName, rs.Version, errIncompatibleSchema, err)
+		log.Error(err)
+		return rs, err
+	}
+	log.Info("Successfully received schema", log.Data{"schema_name": SchemaName, config.SchemaVersionKey: rs.Version, config.SchemaIdKey: rs.Id})
+
+	return rs, nil
 }
 
-func initProducer(cfg *config.Config) (*producer.Producer, error) {
+func initProducer(cfg *config.Config) (sarama.SyncProducer, error) {
 	// Create a new Kafka Producer which will be used to publish our message onto a given Kafka topic.
-	log.Info("Using Streaming Kafka broker Address", log.Data{"Brokers": cfg.BrokerAddr})
-	p, err := callProducerNew(&producer.Config{Acks: &producer.WaitForAll, BrokerAddrs: cfg.BrokerAddr, RoundRobinPartitioner: true})
+	pc, err := producerConfig(cfg)
+	if err != nil {
+		log.Error(fmt.Errorf("error configuring producer: %s", err))
+		return nil, err
+	}
+	log.Info("Using Streaming Kafka broker Address", log.Data{"Brokers": cfg.BrokerAddr, config.KafkaVersionKey: pc.Version.String()})
+	p, err := callProducerNew(cfg.BrokerAddr, pc)
 	if err != nil {
 		log.Error(fmt.Errorf("error initialising producer: %s", err))
 		return nil, err
@@ -85,19 +218,190 @@ func initProducer(cfg *config.Config) (*producer.Producer, error) {
 	return p, nil
 }
 
-// SendMessage publishes a given data string retrieved from a REST request onto a chosen Kafka topic.
-func (kSvc *KafkaServiceImpl) SendMessage(topic, data, contextId string, isDelete bool) error {
+// producerConfig returns the configuration of the producer. Every message must be acknowledged by all in-sync
+// replicas, and is sent to a partition chosen by the keyedPartitioner. An error is returned if the configured Kafka
+// version can't be parsed or is too old to send record headers.
+func producerConfig(cfg *config.Config) (*sarama.Config, error) {
+
+	version := cfg.KafkaVersion
+	if version == "" {
+		version = DefaultKafkaVersion
+	}
+	v, err := sarama.ParseKafkaVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if !v.IsAtLeast(minKafkaVersion) {
+		return nil, fmt.Errorf("kafka version %s can't send record headers, it must be at least %s", v, minKafkaVersion)
+	}
+
+	c := sarama.NewConfig()
+	c.Version = v
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	c.Producer.Return.Successes = true
+	c.Producer.MaxMessageBytes = maxMessageBytes(cfg)
+	c.Producer.Partitioner = newKeyedPartitioner
+	return c, nil
+}
+
+// maxMessageBytes returns the largest message the producer is configured to send, or DefaultMaxMessageBytes.
+func maxMessageBytes(cfg *config.Config) int {
+	if cfg.KafkaMaxMessageBytes > 0 {
+		return cfg.KafkaMaxMessageBytes
+	}
+	return DefaultMaxMessageBytes
+}
+
+// SendMessage publishes a given data string retrieved from a REST request onto a chosen Kafka topic, along with record
+// headers describing the delta. The first primary id, if any, is used as the message key which keeps deltas for the
+// same entity in order on a single partition. Sends failing with retriable errors are retried with backoff, recording
+// the attempt in the chs-delta. Once sends keep failing, a CircuitOpenError is returned without attempting to send.
+// Deltas which still can't be sent are dead-lettered, if configured, and a DeadLetteredError returned. Deltas too large
+// to ever be sent are refused with ErrMessageTooLarge instead.
+func (kSvc *KafkaServiceImpl) SendMessage(topic, data string, meta models.DeltaMetadata) error {
+
+	if err := kSvc.checkMessageSize(data, meta); err != nil {
+		log.ErrorC(meta.ContextId, err, log.Data{config.TopicKey: topic, config.MaxMessageBytesKey: kSvc.maxMessageBytes})
+		return err
+	}
+
+	if kSvc.breaker != nil {
+		if err := kSvc.breaker.allow(); err != nil {
+			log.ErrorC(meta.ContextId, err, log.Data{config.TopicKey: topic})
+			return err
+		}
+	}
+
+	attempts := kSvc.retry.attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	made := 0
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			callSleep(kSvc.retry.delay(attempt))
+		}
+
+		made++
+		err = kSvc.send(topic, data, meta, int32(attempt))
+		if err == nil || !isRetriable(err) {
+			break
+		}
+		log.ErrorC(meta.ContextId, err, log.Data{config.TopicKey: topic, config.AttemptKey: attempt, config.MessageKey: "retriable error sending message"})
+	}
+
+	retriable := err != nil && isRetriable(err)
+	if kSvc.breaker != nil {
+		switch {
+		case err == nil:
+			kSvc.breaker.success()
+		case retriable:
+			kSvc.breaker.failure()
+		default:
+			kSvc.breaker.release()
+		}
+	}
+
+	// Dead-letter the delta rather than dropping it, unless it is being held for another attempt later.
+	if err != nil && (!retriable || !kSvc.holdRetriable) && (kSvc.deadLetterTopic != "" || kSvc.deadLetters != nil) {
+		dl := newDeadLetter(topic, data, meta, err, made)
+		if dlErr := kSvc.deadLetter(dl); dlErr != nil {
+			log.ErrorC(meta.ContextId, dlErr, log.Data{config.TopicKey: topic, config.MessageKey: "error dead-lettering delta, it has been dropped"})
+		} else {
+			err = &DeadLetteredError{Id: dl.Id, Err: err}
+		}
+	}
+
+	return err
+}
+
+// Close stops reconciling a fallback schema with the registry, disconnects the client used to check readiness and
+// closes the producer, which flushes any messages it is still sending.
+func (kSvc *KafkaServiceImpl) Close() error {
+
+	if kSvc.stopReconcile != nil {
+		close(kSvc.stopReconcile)
+		kSvc.stopReconcile = nil
+	}
+
+	kSvc.clientMtx.Lock()
+	if kSvc.client != nil {
+		_ = kSvc.client.Close()
+		kSvc.client = nil
+	}
+	kSvc.clientMtx.Unlock()
+
+	if kSvc.P == nil {
+		return nil
+	}
+	if err := callClose(kSvc); err != nil {
+		log.Error(fmt.Errorf("error closing producer: %s", err))
+		return err
+	}
+	kSvc.P = nil
+	log.Info("Closed kafka producer")
+
+	return nil
+}
+
+// DeadLetterStore returns the local store of dead letters, or nil if there isn't one.
+func (kSvc *KafkaServiceImpl) DeadLetterStore() DeadLetterStore {
+	if kSvc.deadLetters == nil {
+		return nil
+	}
+	return kSvc.deadLetters
+}
+
+// holdRetriableFailures stops deltas which fail with retriable errors from being dead-lettered, for when they are
+// held elsewhere to be sent again later.
+func (kSvc *KafkaServiceImpl) holdRetriableFailures() {
+	kSvc.holdRetriable = true
+}
+
+// BreakerState returns the state of the circuit breaker around sending to Kafka.
+func (kSvc *KafkaServiceImpl) BreakerState() string {
+	if kSvc.breaker == nil {
+		return BreakerClosed
+	}
+	return kSvc.breaker.State()
+}
+
+// send makes a single attempt to send a chs-delta onto a Kafka topic. The attempt is traced as a child of the request's
+// span, and its trace context is published in the record headers so consumers can continue the trace.
+func (kSvc *KafkaServiceImpl) send(topic, data string, meta models.DeltaMetadata, attempt int32) (err error) {
+
+	ctx := tracing.WithTraceContext(context.Background(), meta.TraceParent, meta.TraceState)
+	ctx, span := tracing.Tracer().Start(ctx, topic+" publish", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(topic),
+			attribute.StringSlice(AttributePrimaryIds, meta.PrimaryIds),
+			attribute.Int(AttributeAttempt, int(attempt))))
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+	}()
+	meta.TraceParent, meta.TraceState = tracing.TraceContext(ctx)
 
 	// Retrieve our chs-delta avro schema using the chs go avro package.
+	kSvc.schemaMtx.RLock()
 	chsDeltaAvro := &avro.Schema{
 		Definition: kSvc.schema,
 	}
+	schemaId := kSvc.schemaId
+	kSvc.schemaMtx.RUnlock()
 
 	// Construct a chs-delta using provided data.
 	deltaData := models.ChsDelta{
-		ContextId: contextId,
+		ContextId: meta.ContextId,
 		Data:      data,
-		IsDelete:  isDelete,
+		Attempt:   attempt,
+		IsDelete:  meta.IsDelete,
 	}
 
 	// Marshall the chs-delta previously created into the avro schema and convert it to a []byte for sending.
@@ -105,11 +409,22 @@ func (kSvc *KafkaServiceImpl) SendMessage(topic, data, contextId string, isDelet
 	if err != nil {
 		return err
 	}
+	if kSvc.wireFormat {
+		messageBytes = toWireFormat(schemaId, messageBytes)
+	}
 
-	// Create the producer message which will contain a topic, our message and a default partition.
-	producerMessage := &producer.Message{
-		Topic: topic,
-		Value: sarama.ByteEncoder(messageBytes),
+	// Create the producer message which will contain a topic, our message, its headers and the key used to choose a
+	// partition.
+	producerMessage := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(messageBytes),
+		Headers: buildHeaders(data, meta),
+	}
+	key := ""
+	if len(meta.PrimaryIds) > 0 {
+		key = meta.PrimaryIds[0]
+		producerMessage.Key = sarama.StringEncoder(key)
+		span.SetAttributes(semconv.MessagingKafkaMessageKey(key))
 	}
 
 	// Finally try to send the message.
@@ -117,14 +432,86 @@ func (kSvc *KafkaServiceImpl) SendMessage(topic, data, contextId string, isDelet
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(semconv.MessagingDestinationPartitionID(strconv.Itoa(int(partition))), semconv.MessagingKafkaMessageOffset(int(offset)))
+
+	log.InfoC(meta.ContextId, "Sent message", log.Data{config.TopicKey: producerMessage.Topic, config.MessageKeyKey: key, config.PartitionKey: partition, config.OffsetKey: offset, config.AttemptKey: attempt})
+	log.TraceC(meta.ContextId, "Message data", log.Data{config.MessageKey: deltaData})
 
-	log.InfoC(contextId, "Sent message", log.Data{config.TopicKey: producerMessage.Topic, config.PartitionKey: partition, config.OffsetKey: offset})
-	log.TraceC(contextId, "Message data", log.Data{config.MessageKey: deltaData})
+	return nil
+}
 
+// checkMessageSize returns ErrMessageTooLarge if a delta would make a message larger than the producer is allowed to
+// send, which Kafka would refuse however many times it is sent. The size of the Avro encoded chs-delta is bounded from
+// above, so a delta within a few bytes of the limit may be refused too. Sizes aren't checked until the service is
+// initialised.
+func (kSvc *KafkaServiceImpl) checkMessageSize(data string, meta models.DeltaMetadata) error {
+	if kSvc.maxMessageBytes <= 0 {
+		return nil
+	}
+	if size := messageSize(data, meta, kSvc.wireFormat); size > kSvc.maxMessageBytes {
+		return fmt.Errorf("%w: %d bytes is over the limit of %d bytes", ErrMessageTooLarge, size, kSvc.maxMessageBytes)
+	}
 	return nil
 }
 
+// messageSize returns the most bytes a delta can take up as a Kafka record, counted in the same way as the producer:
+// its key, value and headers along with the record overhead.
+func messageSize(data string, meta models.DeltaMetadata, wireFormat bool) int {
+
+	// The Avro encoded chs-delta prefixes each string with its length and writes the attempt as a varint of up to 10
+	// and 5 bytes, and is_delete as a single byte.
+	size := recordOverhead + len(data) + len(meta.ContextId) + 2*binary.MaxVarintLen64 + binary.MaxVarintLen32 + 1
+	if wireFormat {
+		size += 5
+	}
+	if len(meta.PrimaryIds) > 0 {
+		size += len(meta.PrimaryIds[0])
+	}
+	for _, h := range buildHeaders(data, meta) {
+		size += len(h.Key) + len(h.Value) + headerOverhead
+	}
+	// A traceparent is added when the delta is sent, if tracing is enabled.
+	if meta.TraceParent == "" {
+		size += len(HeaderTraceParent) + traceParentBytes + headerOverhead
+	}
+
+	return size
+}
+
+// buildHeaders returns the Kafka record headers describing a delta. Headers which have no value are omitted. Primary
+// ids are comma separated and the content hash is the hex encoded SHA-256 of the request body.
+func buildHeaders(data string, meta models.DeltaMetadata) []sarama.RecordHeader {
+
+	headers := make([]sarama.RecordHeader, 0, 9)
+	add := func(key, value string) {
+		if value != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+		}
+	}
+
+	sum := sha256.Sum256([]byte(data))
+
+	add(HeaderContextId, meta.ContextId)
+	add(HeaderDeltaType, meta.DeltaType)
+	add(HeaderIsDelete, strconv.FormatBool(meta.IsDelete))
+	add(HeaderPrimaryIds, strings.Join(meta.PrimaryIds, ","))
+	add(HeaderContentSha256, hex.EncodeToString(sum[:]))
+	add(HeaderSpecVersion, meta.SpecVersion)
+	if !meta.ReceivedAt.IsZero() {
+		add(HeaderReceivedAt, meta.ReceivedAt.UTC().Format(time.RFC3339Nano))
+	}
+	add(HeaderTraceParent, meta.TraceParent)
+	add(HeaderTraceState, meta.TraceState)
+
+	return headers
+}
+
 // sendViaProducer is used to add an abstraction layer for unit testing when calling to send a message via a producer.
-func sendViaProducer(k *KafkaServiceImpl, msg *producer.Message) (int32, int64, error) {
-	return k.P.Send(msg)
+func sendViaProducer(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+	return k.P.SendMessage(msg)
+}
+
+// closeProducer is used to add an abstraction layer for unit testing when closing a producer.
+func closeProducer(k *KafkaServiceImpl) error {
+	return k.P.Close()
 }
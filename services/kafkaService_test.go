@@ -2,10 +2,13 @@ package services
 
 import (
 	"errors"
+	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs-delta-api/config"
-	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs.go/avro"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 const (
@@ -13,6 +16,7 @@ const (
 	Data       = `{"test" : "value"}`
 	BadSchema  = `"bad_schema_value"`
 	ContextId  = "contextId"
+	Key        = "key"
 	GoodSchema = `{"type":"record","namespace":"delta","name":"delta","doc":"SchemaforthedeltathatwillbeusedtotransferdatafromCHIPStoCHS.",
 "fields":[{"name":"data","type":"string","doc":"PayloadthatwillbetransferredfromCHIPStoCHSviaKafka"},
 {"name":"attempt","type":"int","default":0,"doc":"NumberofattemptstoretrypublishingthemessagetoKafkaTopic"},
@@ -45,8 +49,10 @@ func TestUnitKafkaServiceInitSuccessful(t *testing.T) {
 			return "mock_url", nil
 		}
 
-		callProducerNew = func(config *producer.Config) (*producer.Producer, error) {
-			return &producer.Producer{}, nil
+		var producerCfg *sarama.Config
+		callProducerNew = func(addrs []string, config *sarama.Config) (sarama.SyncProducer, error) {
+			producerCfg = config
+			return fakeProducer{}, nil
 		}
 
 		err := k.Init(cfg)
@@ -54,10 +60,133 @@ func TestUnitKafkaServiceInitSuccessful(t *testing.T) {
 		Convey("Then the error is nil", func() {
 			So(err, ShouldBeNil)
 		})
+
+		Convey("Then the producer and the service share the default max message size", func() {
+			So(producerCfg.Producer.MaxMessageBytes, ShouldEqual, DefaultMaxMessageBytes)
+			So(k.maxMessageBytes, ShouldEqual, DefaultMaxMessageBytes)
+		})
+
+		Convey("Then the producer speaks a Kafka version which can send record headers", func() {
+			So(producerCfg.Version, ShouldResemble, sarama.V0_11_0_0)
+		})
+
+		Convey("Then the producer waits for every replica and partitions messages by key", func() {
+			So(producerCfg.Producer.RequiredAcks, ShouldEqual, sarama.WaitForAll)
+			So(producerCfg.Producer.Partitioner(Topic), ShouldHaveSameTypeAs, &keyedPartitioner{})
+			So(producerCfg.Validate(), ShouldBeNil)
+		})
+	})
+}
+
+// TestUnitProducerConfig asserts that the producer is configured with a Kafka version which can send record headers.
+func TestUnitProducerConfig(t *testing.T) {
+
+	Convey("Given a Kafka version", t, func() {
+
+		Convey("When none is configured, then the oldest version which can send record headers is used", func() {
+			pc, err := producerConfig(&config.Config{})
+			So(err, ShouldBeNil)
+			So(pc.Version, ShouldResemble, sarama.V0_11_0_0)
+			So(pc.Validate(), ShouldBeNil)
+		})
+
+		Convey("When a newer version is configured, then it is used", func() {
+			pc, err := producerConfig(&config.Config{KafkaVersion: "2.3.0"})
+			So(err, ShouldBeNil)
+			So(pc.Version, ShouldResemble, sarama.V2_3_0_0)
+		})
+
+		Convey("When a version older than 0.11 is configured, then an error is returned", func() {
+			_, err := producerConfig(&config.Config{KafkaVersion: "0.10.2.0"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "can't send record headers")
+		})
+
+		Convey("When the version can't be parsed, then an error is returned", func() {
+			_, err := producerConfig(&config.Config{KafkaVersion: "latest"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitProducerConfigKeepsDefaults asserts that the producer only changes the settings which chs.go's producer.New
+// changed, and those the service needs, so it retries, batches and compresses messages as the baseline producer did.
+func TestUnitProducerConfigKeepsDefaults(t *testing.T) {
+
+	Convey("Given the producer configuration of the service and sarama's defaults", t, func() {
+		pc, err := producerConfig(&config.Config{})
+		So(err, ShouldBeNil)
+		defaults := sarama.NewConfig()
+
+		Convey("Then acks, successes, message size, partitioner and version are the only settings changed", func() {
+			So(pc.Producer.RequiredAcks, ShouldEqual, sarama.WaitForAll)
+			So(pc.Producer.Return.Successes, ShouldBeTrue)
+			So(pc.Producer.MaxMessageBytes, ShouldEqual, DefaultMaxMessageBytes)
+
+			So(pc.ClientID, ShouldEqual, defaults.ClientID)
+			So(pc.Producer.Retry.Max, ShouldEqual, defaults.Producer.Retry.Max)
+			So(pc.Producer.Retry.Backoff, ShouldEqual, defaults.Producer.Retry.Backoff)
+			So(pc.Producer.Timeout, ShouldEqual, defaults.Producer.Timeout)
+			So(pc.Producer.Compression, ShouldEqual, defaults.Producer.Compression)
+			So(pc.Producer.CompressionLevel, ShouldEqual, defaults.Producer.CompressionLevel)
+			So(pc.Producer.Idempotent, ShouldEqual, defaults.Producer.Idempotent)
+			So(pc.Producer.Flush, ShouldResemble, defaults.Producer.Flush)
+			So(pc.Producer.Return.Errors, ShouldEqual, defaults.Producer.Return.Errors)
+			So(pc.Metadata.Retry, ShouldResemble, defaults.Metadata.Retry)
+			So(pc.Net.MaxOpenRequests, ShouldEqual, defaults.Net.MaxOpenRequests)
+			So(pc.Net.DialTimeout, ShouldEqual, defaults.Net.DialTimeout)
+		})
+	})
+}
+
+// TestUnitProducerSendsHeaders asserts that a producer configured by the service can send deltas, with their record
+// headers, to a broker.
+func TestUnitProducerSendsHeaders(t *testing.T) {
+
+	Convey("Given a broker leading a topic", t, func() {
+		broker := sarama.NewMockBroker(t, 1)
+		defer broker.Close()
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader(Topic, 0, broker.BrokerID()),
+			"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+		})
+
+		callSend = sendViaProducer
+		meta := models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}, DeltaType: "officer-delta"}
+
+		Convey("When a delta is sent with the producer's config, then it is sent with its headers", func() {
+			pc, err := producerConfig(&config.Config{BrokerAddr: []string{broker.Addr()}})
+			So(err, ShouldBeNil)
+			p, err := sarama.NewSyncProducer([]string{broker.Addr()}, pc)
+			So(err, ShouldBeNil)
+			defer p.Close()
+
+			k := NewKafkaService()
+			k.schema = GoodSchema
+			k.P = p
+			So(k.SendMessage(Topic, Data, meta), ShouldBeNil)
+		})
+
+		Convey("When sarama's default version is used instead, then deltas with headers are refused", func() {
+			pc := sarama.NewConfig()
+			pc.Producer.Return.Successes = true
+			p, err := sarama.NewSyncProducer([]string{broker.Addr()}, pc)
+			So(err, ShouldBeNil)
+			defer p.Close()
+
+			k := NewKafkaService()
+			k.schema = GoodSchema
+			k.P = p
+			err = k.SendMessage(Topic, Data, meta)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Producing headers requires Kafka at least v0.11")
+		})
 	})
 }
 
-// TestUnitKafkaServiceInitGetSchemaFails asserts that errors are captured and returned when retrieving a schema fails.
+// TestUnitKafkaServiceInitGetSchemaFails asserts that the embedded schema is used when retrieving a schema fails.
 func TestUnitKafkaServiceInitGetSchemaFails(t *testing.T) {
 
 	cfg, _ := config.Get()
@@ -69,14 +198,15 @@ func TestUnitKafkaServiceInitGetSchemaFails(t *testing.T) {
 			return "", errors.New("error retrieving schema")
 		}
 
-		callProducerNew = func(config *producer.Config) (*producer.Producer, error) {
-			return &producer.Producer{}, nil
+		callProducerNew = func(addrs []string, config *sarama.Config) (sarama.SyncProducer, error) {
+			return fakeProducer{}, nil
 		}
 
 		err := k.Init(cfg)
 
-		Convey("Then the error is not nil", func() {
-			So(err, ShouldNotBeNil)
+		Convey("Then the error is nil and the embedded schema is used", func() {
+			So(err, ShouldBeNil)
+			So(k.schema, ShouldEqual, embeddedSchema)
 		})
 	})
 }
@@ -93,7 +223,7 @@ func TestUnitKafkaServiceInitNewProducerFails(t *testing.T) {
 			return "mock_url", nil
 		}
 
-		callProducerNew = func(config *producer.Config) (*producer.Producer, error) {
+		callProducerNew = func(addrs []string, config *sarama.Config) (sarama.SyncProducer, error) {
 			return nil, errors.New("error creating producer")
 		}
 
@@ -113,11 +243,11 @@ func TestUnitSendMessageSuccessfully(t *testing.T) {
 		k.schema = GoodSchema
 
 		Convey("When I call to send a message via the producer", func() {
-			callSend = func(k *KafkaServiceImpl, msg *producer.Message) (int32, int64, error) {
+			callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
 				return int32(0), int64(0), nil
 			}
 
-			err := k.SendMessage(Topic, Data, ContextId, false)
+			err := k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}})
 
 			Convey("Then there are no errors", func() {
 				So(err, ShouldBeNil)
@@ -126,6 +256,240 @@ func TestUnitSendMessageSuccessfully(t *testing.T) {
 	})
 }
 
+// TestUnitSendMessageKeysByPrimaryId asserts that messages are keyed so deltas for the same entity share a partition.
+func TestUnitSendMessageKeysByPrimaryId(t *testing.T) {
+	Convey("Given I have a Kafka service", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+
+		var sent []*sarama.ProducerMessage
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			sent = append(sent, msg)
+			return int32(0), int64(0), nil
+		}
+
+		Convey("When I send several messages for the same entity", func() {
+			for i := 0; i < 3; i++ {
+				So(k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}}), ShouldBeNil)
+			}
+			So(k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId, IsDelete: true, PrimaryIds: []string{Key, "other"}}), ShouldBeNil)
+
+			Convey("Then every message has the key and is assigned the same partition", func() {
+				partitioner := sarama.NewHashPartitioner(Topic)
+				var partitions []int32
+				for _, msg := range sent {
+					So(msg.Key, ShouldEqual, sarama.StringEncoder(Key))
+					p, err := partitioner.Partition((*sarama.ProducerMessage)(msg), 12)
+					So(err, ShouldBeNil)
+					partitions = append(partitions, p)
+				}
+				So(partitions, ShouldHaveLength, 4)
+				for _, p := range partitions {
+					So(p, ShouldEqual, partitions[0])
+				}
+			})
+		})
+
+		Convey("When I send a message without a key", func() {
+			So(k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+
+			Convey("Then the message is left unkeyed", func() {
+				So(sent, ShouldHaveLength, 1)
+				So(sent[0].Key, ShouldBeNil)
+			})
+		})
+	})
+}
+
+// TestUnitSendMessageHeaders asserts that messages carry record headers describing the delta.
+func TestUnitSendMessageHeaders(t *testing.T) {
+	Convey("Given I have a Kafka service", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+
+		var sent *sarama.ProducerMessage
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			sent = msg
+			return int32(0), int64(0), nil
+		}
+
+		headers := func() map[string]string {
+			h := make(map[string]string, len(sent.Headers))
+			for _, header := range sent.Headers {
+				h[string(header.Key)] = string(header.Value)
+			}
+			return h
+		}
+
+		Convey("When I send a message with full metadata", func() {
+			meta := models.DeltaMetadata{
+				ContextId:   ContextId,
+				DeltaType:   "officer-delta-delete",
+				IsDelete:    true,
+				PrimaryIds:  []string{"id-1", "id-2"},
+				SpecVersion: "abc123",
+				ReceivedAt:  time.Date(2024, 5, 1, 10, 30, 0, 123000000, time.FixedZone("BST", 3600)),
+			}
+			So(k.SendMessage(Topic, Data, meta), ShouldBeNil)
+
+			Convey("Then every header is set", func() {
+				So(headers(), ShouldResemble, map[string]string{
+					HeaderContextId:     ContextId,
+					HeaderDeltaType:     "officer-delta-delete",
+					HeaderIsDelete:      "true",
+					HeaderPrimaryIds:    "id-1,id-2",
+					HeaderContentSha256: "7081f734f4c20fff97d7998b9db2d07a5e95a78546e63706e1c6b31d96d6ef8a",
+					HeaderSpecVersion:   "abc123",
+					HeaderReceivedAt:    "2024-05-01T09:30:00.123Z",
+				})
+			})
+		})
+
+		Convey("When I send a message with minimal metadata", func() {
+			So(k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+
+			Convey("Then headers without a value are omitted", func() {
+				h := headers()
+				So(h, ShouldHaveLength, 3)
+				So(h[HeaderContextId], ShouldEqual, ContextId)
+				So(h[HeaderIsDelete], ShouldEqual, "false")
+				So(h, ShouldContainKey, HeaderContentSha256)
+			})
+		})
+	})
+}
+
+// TestUnitSendMessageRetries asserts that retriable errors are retried with backoff, recording the attempt in the
+// chs-delta, and that other errors are returned straight away.
+func TestUnitSendMessageRetries(t *testing.T) {
+	Convey("Given I have a Kafka service which retries", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+		k.retry = newRetryPolicy(3, time.Millisecond)
+
+		var sleeps []time.Duration
+		callSleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+		callJitter = func(n int64) int64 { return n - 1 }
+		defer func() { callSleep = time.Sleep }()
+
+		var attempts []int32
+		sendErrs := []error{}
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			var delta models.ChsDelta
+			_ = (&avro.Schema{Definition: GoodSchema}).Unmarshal(msg.Value.(sarama.ByteEncoder), &delta)
+			attempts = append(attempts, delta.Attempt)
+			if len(sendErrs) > 0 {
+				err := sendErrs[0]
+				sendErrs = sendErrs[1:]
+				return -1, -1, err
+			}
+			return int32(0), int64(0), nil
+		}
+
+		Convey("When the first sends fail with retriable errors", func() {
+			sendErrs = []error{sarama.ErrLeaderNotAvailable, sarama.ErrNotLeaderForPartition}
+			err := k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId})
+
+			Convey("Then the message is sent, with the attempt recorded and backoff between attempts", func() {
+				So(err, ShouldBeNil)
+				So(attempts, ShouldResemble, []int32{0, 1, 2})
+				So(sleeps, ShouldResemble, []time.Duration{time.Millisecond, 2 * time.Millisecond})
+			})
+		})
+
+		Convey("When every send fails with retriable errors", func() {
+			sendErrs = []error{sarama.ErrOutOfBrokers, sarama.ErrOutOfBrokers, sarama.ErrOutOfBrokers, nil}
+			err := k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId})
+
+			Convey("Then the last error is returned once the attempts run out", func() {
+				So(err, ShouldEqual, sarama.ErrOutOfBrokers)
+				So(attempts, ShouldHaveLength, 3)
+			})
+		})
+
+		Convey("When a send fails with an error which isn't retriable", func() {
+			sendErrs = []error{sarama.ErrMessageSizeTooLarge}
+			err := k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId})
+
+			Convey("Then the error is returned without retrying", func() {
+				So(err, ShouldEqual, sarama.ErrMessageSizeTooLarge)
+				So(attempts, ShouldHaveLength, 1)
+				So(sleeps, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+// TestUnitSendMessageCircuitBreaker asserts that once sends keep failing, messages are refused without being sent.
+func TestUnitSendMessageCircuitBreaker(t *testing.T) {
+	Convey("Given I have a Kafka service with a circuit breaker", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+		k.retry = newRetryPolicy(1, time.Millisecond)
+		k.breaker = newCircuitBreaker(2, time.Minute)
+
+		sends := 0
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			sends++
+			return -1, -1, sarama.ErrOutOfBrokers
+		}
+
+		Convey("When sends fail until the breaker opens", func() {
+			So(k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldEqual, sarama.ErrOutOfBrokers)
+			So(k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldEqual, sarama.ErrOutOfBrokers)
+			So(k.BreakerState(), ShouldEqual, BreakerOpen)
+
+			Convey("Then further messages fail fast with a CircuitOpenError", func() {
+				err := k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId})
+				var openErr *CircuitOpenError
+				So(errors.As(err, &openErr), ShouldBeTrue)
+				So(openErr.RetryAfter, ShouldBeGreaterThan, 0)
+				So(sends, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+// TestUnitSendMessageTooLarge asserts that deltas too large for the producer are refused before being sent, without
+// being dead-lettered, and that the size checked is never less than the size of the message sent.
+func TestUnitSendMessageTooLarge(t *testing.T) {
+	Convey("Given I have a Kafka service with a dead-letter sink", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+		k.wireFormat = true
+		k.deadLetters, _ = NewFileDeadLetterSink(t.TempDir())
+		meta := models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}, DeltaType: "officer-delta"}
+
+		var sent *sarama.ProducerMessage
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			sent = msg
+			return 0, 0, nil
+		}
+
+		Convey("When a delta fits, then it is sent in no more bytes than were checked", func() {
+			k.maxMessageBytes = messageSize(Data, meta, k.wireFormat)
+			So(k.SendMessage(Topic, Data, meta), ShouldBeNil)
+			So(sent, ShouldNotBeNil)
+
+			size := recordOverhead + len(sent.Value.(sarama.ByteEncoder)) + len(Key)
+			for _, h := range sent.Headers {
+				size += len(h.Key) + len(h.Value) + headerOverhead
+			}
+			So(size, ShouldBeLessThanOrEqualTo, k.maxMessageBytes)
+		})
+
+		Convey("When a delta doesn't fit, then ErrMessageTooLarge is returned without sending or dead-lettering it", func() {
+			k.maxMessageBytes = messageSize(Data, meta, k.wireFormat) - 1
+			err := k.SendMessage(Topic, Data, meta)
+			So(errors.Is(err, ErrMessageTooLarge), ShouldBeTrue)
+			So(sent, ShouldBeNil)
+
+			dls, _ := k.deadLetters.List()
+			So(dls, ShouldBeEmpty)
+		})
+	})
+}
+
 // TestUnitSendMessageFailsSchemaMarshalling asserts that errors are handled and returned when marshalling a schema fails.
 func TestUnitSendMessageFailsSchemaMarshalling(t *testing.T) {
 	Convey("Given I have a Kafka service", t, func() {
@@ -134,7 +498,7 @@ func TestUnitSendMessageFailsSchemaMarshalling(t *testing.T) {
 
 		Convey("When I call to send a message via the producer", func() {
 
-			err := k.SendMessage(Topic, Data, ContextId, false)
+			err := k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}})
 
 			Convey("Then there are errors returned", func() {
 				So(err, ShouldNotBeNil)
@@ -150,11 +514,11 @@ func TestUnitSendMessageFailsWithError(t *testing.T) {
 		k.schema = GoodSchema
 
 		Convey("When I call to send a message via the producer", func() {
-			callSend = func(k *KafkaServiceImpl, msg *producer.Message) (int32, int64, error) {
+			callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
 				return int32(0), int64(0), errors.New("error sending to kafka producer")
 			}
 
-			err := k.SendMessage(Topic, Data, ContextId, false)
+			err := k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}})
 
 			Convey("Then there are errors returned", func() {
 				So(err, ShouldNotBeNil)
@@ -162,3 +526,49 @@ func TestUnitSendMessageFailsWithError(t *testing.T) {
 		})
 	})
 }
+
+// fakeProducer is a producer which isn't connected to Kafka, for tests which stub sending and closing.
+type fakeProducer struct {
+	sarama.SyncProducer
+}
+
+func (fakeProducer) Close() error {
+	return nil
+}
+
+// TestUnitKafkaServiceClose asserts that closing the Kafka service closes the producer.
+func TestUnitKafkaServiceClose(t *testing.T) {
+
+	Convey("Given an initialised Kafka service", t, func() {
+		k := NewKafkaService()
+		k.P = fakeProducer{}
+		k.stopReconcile = make(chan struct{})
+		stop := k.stopReconcile
+
+		closed := 0
+		callClose = func(k *KafkaServiceImpl) error {
+			closed++
+			return nil
+		}
+		defer func() { callClose = closeProducer }()
+
+		Convey("When I close it, then the producer is closed and the schema no longer reconciled", func() {
+			So(k.Close(), ShouldBeNil)
+			So(closed, ShouldEqual, 1)
+			_, open := <-stop
+			So(open, ShouldBeFalse)
+
+			Convey("Then closing it again does nothing", func() {
+				So(k.Close(), ShouldBeNil)
+				So(closed, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the producer can't be closed, then the error is returned", func() {
+			callClose = func(k *KafkaServiceImpl) error {
+				return errors.New("error closing producer")
+			}
+			So(k.Close(), ShouldNotBeNil)
+		})
+	})
+}
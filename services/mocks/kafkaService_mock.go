@@ -8,6 +8,7 @@ import (
 	reflect "reflect"
 
 	config "github.com/companieshouse/chs-delta-api/config"
+	models "github.com/companieshouse/chs-delta-api/models"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -34,6 +35,20 @@ func (m *MockKafkaService) EXPECT() *MockKafkaServiceMockRecorder {
 	return m.recorder
 }
 
+// Close mocks base method.
+func (m *MockKafkaService) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockKafkaServiceMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockKafkaService)(nil).Close))
+}
+
 // Init mocks base method.
 func (m *MockKafkaService) Init(cfg *config.Config) error {
 	m.ctrl.T.Helper()
@@ -49,15 +64,15 @@ func (mr *MockKafkaServiceMockRecorder) Init(cfg interface{}) *gomock.Call {
 }
 
 // SendMessage mocks base method.
-func (m *MockKafkaService) SendMessage(topic, data, contextId string, isDelete bool) error {
+func (m *MockKafkaService) SendMessage(topic, data string, meta models.DeltaMetadata) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SendMessage", topic, data, contextId)
+	ret := m.ctrl.Call(m, "SendMessage", topic, data, meta)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SendMessage indicates an expected call of SendMessage.
-func (mr *MockKafkaServiceMockRecorder) SendMessage(topic, data, contextId interface{}, isDelete bool) *gomock.Call {
+func (mr *MockKafkaServiceMockRecorder) SendMessage(topic, data, meta interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockKafkaService)(nil).SendMessage), topic, data, contextId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockKafkaService)(nil).SendMessage), topic, data, meta)
 }
@@ -0,0 +1,507 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outboxEntryExt    = ".delta"
+	outboxTempExt     = ".tmp"
+	outboxCorruptExt  = ".corrupt"
+	outboxTooLargeExt = ".too-large"
+
+	// DefaultOutboxMaxBytes is the disk usage limit of the outbox when none is configured.
+	DefaultOutboxMaxBytes = 256 * 1024 * 1024
+
+	// OutboxFullRetryAfter is how long callers are told to wait before sending a delta the outbox had no room for.
+	OutboxFullRetryAfter = 30 * time.Second
+)
+
+// Used for unit testing. Allows the backoff between failed sends to be shortened, and writes to be held or failed.
+var (
+	outboxInitialBackoff = 100 * time.Millisecond
+	outboxMaxBackoff     = 30 * time.Second
+	callWriteFileSync    = writeFileSync
+)
+
+// Metrics describing the deltas waiting in the outbox.
+var (
+	outboxDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chs_delta_api_outbox_depth",
+		Help: "Deltas waiting in the outbox to be sent to Kafka.",
+	})
+	outboxBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chs_delta_api_outbox_bytes",
+		Help: "Total size in bytes of the deltas waiting in the outbox.",
+	})
+	outboxMaxBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chs_delta_api_outbox_max_bytes",
+		Help: "Disk usage limit in bytes of the outbox.",
+	})
+)
+
+// ErrOutboxFull is returned when accepting a delta would take the outbox over its disk usage limit.
+var ErrOutboxFull = errors.New("outbox is full")
+
+// errOutboxEmpty is returned by step when there is nothing to send yet.
+var errOutboxEmpty = errors.New("outbox is empty")
+
+// OutboxStats describes the deltas waiting in an outbox to be sent to Kafka.
+type OutboxStats struct {
+	Depth    int   `json:"depth"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// OutboxStatser is implemented by Kafka services which hold deltas in an outbox before sending them.
+type OutboxStatser interface {
+	OutboxStats() OutboxStats
+}
+
+// retriableHolder is implemented by Kafka services which can leave deltas failing with retriable errors to be sent
+// again later, rather than dead-lettering them.
+type retriableHolder interface {
+	holdRetriableFailures()
+}
+
+// messageSizeChecker is implemented by Kafka services which can tell whether a delta is too large to ever be sent.
+type messageSizeChecker interface {
+	checkMessageSize(data string, meta models.DeltaMetadata) error
+}
+
+// outboxEntry is a delta as written to disk.
+type outboxEntry struct {
+	Topic string               `json:"topic"`
+	Data  string               `json:"data"`
+	Meta  models.DeltaMetadata `json:"meta"`
+}
+
+// outboxFile is a delta waiting on disk to be sent.
+type outboxFile struct {
+	seq  uint64
+	size int64
+}
+
+// OutboxService is a KafkaService which durably writes each delta to a local outbox directory before acknowledging
+// it, then sends the deltas to Kafka in order from a background worker. A broker outage therefore delays deltas rather
+// than failing the requests which carry them. Deltas left in the outbox are recovered when the service restarts.
+type OutboxService struct {
+	kSvc     KafkaService
+	dir      string
+	maxBytes int64
+
+	mtx      sync.Mutex
+	files    []outboxFile
+	bytes    int64
+	reserved int64
+	writing  []uint64
+	nextSeq  uint64
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	ready   bool
+	started bool
+	notify  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewOutboxService returns an OutboxService which writes deltas to dir and sends them using kSvc. The outbox refuses
+// new deltas once it holds maxBytes, or DefaultOutboxMaxBytes if maxBytes isn't greater than zero.
+func NewOutboxService(kSvc KafkaService, dir string, maxBytes int64) *OutboxService {
+	if maxBytes <= 0 {
+		maxBytes = DefaultOutboxMaxBytes
+	}
+	return &OutboxService{
+		kSvc:     kSvc,
+		dir:      dir,
+		maxBytes: maxBytes,
+
+		initialBackoff: outboxInitialBackoff,
+		maxBackoff:     outboxMaxBackoff,
+
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Init recovers any deltas left in the outbox and starts sending them. If the underlying Kafka service can't be
+// initialised, deltas are still accepted and the worker retries initialising it with backoff.
+func (o *OutboxService) Init(cfg *config.Config) error {
+
+	if err := o.recover(); err != nil {
+		log.Error(err, log.Data{config.OutboxDirKey: o.dir, config.MessageKey: "error recovering outbox"})
+		return err
+	}
+	// Deltas stay in the outbox while Kafka is unavailable, so only those which can never be sent are dead-lettered.
+	if h, ok := o.kSvc.(retriableHolder); ok {
+		h.holdRetriableFailures()
+	}
+
+	stats := o.OutboxStats()
+	log.Info("Recovered outbox", log.Data{config.OutboxDirKey: o.dir, config.OutboxDepthKey: stats.Depth, config.OutboxBytesKey: stats.Bytes})
+
+	if err := o.kSvc.Init(cfg); err != nil {
+		log.Error(err, log.Data{config.MessageKey: "error initialising kafka service, deltas will be held in the outbox"})
+	} else {
+		o.ready = true
+	}
+
+	o.started = true
+	go o.run(cfg)
+
+	return nil
+}
+
+// SendMessage durably writes a delta to the outbox to be sent to the given topic. It returns once the delta is on
+// disk, ErrOutboxFull if there isn't room for it, or ErrMessageTooLarge if it could never be sent. Room is reserved for
+// the delta before it is written, so deltas are written concurrently without going over the limit.
+func (o *OutboxService) SendMessage(topic, data string, meta models.DeltaMetadata) error {
+
+	if c, ok := o.kSvc.(messageSizeChecker); ok {
+		if err := c.checkMessageSize(data, meta); err != nil {
+			log.ErrorC(meta.ContextId, err, log.Data{config.TopicKey: topic})
+			return err
+		}
+	}
+
+	b, err := json.Marshal(outboxEntry{Topic: topic, Data: data, Meta: meta})
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(b))
+
+	o.mtx.Lock()
+	if o.bytes+o.reserved+size > o.maxBytes {
+		log.ErrorC(meta.ContextId, ErrOutboxFull, log.Data{config.OutboxDepthKey: len(o.files), config.OutboxBytesKey: o.bytes})
+		o.mtx.Unlock()
+		return ErrOutboxFull
+	}
+	seq := o.nextSeq
+	o.nextSeq++
+	o.reserved += size
+	o.writing = append(o.writing, seq)
+	o.mtx.Unlock()
+
+	err = callWriteFileSync(o.dir, o.entryName(seq), b)
+
+	o.mtx.Lock()
+	o.reserved -= size
+	o.doneWriting(seq)
+	if err == nil {
+		o.insert(outboxFile{seq: seq, size: size})
+		o.bytes += size
+		o.updateMetrics()
+	}
+	o.mtx.Unlock()
+
+	// The worker is woken even if the write failed, as it may be holding back deltas written after this one.
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return err
+}
+
+// OutboxStats returns the number and total size of the deltas waiting in the outbox.
+func (o *OutboxService) OutboxStats() OutboxStats {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return OutboxStats{Depth: len(o.files), Bytes: o.bytes, MaxBytes: o.maxBytes}
+}
+
+// BreakerState returns the state of the circuit breaker of the underlying Kafka service, if it has one.
+func (o *OutboxService) BreakerState() string {
+	if b, ok := o.kSvc.(BreakerStater); ok {
+		return b.BreakerState()
+	}
+	return BreakerClosed
+}
+
+// DeadLetterStore returns the local store of dead letters of the underlying Kafka service, or nil if there isn't one.
+func (o *OutboxService) DeadLetterStore() DeadLetterStore {
+	if d, ok := o.kSvc.(DeadLetterStorer); ok {
+		return d.DeadLetterStore()
+	}
+	return nil
+}
+
+// Close stops the worker once any send in progress has finished, then closes the Kafka service. Deltas not yet sent
+// stay in the outbox and are sent when the service next starts.
+func (o *OutboxService) Close() error {
+	if o.started {
+		close(o.stop)
+		<-o.done
+		o.started = false
+	}
+	return o.kSvc.Close()
+}
+
+// run sends deltas from the outbox until the service is closed, backing off while sends are failing. Deltas are sent
+// one at a time in the order they were accepted, so a failing delta holds back those behind it.
+func (o *OutboxService) run(cfg *config.Config) {
+
+	defer close(o.done)
+
+	backoff := o.initialBackoff
+	for {
+		select {
+		case <-o.stop:
+			return
+		default:
+		}
+
+		err := o.step(cfg)
+		switch {
+		case err == nil:
+			backoff = o.initialBackoff
+		case errors.Is(err, errOutboxEmpty):
+			select {
+			case <-o.stop:
+				return
+			case <-o.notify:
+			}
+		default:
+			timer := time.NewTimer(backoff)
+			select {
+			case <-o.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if backoff *= 2; backoff > o.maxBackoff {
+				backoff = o.maxBackoff
+			}
+		}
+	}
+}
+
+// step initialises the Kafka service if needed and sends the oldest delta in the outbox.
+func (o *OutboxService) step(cfg *config.Config) error {
+
+	if !o.ready {
+		if err := o.kSvc.Init(cfg); err != nil {
+			log.Error(err, log.Data{config.MessageKey: "error initialising kafka service, retrying"})
+			return err
+		}
+		log.Info("Initialised kafka service, sending deltas held in the outbox")
+		o.ready = true
+	}
+
+	o.mtx.Lock()
+	if len(o.files) == 0 {
+		o.mtx.Unlock()
+		return errOutboxEmpty
+	}
+	head := o.files[0]
+	// Deltas are written concurrently, so one accepted before the head may still be being written. It is sent first.
+	if len(o.writing) > 0 && o.writing[0] < head.seq {
+		o.mtx.Unlock()
+		return errOutboxEmpty
+	}
+	o.mtx.Unlock()
+
+	path := filepath.Join(o.dir, o.entryName(head.seq))
+	entry, err := readEntry(path)
+	if err != nil {
+		// A corrupt entry can never be sent, so set it aside for investigation rather than blocking the outbox.
+		log.Error(err, log.Data{config.OutboxDirKey: o.dir, config.MessageKey: "corrupt outbox entry, setting it aside"})
+		return o.setAside(head, path, outboxCorruptExt)
+	}
+
+	// A dead-lettered delta has been recorded elsewhere, so it is removed from the outbox as if it had been sent. A delta
+	// accepted before the Kafka service could check its size may be too large to ever be sent, so it is set aside.
+	var dlErr *DeadLetteredError
+	if err := o.kSvc.SendMessage(entry.Topic, entry.Data, entry.Meta); errors.Is(err, ErrMessageTooLarge) {
+		log.ErrorC(entry.Meta.ContextId, err, log.Data{config.OutboxDirKey: o.dir, config.MessageKey: "outbox entry too large to send, setting it aside"})
+		return o.setAside(head, path, outboxTooLargeExt)
+	} else if err != nil && !errors.As(err, &dlErr) {
+		log.ErrorC(entry.Meta.ContextId, err, log.Data{config.TopicKey: entry.Topic, config.MessageKey: "error sending delta from outbox, retrying"})
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		log.ErrorC(entry.Meta.ContextId, err, log.Data{config.OutboxDirKey: o.dir, config.MessageKey: "error removing sent delta from outbox"})
+		return err
+	}
+	o.remove(head)
+
+	return nil
+}
+
+// setAside renames the oldest delta in the outbox with the given extension, for investigation, so it no longer blocks
+// the deltas behind it.
+func (o *OutboxService) setAside(f outboxFile, path, ext string) error {
+	if err := os.Rename(path, strings.TrimSuffix(path, outboxEntryExt)+ext); err != nil {
+		return err
+	}
+	o.remove(f)
+	return nil
+}
+
+// remove forgets the oldest delta in the outbox once it has been sent or set aside.
+func (o *OutboxService) remove(f outboxFile) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.files = o.files[1:]
+	o.bytes -= f.size
+	o.updateMetrics()
+}
+
+// insert adds a delta written to the outbox in sequence order. Deltas finish being written in any order, so it may
+// not be the newest. The caller must hold o.mtx.
+func (o *OutboxService) insert(f outboxFile) {
+	i := sort.Search(len(o.files), func(i int) bool {
+		return o.files[i].seq > f.seq
+	})
+	o.files = append(o.files, outboxFile{})
+	copy(o.files[i+1:], o.files[i:])
+	o.files[i] = f
+}
+
+// doneWriting forgets a delta which has been written to the outbox, or failed to be. The caller must hold o.mtx.
+func (o *OutboxService) doneWriting(seq uint64) {
+	for i, s := range o.writing {
+		if s == seq {
+			o.writing = append(o.writing[:i], o.writing[i+1:]...)
+			return
+		}
+	}
+}
+
+// updateMetrics sets the outbox gauges to its current depth. The caller must hold o.mtx.
+func (o *OutboxService) updateMetrics() {
+	outboxDepth.Set(float64(len(o.files)))
+	outboxBytes.Set(float64(o.bytes))
+	outboxMaxBytes.Set(float64(o.maxBytes))
+}
+
+// recover creates the outbox directory if needed and loads the deltas left in it, oldest first. Partially written
+// deltas were never acknowledged, so they are removed.
+func (o *OutboxService) recover() error {
+
+	if err := os.MkdirAll(o.dir, 0o700); err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		switch filepath.Ext(name) {
+		case outboxTempExt:
+			if err := os.Remove(filepath.Join(o.dir, name)); err != nil {
+				return err
+			}
+		case outboxEntryExt:
+			seq, err := strconv.ParseUint(strings.TrimSuffix(name, outboxEntryExt), 10, 64)
+			if err != nil {
+				return fmt.Errorf("unexpected file %s in outbox: %w", name, err)
+			}
+			info, err := de.Info()
+			if err != nil {
+				return err
+			}
+			o.files = append(o.files, outboxFile{seq: seq, size: info.Size()})
+			o.bytes += info.Size()
+			if seq >= o.nextSeq {
+				o.nextSeq = seq + 1
+			}
+		}
+	}
+
+	sort.Slice(o.files, func(i, j int) bool {
+		return o.files[i].seq < o.files[j].seq
+	})
+	o.updateMetrics()
+
+	return nil
+}
+
+// entryName returns the file name of a delta. Sequence numbers are zero padded so the names sort in order.
+func (o *OutboxService) entryName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, outboxEntryExt)
+}
+
+// readEntry reads a delta from disk.
+func readEntry(path string) (outboxEntry, error) {
+	var entry outboxEntry
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(b, &entry)
+	return entry, err
+}
+
+// writeFileSync writes a file so that it is either completely on disk or absent after a crash. The data is written to
+// a temporary file and synced before being renamed into place, then the directory is synced to persist the rename. If
+// the rename can't be persisted the file is removed, as the caller will be told it wasn't written and may write it
+// again.
+func writeFileSync(dir, name string, data []byte) error {
+
+	tmp := filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))+outboxTempExt)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := syncDir(dir); err != nil {
+		_ = os.Remove(filepath.Join(dir, name))
+		return err
+	}
+	return nil
+}
+
+// syncDir syncs a directory, persisting the files renamed into it.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
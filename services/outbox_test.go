@@ -0,0 +1,392 @@
+package services
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKafkaService records the deltas sent to it, failing the first initErrs calls to Init and sendErrs calls to
+// SendMessage. Deltas containing deadLetter are dead-lettered rather than sent.
+type fakeKafkaService struct {
+	mtx        sync.Mutex
+	initErrs   int
+	sendErrs   int
+	deadLetter string
+	inits      int
+	sent       []string
+	closed     bool
+}
+
+func (f *fakeKafkaService) Init(cfg *config.Config) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.inits++
+	if f.inits <= f.initErrs {
+		return errors.New("error initialising")
+	}
+	return nil
+}
+
+func (f *fakeKafkaService) SendMessage(topic, data string, meta models.DeltaMetadata) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.sendErrs > 0 {
+		f.sendErrs--
+		return errors.New("error sending")
+	}
+	if f.deadLetter != "" && data == f.deadLetter {
+		return &DeadLetteredError{Id: "1-1", Err: errors.New("error sending")}
+	}
+	f.sent = append(f.sent, data)
+	return nil
+}
+
+func (f *fakeKafkaService) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeKafkaService) getSent() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+// waitForSent waits for n deltas to have been sent, returning those sent so far if it times out.
+func waitForSent(f *fakeKafkaService, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := f.getSent(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return f.getSent()
+}
+
+// waitForEmpty waits for the outbox to remove every delta it has sent.
+func waitForEmpty(o *OutboxService) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && o.OutboxStats().Depth > 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// outboxFiles returns the names of the files in the outbox directory.
+func outboxFiles(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// writingCount returns the number of deltas being written to the outbox.
+func (o *OutboxService) writingCount() int {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return len(o.writing)
+}
+
+// TestUnitOutboxSendsInOrder asserts that accepted deltas are sent in order and removed from disk once sent.
+func TestUnitOutboxSendsInOrder(t *testing.T) {
+
+	outboxInitialBackoff = time.Millisecond
+
+	Convey("Given an outbox whose Kafka service fails the first few sends", t, func() {
+		dir := t.TempDir()
+		f := &fakeKafkaService{sendErrs: 3}
+		o := NewOutboxService(f, dir, 0)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+		defer o.Close()
+
+		Convey("When I send several deltas", func() {
+			for _, data := range []string{"one", "two", "three"} {
+				So(o.SendMessage(Topic, data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			}
+
+			Convey("Then they are all sent in the order they were accepted", func() {
+				So(waitForSent(f, 3), ShouldResemble, []string{"one", "two", "three"})
+			})
+
+			Convey("Then the outbox is emptied", func() {
+				waitForSent(f, 3)
+				waitForEmpty(o)
+				So(o.OutboxStats().Depth, ShouldEqual, 0)
+				So(o.OutboxStats().Bytes, ShouldEqual, 0)
+				So(outboxFiles(dir), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+// TestUnitOutboxSendsInOrderWhenWrittenOutOfOrder asserts that deltas are sent in the order they were accepted even
+// when a later delta finishes being written first, and that a delta which fails to be written doesn't hold back those
+// accepted after it.
+func TestUnitOutboxSendsInOrderWhenWrittenOutOfOrder(t *testing.T) {
+
+	defer func() { callWriteFileSync = writeFileSync }()
+
+	Convey("Given an outbox whose write of the first delta is held", t, func() {
+		release := make(chan error)
+		callWriteFileSync = func(dir, name string, data []byte) error {
+			if strings.Contains(string(data), `"data":"one"`) {
+				if err := <-release; err != nil {
+					return err
+				}
+			}
+			return writeFileSync(dir, name, data)
+		}
+
+		dir := t.TempDir()
+		f := &fakeKafkaService{}
+		o := NewOutboxService(f, dir, 0)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+		defer o.Close()
+
+		first := make(chan error, 1)
+		go func() {
+			first <- o.SendMessage(Topic, "one", models.DeltaMetadata{ContextId: ContextId})
+		}()
+		for o.writingCount() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		Convey("When a later delta is written before it, then it isn't sent until the first has been", func() {
+			So(o.SendMessage(Topic, "two", models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			time.Sleep(50 * time.Millisecond)
+			So(f.getSent(), ShouldBeEmpty)
+
+			release <- nil
+			So(<-first, ShouldBeNil)
+			So(waitForSent(f, 2), ShouldResemble, []string{"one", "two"})
+		})
+
+		Convey("When the first delta fails to be written, then the later delta is still sent", func() {
+			So(o.SendMessage(Topic, "two", models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+
+			release <- errors.New("error writing")
+			So(<-first, ShouldNotBeNil)
+			So(waitForSent(f, 1), ShouldResemble, []string{"two"})
+		})
+	})
+}
+
+// TestUnitOutboxInsert asserts that deltas are kept in sequence order whatever order they finish being written in.
+func TestUnitOutboxInsert(t *testing.T) {
+
+	Convey("Given an outbox", t, func() {
+		o := NewOutboxService(&fakeKafkaService{}, t.TempDir(), 0)
+
+		Convey("When deltas are inserted out of order, then they are held in sequence order", func() {
+			for _, seq := range []uint64{3, 1, 4, 0, 2} {
+				o.insert(outboxFile{seq: seq})
+			}
+			var seqs []uint64
+			for _, f := range o.files {
+				seqs = append(seqs, f.seq)
+			}
+			So(seqs, ShouldResemble, []uint64{0, 1, 2, 3, 4})
+		})
+	})
+}
+
+// TestUnitOutboxClose asserts that closing the outbox closes the Kafka service it sends to.
+func TestUnitOutboxClose(t *testing.T) {
+
+	Convey("Given a started outbox", t, func() {
+		f := &fakeKafkaService{}
+		o := NewOutboxService(f, t.TempDir(), 0)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+
+		Convey("When I close it, then the Kafka service is closed", func() {
+			So(o.Close(), ShouldBeNil)
+			So(f.closed, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given an outbox which failed to start", t, func() {
+		file := filepath.Join(t.TempDir(), "file")
+		So(os.WriteFile(file, nil, 0o600), ShouldBeNil)
+
+		f := &fakeKafkaService{}
+		o := NewOutboxService(f, filepath.Join(file, "outbox"), 0)
+		So(o.Init(&config.Config{}), ShouldNotBeNil)
+
+		Convey("When I close it, then it returns without waiting for a worker and the Kafka service is closed", func() {
+			So(o.Close(), ShouldBeNil)
+			So(f.closed, ShouldBeTrue)
+		})
+	})
+}
+
+// TestUnitOutboxRemovesDeadLetters asserts that dead-lettered deltas are removed rather than holding up the outbox.
+func TestUnitOutboxRemovesDeadLetters(t *testing.T) {
+
+	outboxInitialBackoff = time.Millisecond
+
+	Convey("Given an outbox whose Kafka service dead-letters a delta", t, func() {
+		dir := t.TempDir()
+		f := &fakeKafkaService{deadLetter: "two"}
+		o := NewOutboxService(f, dir, 0)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+		defer o.Close()
+
+		Convey("When I send several deltas, then the others are sent and the outbox is emptied", func() {
+			for _, data := range []string{"one", "two", "three"} {
+				So(o.SendMessage(Topic, data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			}
+			So(waitForSent(f, 2), ShouldResemble, []string{"one", "three"})
+			So(outboxFiles(dir), ShouldBeEmpty)
+		})
+	})
+}
+
+// TestUnitOutboxHoldsDeltasWhileKafkaUnavailable asserts that deltas are accepted while the Kafka service can't be
+// initialised, and sent once it can.
+func TestUnitOutboxHoldsDeltasWhileKafkaUnavailable(t *testing.T) {
+
+	outboxInitialBackoff = time.Millisecond
+
+	Convey("Given an outbox whose Kafka service can't be initialised at first", t, func() {
+		f := &fakeKafkaService{initErrs: 3}
+		o := NewOutboxService(f, t.TempDir(), 0)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+		defer o.Close()
+
+		Convey("When I send a delta, then it is accepted and sent once Kafka is available", func() {
+			So(o.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			So(waitForSent(f, 1), ShouldResemble, []string{Data})
+		})
+	})
+}
+
+// TestUnitOutboxFull asserts that deltas are refused once the outbox reaches its disk usage limit.
+func TestUnitOutboxFull(t *testing.T) {
+
+	Convey("Given a small outbox whose Kafka service can't be initialised", t, func() {
+		outboxInitialBackoff = time.Hour
+		defer func() { outboxInitialBackoff = time.Millisecond }()
+
+		f := &fakeKafkaService{initErrs: math.MaxInt}
+		o := NewOutboxService(f, t.TempDir(), 200)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+		defer o.Close()
+
+		Convey("When I send more deltas than fit, then ErrOutboxFull is returned", func() {
+			So(o.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			So(o.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldEqual, ErrOutboxFull)
+
+			stats := o.OutboxStats()
+			So(stats.Depth, ShouldEqual, 1)
+			So(stats.Bytes, ShouldBeLessThanOrEqualTo, 200)
+			So(stats.MaxBytes, ShouldEqual, 200)
+
+			So(testutil.ToFloat64(outboxDepth), ShouldEqual, 1)
+			So(testutil.ToFloat64(outboxBytes), ShouldEqual, stats.Bytes)
+			So(testutil.ToFloat64(outboxMaxBytes), ShouldEqual, 200)
+		})
+	})
+}
+
+// TestUnitOutboxRefusesTooLarge asserts that deltas too large to ever be sent are refused, and that any accepted before
+// their size could be checked are set aside rather than blocking the outbox.
+func TestUnitOutboxRefusesTooLarge(t *testing.T) {
+
+	outboxInitialBackoff = time.Millisecond
+
+	Convey("Given an outbox sending to a Kafka service which limits the size of messages", t, func() {
+		dir := t.TempDir()
+		k := NewKafkaService()
+		k.schema = GoodSchema
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			return 0, 0, nil
+		}
+		callSchemaGet = func(url, name string) (string, error) {
+			return GoodSchema, nil
+		}
+		callProducerNew = func(addrs []string, config *sarama.Config) (sarama.SyncProducer, error) {
+			return fakeProducer{}, nil
+		}
+		limit := messageSize(Data, models.DeltaMetadata{ContextId: ContextId}, false)
+
+		Convey("When I send a delta which is too large, then ErrMessageTooLarge is returned and it isn't accepted", func() {
+			o := NewOutboxService(&k, dir, 0)
+			So(o.Init(&config.Config{KafkaMaxMessageBytes: limit}), ShouldBeNil)
+			defer o.Close()
+
+			err := o.SendMessage(Topic, Data+" ", models.DeltaMetadata{ContextId: ContextId})
+			So(errors.Is(err, ErrMessageTooLarge), ShouldBeTrue)
+			So(o.OutboxStats().Depth, ShouldEqual, 0)
+			So(o.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+		})
+
+		Convey("When a delta which is too large was accepted before the service was initialised, then it is set aside", func() {
+			entry := `{"topic":"` + Topic + `","data":"` + strings.Repeat("a", limit) + `"}`
+			So(os.WriteFile(filepath.Join(dir, "00000000000000000001.delta"), []byte(entry), 0o600), ShouldBeNil)
+
+			o := NewOutboxService(&k, dir, 0)
+			So(o.Init(&config.Config{KafkaMaxMessageBytes: limit}), ShouldBeNil)
+			defer o.Close()
+
+			waitForEmpty(o)
+			So(outboxFiles(dir), ShouldResemble, []string{"00000000000000000001.too-large"})
+		})
+	})
+}
+
+// TestUnitOutboxRecovers asserts that deltas left on disk are sent when the outbox starts, and that partially written
+// and corrupt entries don't block it.
+func TestUnitOutboxRecovers(t *testing.T) {
+
+	outboxInitialBackoff = time.Millisecond
+
+	Convey("Given an outbox which was stopped before sending its deltas", t, func() {
+		dir := t.TempDir()
+		f := &fakeKafkaService{initErrs: math.MaxInt}
+		outboxInitialBackoff = time.Hour
+		o := NewOutboxService(f, dir, 0)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+		for _, data := range []string{"one", "two", "three"} {
+			So(o.SendMessage(Topic, data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+		}
+		o.Close()
+		outboxInitialBackoff = time.Millisecond
+
+		// Simulate a crash part way through writing a delta, and corrupt the second one.
+		So(os.WriteFile(filepath.Join(dir, "00000000000000000003.tmp"), []byte(`{"topic":`), 0o600), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(dir, "00000000000000000001.delta"), []byte(`not json`), 0o600), ShouldBeNil)
+
+		Convey("When the outbox is started again", func() {
+			f2 := &fakeKafkaService{}
+			o2 := NewOutboxService(f2, dir, 0)
+			So(o2.Init(&config.Config{}), ShouldBeNil)
+			defer o2.Close()
+
+			So(o2.SendMessage(Topic, "four", models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+
+			Convey("Then the remaining deltas are sent in order, followed by new ones", func() {
+				So(waitForSent(f2, 3), ShouldResemble, []string{"one", "three", "four"})
+			})
+
+			Convey("Then the partial delta is removed and the corrupt one set aside", func() {
+				waitForSent(f2, 3)
+				So(outboxFiles(dir), ShouldResemble, []string{"00000000000000000001.corrupt"})
+			})
+		})
+	})
+}
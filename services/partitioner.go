@@ -0,0 +1,40 @@
+package services
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// keyedPartitioner chooses the partition of a message by hashing its key, so deltas for the same entity stay in order
+// on a single partition. Messages without a key, from routes without a primary id, are spread across partitions in
+// turn rather than at random.
+type keyedPartitioner struct {
+	hash       sarama.Partitioner
+	roundRobin sarama.Partitioner
+}
+
+// newKeyedPartitioner returns the partitioner of a topic. It is a sarama.PartitionerConstructor.
+func newKeyedPartitioner(topic string) sarama.Partitioner {
+	return &keyedPartitioner{
+		hash:       sarama.NewHashPartitioner(topic),
+		roundRobin: sarama.NewRoundRobinPartitioner(topic),
+	}
+}
+
+// Partition returns the partition a message is sent to.
+func (p *keyedPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.roundRobin.Partition(message, numPartitions)
+	}
+	return p.hash.Partition(message, numPartitions)
+}
+
+// RequiresConsistency reports that keyed messages must always be sent to the partition their key hashes to.
+func (p *keyedPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// MessageRequiresConsistency reports that only messages with a key must be sent to the partition chosen for them.
+// Messages without a key can be sent to another partition while the one chosen is unavailable.
+func (p *keyedPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	return message.Key != nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// TestUnitKeyedPartitionerMatchesSarama asserts that the partitioner sends messages to the same partitions as the
+// partitioners it replaces: sarama's hash partitioner, the default of chs.go's producer for keyed messages, and the
+// round robin partitioner the baseline producer used for every message.
+func TestUnitKeyedPartitionerMatchesSarama(t *testing.T) {
+
+	Convey("Given the partitioner of a topic", t, func() {
+
+		Convey("When messages have a key, then they go to the partition sarama's hash partitioner chooses", func() {
+			for _, partitions := range []int32{1, 2, 3, 6, 12, 50} {
+				p := newKeyedPartitioner(Topic)
+				hash := sarama.NewHashPartitioner(Topic)
+				for i := 0; i < 500; i++ {
+					msg := &sarama.ProducerMessage{Topic: Topic, Key: sarama.StringEncoder(fmt.Sprintf("%08d", i))}
+					want, err := hash.Partition(msg, partitions)
+					So(err, ShouldBeNil)
+					got, err := p.Partition(msg, partitions)
+					So(err, ShouldBeNil)
+					So(got, ShouldEqual, want)
+				}
+			}
+		})
+
+		Convey("When messages have no key, then they go to the partitions the baseline round robin partitioner chose", func() {
+			for _, partitions := range []int32{1, 3, 12} {
+				p := newKeyedPartitioner(Topic)
+				roundRobin := sarama.NewRoundRobinPartitioner(Topic)
+				msg := &sarama.ProducerMessage{Topic: Topic}
+				for i := 0; i < 50; i++ {
+					want, err := roundRobin.Partition(msg, partitions)
+					So(err, ShouldBeNil)
+					got, err := p.Partition(msg, partitions)
+					So(err, ShouldBeNil)
+					So(got, ShouldEqual, want)
+				}
+			}
+		})
+	})
+}
+
+// TestUnitKeyedPartitioner asserts that messages with a key are partitioned by hashing it, and messages without a key
+// are spread across partitions in turn.
+func TestUnitKeyedPartitioner(t *testing.T) {
+
+	Convey("Given the partitioner of a topic with several partitions", t, func() {
+		p := newKeyedPartitioner(Topic)
+		hash := sarama.NewHashPartitioner(Topic)
+		const partitions = 4
+
+		Convey("When messages have a key, then they are sent to the partition it hashes to", func() {
+			for _, key := range []string{"a", "b", "c", "d", "e"} {
+				msg := &sarama.ProducerMessage{Topic: Topic, Key: sarama.StringEncoder(key)}
+				want, _ := hash.Partition(msg, partitions)
+				for i := 0; i < 3; i++ {
+					got, err := p.Partition(msg, partitions)
+					So(err, ShouldBeNil)
+					So(got, ShouldEqual, want)
+				}
+				So(p.(sarama.DynamicConsistencyPartitioner).MessageRequiresConsistency(msg), ShouldBeTrue)
+			}
+		})
+
+		Convey("When messages have no key, then they are sent to each partition in turn", func() {
+			msg := &sarama.ProducerMessage{Topic: Topic}
+			var got []int32
+			for i := 0; i < 2*partitions; i++ {
+				partition, err := p.Partition(msg, partitions)
+				So(err, ShouldBeNil)
+				got = append(got, partition)
+			}
+			So(got, ShouldResemble, []int32{0, 1, 2, 3, 0, 1, 2, 3})
+			So(p.(sarama.DynamicConsistencyPartitioner).MessageRequiresConsistency(msg), ShouldBeFalse)
+		})
+	})
+}
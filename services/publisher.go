@@ -0,0 +1,172 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/companieshouse/chs.go/log"
+	"io"
+	"os"
+	"sync"
+)
+
+// PublishedDelta is a delta as it would have been published to Kafka, used by the publishers which don't need a broker.
+type PublishedDelta struct {
+	Topic     string            `json:"topic"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers"`
+	ContextId string            `json:"context_id"`
+	IsDelete  bool              `json:"is_delete"`
+	Data      string            `json:"data"`
+}
+
+// PublishedLister is implemented by publishers which keep the deltas they have published so they can be inspected.
+type PublishedLister interface {
+	Published() []PublishedDelta
+	ClearPublished()
+}
+
+// NewPublisher returns the KafkaService publishing deltas to the backend chosen by PUBLISHER, which defaults to Kafka.
+func NewPublisher(cfg *config.Config) (KafkaService, error) {
+	switch cfg.Publisher {
+	case "", config.PublisherKafka:
+		kSvc := NewKafkaService()
+		return &kSvc, nil
+	case config.PublisherMemory:
+		return NewMemoryPublisher(), nil
+	case config.PublisherFile:
+		return NewFilePublisher(cfg.PublisherFile), nil
+	case config.PublisherStdout:
+		return NewWriterPublisher(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("unknown publisher %q", cfg.Publisher)
+	}
+}
+
+// newPublishedDelta returns a delta as it would be published to Kafka, keyed by its first primary id and with the same
+// record headers.
+func newPublishedDelta(topic, data string, meta models.DeltaMetadata) PublishedDelta {
+
+	pd := PublishedDelta{
+		Topic:     topic,
+		Headers:   make(map[string]string),
+		ContextId: meta.ContextId,
+		IsDelete:  meta.IsDelete,
+		Data:      data,
+	}
+	if len(meta.PrimaryIds) > 0 {
+		pd.Key = meta.PrimaryIds[0]
+	}
+	for _, h := range buildHeaders(data, meta) {
+		pd.Headers[string(h.Key)] = string(h.Value)
+	}
+
+	return pd
+}
+
+// MemoryPublisher keeps published deltas in memory, so they can be inspected when running without Kafka.
+type MemoryPublisher struct {
+	mtx       sync.Mutex
+	published []PublishedDelta
+}
+
+// NewMemoryPublisher returns a MemoryPublisher which hasn't published anything.
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Init does nothing, as a MemoryPublisher needs no configuration.
+func (m *MemoryPublisher) Init(cfg *config.Config) error {
+	return nil
+}
+
+// SendMessage keeps a delta in memory.
+func (m *MemoryPublisher) SendMessage(topic, data string, meta models.DeltaMetadata) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.published = append(m.published, newPublishedDelta(topic, data, meta))
+	log.InfoC(meta.ContextId, "Published delta to memory", log.Data{config.TopicKey: topic})
+	return nil
+}
+
+// Close does nothing, as a MemoryPublisher holds no resources.
+func (m *MemoryPublisher) Close() error {
+	return nil
+}
+
+// Published returns every delta published, oldest first.
+func (m *MemoryPublisher) Published() []PublishedDelta {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]PublishedDelta{}, m.published...)
+}
+
+// ClearPublished forgets every delta published.
+func (m *MemoryPublisher) ClearPublished() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.published = nil
+}
+
+// WriterPublisher writes published deltas as newline-delimited JSON, either to a writer such as stdout or to a file
+// opened by Init.
+type WriterPublisher struct {
+	mtx  sync.Mutex
+	w    io.Writer
+	path string
+}
+
+// NewWriterPublisher returns a WriterPublisher writing deltas to w.
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+// NewFilePublisher returns a WriterPublisher appending deltas to the file at path, which is opened by Init.
+func NewFilePublisher(path string) *WriterPublisher {
+	return &WriterPublisher{path: path}
+}
+
+// Init opens the file deltas are appended to, creating it if needed.
+func (wp *WriterPublisher) Init(cfg *config.Config) error {
+	if wp.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(wp.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		log.Error(fmt.Errorf("error opening publisher file: %s", err))
+		return err
+	}
+	wp.mtx.Lock()
+	defer wp.mtx.Unlock()
+	wp.w = f
+	return nil
+}
+
+// SendMessage writes a delta as a single line of JSON.
+func (wp *WriterPublisher) SendMessage(topic, data string, meta models.DeltaMetadata) error {
+
+	b, err := json.Marshal(newPublishedDelta(topic, data, meta))
+	if err != nil {
+		return err
+	}
+
+	// Write each line in one call so concurrent deltas aren't interleaved.
+	wp.mtx.Lock()
+	defer wp.mtx.Unlock()
+	if _, err := wp.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the file deltas are appended to, if Init opened one.
+func (wp *WriterPublisher) Close() error {
+	wp.mtx.Lock()
+	defer wp.mtx.Unlock()
+	if c, ok := wp.w.(io.Closer); ok && wp.path != "" {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestUnitNewPublisher asserts that the publisher is chosen by config.
+func TestUnitNewPublisher(t *testing.T) {
+
+	Convey("When I create a publisher", t, func() {
+		cases := map[string]interface{}{
+			"":                     &KafkaServiceImpl{},
+			config.PublisherKafka:  &KafkaServiceImpl{},
+			config.PublisherMemory: &MemoryPublisher{},
+			config.PublisherFile:   &WriterPublisher{},
+			config.PublisherStdout: &WriterPublisher{},
+		}
+		for publisher, expected := range cases {
+			p, err := NewPublisher(&config.Config{Publisher: publisher})
+			So(err, ShouldBeNil)
+			So(p, ShouldHaveSameTypeAs, expected)
+		}
+
+		Convey("Then an unknown publisher returns an error", func() {
+			_, err := NewPublisher(&config.Config{Publisher: "unknown"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitMemoryPublisher asserts that published deltas are kept in memory, keyed and headed as they would be in Kafka.
+func TestUnitMemoryPublisher(t *testing.T) {
+
+	Convey("Given a memory publisher", t, func() {
+		m := NewMemoryPublisher()
+		So(m.Init(&config.Config{}), ShouldBeNil)
+
+		Convey("When I publish deltas", func() {
+			So(m.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{Key}, IsDelete: true}), ShouldBeNil)
+			So(m.SendMessage("other", Data, models.DeltaMetadata{}), ShouldBeNil)
+
+			Convey("Then they are returned in the order they were published", func() {
+				published := m.Published()
+				So(published, ShouldHaveLength, 2)
+				So(published[0].Topic, ShouldEqual, Topic)
+				So(published[0].Key, ShouldEqual, Key)
+				So(published[0].ContextId, ShouldEqual, ContextId)
+				So(published[0].IsDelete, ShouldBeTrue)
+				So(published[0].Data, ShouldEqual, Data)
+				So(published[0].Headers[HeaderPrimaryIds], ShouldEqual, Key)
+				So(published[1].Topic, ShouldEqual, "other")
+			})
+
+			Convey("Then they can be cleared", func() {
+				m.ClearPublished()
+				So(m.Published(), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+// TestUnitWriterPublisher asserts that published deltas are written as newline-delimited JSON.
+func TestUnitWriterPublisher(t *testing.T) {
+
+	Convey("Given a publisher writing to a buffer", t, func() {
+		var buf bytes.Buffer
+		wp := NewWriterPublisher(&buf)
+		So(wp.Init(&config.Config{}), ShouldBeNil)
+
+		Convey("When I publish deltas, then each is written as a line of JSON", func() {
+			So(wp.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			So(wp.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			So(lines, ShouldHaveLength, 2)
+			var pd PublishedDelta
+			So(json.Unmarshal([]byte(lines[0]), &pd), ShouldBeNil)
+			So(pd.Topic, ShouldEqual, Topic)
+			So(pd.Data, ShouldEqual, Data)
+			So(pd.Headers[HeaderContextId], ShouldEqual, ContextId)
+		})
+	})
+
+	Convey("Given a publisher appending to a file which already has deltas", t, func() {
+		path := filepath.Join(t.TempDir(), "deltas.ndjson")
+		So(os.WriteFile(path, []byte("{}\n"), 0o600), ShouldBeNil)
+		wp := NewFilePublisher(path)
+		So(wp.Init(&config.Config{}), ShouldBeNil)
+
+		Convey("When I close it, then the file is closed", func() {
+			So(wp.Close(), ShouldBeNil)
+			So(wp.SendMessage(Topic, Data, models.DeltaMetadata{}), ShouldNotBeNil)
+		})
+
+		Convey("When I publish a delta, then it is appended", func() {
+			So(wp.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			b, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+			So(lines, ShouldHaveLength, 2)
+			So(lines[1], ShouldContainSubstring, `"context_id":"contextId"`)
+		})
+	})
+
+	Convey("Given a publisher whose file can't be opened, then Init returns an error", t, func() {
+		wp := NewFilePublisher(filepath.Join(t.TempDir(), "missing", "deltas.ndjson"))
+		So(wp.Init(&config.Config{}), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Statuses of a readiness check. A degraded check is reported but doesn't stop the service being ready.
+const (
+	CheckOk       = "ok"
+	CheckDegraded = "degraded"
+	CheckFailing  = "failing"
+)
+
+// readinessTimeout limits how long connecting to the brokers can take when checking readiness.
+const readinessTimeout = 5 * time.Second
+
+// Used for unit testing. Allows the brokers' metadata to be mocked.
+var (
+	callClusterTopics = clusterTopics
+)
+
+// ReadinessCheck is the outcome of checking a dependency the service needs to accept deltas.
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ReadinessChecker is implemented by Kafka services which can check the dependencies they need to publish deltas to
+// the given topics.
+type ReadinessChecker interface {
+	ReadinessChecks(topics []string) []ReadinessCheck
+}
+
+// ReadinessChecks checks that the brokers' metadata can be fetched, that the given topics exist and that a schema has
+// been loaded. Running with a fallback schema is reported as degraded.
+func (kSvc *KafkaServiceImpl) ReadinessChecks(topics []string) []ReadinessCheck {
+
+	checks := make([]ReadinessCheck, 0, 3)
+
+	existing, err := callClusterTopics(kSvc)
+	if err != nil {
+		checks = append(checks,
+			ReadinessCheck{Name: "kafka_brokers", Status: CheckFailing, Detail: err.Error()},
+			ReadinessCheck{Name: "kafka_topics", Status: CheckFailing, Detail: "broker metadata unavailable"})
+	} else {
+		checks = append(checks, ReadinessCheck{Name: "kafka_brokers", Status: CheckOk})
+		if missing := missingTopics(topics, existing); len(missing) > 0 {
+			checks = append(checks, ReadinessCheck{Name: "kafka_topics", Status: CheckFailing, Detail: "missing topics: " + strings.Join(missing, ", ")})
+		} else {
+			checks = append(checks, ReadinessCheck{Name: "kafka_topics", Status: CheckOk})
+		}
+	}
+
+	kSvc.schemaMtx.RLock()
+	schema, source := kSvc.schema, kSvc.schemaSource
+	kSvc.schemaMtx.RUnlock()
+	switch {
+	case schema == "":
+		checks = append(checks, ReadinessCheck{Name: "schema", Status: CheckFailing, Detail: "no schema loaded"})
+	case source != "":
+		checks = append(checks, ReadinessCheck{Name: "schema", Status: CheckDegraded, Detail: "schema registry unavailable, using fallback schema from " + source})
+	default:
+		checks = append(checks, ReadinessCheck{Name: "schema", Status: CheckOk})
+	}
+
+	return checks
+}
+
+// missingTopics returns the sorted, distinct topics which don't exist.
+func missingTopics(topics, existing []string) []string {
+	exists := make(map[string]bool, len(existing))
+	for _, t := range existing {
+		exists[t] = true
+	}
+	seen := make(map[string]bool)
+	var missing []string
+	for _, t := range topics {
+		if !exists[t] && !seen[t] {
+			missing = append(missing, t)
+			seen[t] = true
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// clusterTopics refreshes the brokers' metadata and returns the topics which exist. A client is connected on first use
+// and kept for later checks, but discarded if its metadata can't be refreshed.
+func clusterTopics(k *KafkaServiceImpl) ([]string, error) {
+
+	k.clientMtx.Lock()
+	defer k.clientMtx.Unlock()
+
+	if k.client == nil {
+		cfg := sarama.NewConfig()
+		cfg.Net.DialTimeout = readinessTimeout
+		cfg.Metadata.Retry.Max = 0
+		c, err := sarama.NewClient(k.brokers, cfg)
+		if err != nil {
+			return nil, err
+		}
+		k.client = c
+	}
+
+	if err := k.client.RefreshMetadata(); err != nil {
+		_ = k.client.Close()
+		k.client = nil
+		return nil, fmt.Errorf("error refreshing broker metadata: %w", err)
+	}
+
+	return k.client.Topics()
+}
+
+// ReadinessChecks reports whether the outbox can accept deltas, along with the checks of the Kafka service it sends to.
+// While deltas can be held in the outbox, failing Kafka checks are reported as degraded.
+func (o *OutboxService) ReadinessChecks(topics []string) []ReadinessCheck {
+
+	var checks []ReadinessCheck
+	if r, ok := o.kSvc.(ReadinessChecker); ok {
+		for _, c := range r.ReadinessChecks(topics) {
+			if c.Status == CheckFailing {
+				c.Status = CheckDegraded
+			}
+			checks = append(checks, c)
+		}
+	}
+
+	stats := o.OutboxStats()
+	if stats.Bytes >= stats.MaxBytes {
+		checks = append(checks, ReadinessCheck{Name: "outbox", Status: CheckFailing, Detail: fmt.Sprintf("outbox full with %d deltas", stats.Depth)})
+	} else {
+		checks = append(checks, ReadinessCheck{Name: "outbox", Status: CheckOk, Detail: fmt.Sprintf("%d deltas waiting", stats.Depth)})
+	}
+
+	return checks
+}
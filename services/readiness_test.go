@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"github.com/companieshouse/chs-delta-api/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"math"
+	"testing"
+)
+
+// checkingFakeKafkaService is a fakeKafkaService returning fixed readiness checks.
+type checkingFakeKafkaService struct {
+	fakeKafkaService
+	checks []ReadinessCheck
+}
+
+func (c *checkingFakeKafkaService) ReadinessChecks(topics []string) []ReadinessCheck {
+	return c.checks
+}
+
+// TestUnitKafkaServiceReadinessChecks asserts that the brokers, topics and schema are checked.
+func TestUnitKafkaServiceReadinessChecks(t *testing.T) {
+
+	Convey("Given a Kafka service with a schema from the registry", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+		defer func() { callClusterTopics = clusterTopics }()
+
+		Convey("When the brokers have every topic, then all checks pass", func() {
+			callClusterTopics = func(k *KafkaServiceImpl) ([]string, error) {
+				return []string{"a", "b"}, nil
+			}
+			So(k.ReadinessChecks([]string{"a", "b", "a"}), ShouldResemble, []ReadinessCheck{
+				{Name: "kafka_brokers", Status: CheckOk},
+				{Name: "kafka_topics", Status: CheckOk},
+				{Name: "schema", Status: CheckOk},
+			})
+		})
+
+		Convey("When topics are missing, then the topic check fails naming them", func() {
+			callClusterTopics = func(k *KafkaServiceImpl) ([]string, error) {
+				return []string{"a"}, nil
+			}
+			checks := k.ReadinessChecks([]string{"c", "a", "b", "c"})
+			So(checks[1], ShouldResemble, ReadinessCheck{Name: "kafka_topics", Status: CheckFailing, Detail: "missing topics: b, c"})
+		})
+
+		Convey("When the brokers can't be reached, then the broker and topic checks fail", func() {
+			callClusterTopics = func(k *KafkaServiceImpl) ([]string, error) {
+				return nil, errors.New("brokers unavailable")
+			}
+			checks := k.ReadinessChecks([]string{"a"})
+			So(checks[0], ShouldResemble, ReadinessCheck{Name: "kafka_brokers", Status: CheckFailing, Detail: "brokers unavailable"})
+			So(checks[1].Status, ShouldEqual, CheckFailing)
+		})
+
+		Convey("When it is using a fallback schema, then the schema check is degraded", func() {
+			callClusterTopics = func(k *KafkaServiceImpl) ([]string, error) {
+				return nil, nil
+			}
+			k.schemaSource = "embedded"
+			So(k.ReadinessChecks(nil)[2].Status, ShouldEqual, CheckDegraded)
+		})
+	})
+}
+
+// TestUnitOutboxReadinessChecks asserts that failing Kafka checks are degraded while the outbox can hold deltas.
+func TestUnitOutboxReadinessChecks(t *testing.T) {
+
+	Convey("Given an outbox sending to a Kafka service which is failing", t, func() {
+		f := &checkingFakeKafkaService{checks: []ReadinessCheck{{Name: "kafka_brokers", Status: CheckFailing}}}
+		f.initErrs = math.MaxInt
+		o := NewOutboxService(f, t.TempDir(), 0)
+		So(o.Init(&config.Config{}), ShouldBeNil)
+		defer o.Close()
+
+		Convey("When I check readiness, then the Kafka checks are degraded and the outbox passes", func() {
+			So(o.ReadinessChecks(nil), ShouldResemble, []ReadinessCheck{
+				{Name: "kafka_brokers", Status: CheckDegraded},
+				{Name: "outbox", Status: CheckOk, Detail: "0 deltas waiting"},
+			})
+		})
+	})
+}
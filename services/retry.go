@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultSendAttempts is the number of attempts made to send a message when none is configured.
+	DefaultSendAttempts = 3
+	// DefaultRetryBackoff is the backoff before the first retry when none is configured.
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// MaxRetryBackoff caps the backoff between retries.
+	MaxRetryBackoff = 5 * time.Second
+)
+
+// Used for unit testing. Allows the backoff between retries to be skipped and made deterministic.
+var (
+	callSleep  = time.Sleep
+	callJitter = rand.Int64N
+)
+
+// retriableErrors are the errors returned when sending to Kafka which are expected to clear by themselves, typically
+// while partition leadership moves between brokers or a broker restarts.
+var retriableErrors = []error{
+	sarama.ErrOutOfBrokers,
+	sarama.ErrNotConnected,
+	sarama.ErrControllerNotAvailable,
+	sarama.ErrLeaderNotAvailable,
+	sarama.ErrNotLeaderForPartition,
+	sarama.ErrRequestTimedOut,
+	sarama.ErrBrokerNotAvailable,
+	sarama.ErrReplicaNotAvailable,
+	sarama.ErrNetworkException,
+	sarama.ErrNotEnoughReplicas,
+	sarama.ErrNotEnoughReplicasAfterAppend,
+	sarama.ErrNotController,
+	sarama.ErrKafkaStorageError,
+}
+
+// retryPolicy decides how many times, and how often, sending a message is attempted.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// newRetryPolicy returns a retryPolicy making the given number of attempts with the given initial backoff, using the
+// defaults for values which aren't greater than zero.
+func newRetryPolicy(attempts int, backoff time.Duration) retryPolicy {
+	if attempts <= 0 {
+		attempts = DefaultSendAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	return retryPolicy{attempts: attempts, backoff: backoff}
+}
+
+// delay returns the backoff before the given retry, counting from 1. The backoff doubles with each retry up to
+// MaxRetryBackoff, and is jittered to between half and all of that so retries from many requests don't line up.
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := p.backoff
+	for i := 1; i < retry && d < MaxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxRetryBackoff {
+		d = MaxRetryBackoff
+	}
+	half := int64(d / 2)
+	return time.Duration(half + callJitter(half+1))
+}
+
+// isRetriable reports whether an error sending to Kafka is worth retrying.
+func isRetriable(err error) bool {
+	var pErr *sarama.ProducerError
+	if errors.As(err, &pErr) {
+		err = pErr.Err
+	}
+	for _, r := range retriableErrors {
+		if errors.Is(err, r) {
+			return true
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
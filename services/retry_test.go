@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestUnitRetryPolicyDelay asserts that the backoff doubles with each retry, is jittered, and is capped.
+func TestUnitRetryPolicyDelay(t *testing.T) {
+
+	Convey("Given a retry policy", t, func() {
+		p := newRetryPolicy(0, 0)
+		So(p.attempts, ShouldEqual, DefaultSendAttempts)
+		So(p.backoff, ShouldEqual, DefaultRetryBackoff)
+
+		Convey("When the jitter is at its maximum, then the backoff doubles with each retry up to the cap", func() {
+			callJitter = func(n int64) int64 { return n - 1 }
+			So(p.delay(1), ShouldEqual, 100*time.Millisecond)
+			So(p.delay(2), ShouldEqual, 200*time.Millisecond)
+			So(p.delay(3), ShouldEqual, 400*time.Millisecond)
+			So(p.delay(20), ShouldEqual, MaxRetryBackoff)
+		})
+
+		Convey("When the jitter is at its minimum, then the backoff is halved", func() {
+			callJitter = func(n int64) int64 { return 0 }
+			So(p.delay(1), ShouldEqual, 50*time.Millisecond)
+			So(p.delay(20), ShouldEqual, MaxRetryBackoff/2)
+		})
+	})
+}
+
+// TestUnitIsRetriable asserts that only errors expected to clear by themselves are retried.
+func TestUnitIsRetriable(t *testing.T) {
+
+	Convey("Given errors returned when sending to Kafka", t, func() {
+
+		Convey("When the broker is unavailable or leadership is moving, then the error is retriable", func() {
+			So(isRetriable(sarama.ErrOutOfBrokers), ShouldBeTrue)
+			So(isRetriable(sarama.ErrNotLeaderForPartition), ShouldBeTrue)
+			So(isRetriable(&sarama.ProducerError{Err: sarama.ErrNotEnoughReplicas}), ShouldBeTrue)
+			So(isRetriable(fmt.Errorf("wrapped: %w", sarama.ErrRequestTimedOut)), ShouldBeTrue)
+			So(isRetriable(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), ShouldBeTrue)
+		})
+
+		Convey("When the message itself is at fault, then the error is not retriable", func() {
+			So(isRetriable(sarama.ErrMessageSizeTooLarge), ShouldBeFalse)
+			So(isRetriable(sarama.ErrInvalidMessage), ShouldBeFalse)
+			So(isRetriable(errors.New("bad schema")), ShouldBeFalse)
+		})
+	})
+}
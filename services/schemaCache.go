@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs.go/log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultSchemaRefresh is the interval between attempts to reach the schema registry, after starting with a fallback
+// schema, when none is configured.
+const DefaultSchemaRefresh = time.Minute
+
+// embeddedSchema is the chs-delta schema built into the service, used when neither the registry nor a cached copy of
+// the schema is available.
+//
+//go:embed schemas/chs-delta.avsc
+var embeddedSchema string
+
+// errIncompatibleSchema is returned when the registry's schema can't be used to write a chs-delta. Falling back to
+// another schema would hide this, so the service doesn't start.
+var errIncompatibleSchema = errors.New("schema is not compatible with the chs-delta")
+
+// fallbackSchema returns the schema to use while the registry is unavailable: the cached copy if it matches the
+// configured version, otherwise the embedded schema. The embedded schema has no version or id, so isn't used when a
+// version is pinned or the wire format is used.
+func fallbackSchema(cfg *config.Config) (registeredSchema, string, error) {
+
+	if cfg.SchemaCacheFile != "" {
+		rs, err := readSchemaCache(cfg.SchemaCacheFile)
+		switch {
+		case err != nil:
+			log.Error(err, log.Data{config.MessageKey: "error reading cached schema"})
+		case cfg.SchemaVersion != 0 && rs.Version != cfg.SchemaVersion:
+			log.Info("Cached schema is not the configured version", log.Data{config.SchemaVersionKey: rs.Version})
+		case cfg.SchemaWireFormat && rs.Id == 0:
+			log.Info("Cached schema has no id to use with the wire format", log.Data{config.SchemaVersionKey: rs.Version})
+		default:
+			if err := checkChsDeltaCompatible(rs.Schema); err != nil {
+				return rs, "", fmt.Errorf("cached %w: %s", errIncompatibleSchema, err)
+			}
+			return rs, cfg.SchemaCacheFile, nil
+		}
+	}
+
+	if cfg.SchemaVersion != 0 || cfg.SchemaWireFormat {
+		return registeredSchema{}, "", errors.New("no cached schema with the configured version and id")
+	}
+
+	return registeredSchema{Subject: SchemaName, Schema: embeddedSchema}, "embedded", nil
+}
+
+// readSchemaCache reads a schema cached by writeSchemaCache.
+func readSchemaCache(path string) (registeredSchema, error) {
+	var rs registeredSchema
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return rs, err
+	}
+	err = json.Unmarshal(b, &rs)
+	return rs, err
+}
+
+// writeSchemaCache durably writes a schema fetched from the registry, along with its version and id, so it can be
+// used if the registry is unavailable when the service next starts.
+func writeSchemaCache(path string, rs registeredSchema) error {
+	b, err := json.Marshal(rs)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(filepath.Dir(path), filepath.Base(path), b)
+}
+
+// cacheSchema caches a schema if a cache file is configured, logging rather than failing if it can't be written.
+func cacheSchema(cfg *config.Config, rs registeredSchema) {
+	if cfg.SchemaCacheFile == "" {
+		return
+	}
+	if err := writeSchemaCache(cfg.SchemaCacheFile, rs); err != nil {
+		log.Error(err, log.Data{config.MessageKey: "error caching schema"})
+	}
+}
+
+// sameSchema reports whether two schema definitions are the same, ignoring insignificant whitespace.
+func sameSchema(a, b string) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, []byte(a)) != nil || json.Compact(&cb, []byte(b)) != nil {
+		return a == b
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// reconcileSchema tries to reach the registry every interval after starting with a fallback schema, until it does or
+// stop is closed.
+func (kSvc *KafkaServiceImpl) reconcileSchema(cfg *config.Config, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if kSvc.refreshSchema(cfg) {
+				return
+			}
+		}
+	}
+}
+
+// refreshSchema fetches the schema from the registry, reporting whether it was reached. A warning is logged if the
+// schema differs from the fallback being used, and the registry's schema is used from then on, unless it isn't
+// compatible with the chs-delta.
+func (kSvc *KafkaServiceImpl) refreshSchema(cfg *config.Config) bool {
+
+	rs, err := initSchema(cfg)
+	if errors.Is(err, errIncompatibleSchema) {
+		log.Error(err, log.Data{config.MessageKey: "registry schema is not compatible, keeping the fallback schema"})
+		return true
+	} else if err != nil {
+		return false
+	}
+
+	kSvc.schemaMtx.Lock()
+	current := kSvc.schema
+	kSvc.schema = rs.Schema
+	kSvc.schemaId = rs.Id
+	kSvc.schemaSource = ""
+	kSvc.schemaMtx.Unlock()
+
+	if !sameSchema(current, rs.Schema) {
+		// The logger has no warning level, so this is logged as an error to be noticed.
+		log.Error(fmt.Errorf("registry %s schema differs from the fallback schema used at startup, using the registry schema", SchemaName),
+			log.Data{config.SchemaVersionKey: rs.Version, config.SchemaIdKey: rs.Id})
+	} else {
+		log.Info("Reconciled fallback schema with the registry", log.Data{"schema_name": SchemaName, config.SchemaVersionKey: rs.Version})
+	}
+
+	cacheSchema(cfg, rs)
+	return true
+}
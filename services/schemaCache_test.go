@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs-delta-api/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUnitEmbeddedSchemaCompatible asserts that the embedded schema can be used to write a chs-delta.
+func TestUnitEmbeddedSchemaCompatible(t *testing.T) {
+	Convey("When I check the embedded schema, then it is compatible with the chs-delta", t, func() {
+		So(checkChsDeltaCompatible(embeddedSchema), ShouldBeNil)
+	})
+}
+
+// TestUnitKafkaServiceInitCachedSchema asserts that the schema is cached and used when the registry is unavailable.
+func TestUnitKafkaServiceInitCachedSchema(t *testing.T) {
+
+	Convey("Given a Kafka service with a schema cache and a pinned version using the wire format", t, func() {
+		cache := filepath.Join(t.TempDir(), "chs-delta.json")
+		cfg := &config.Config{SchemaCacheFile: cache, SchemaVersion: 3, SchemaWireFormat: true}
+
+		callProducerNew = func(addrs []string, config *sarama.Config) (sarama.SyncProducer, error) {
+			return fakeProducer{}, nil
+		}
+		defer func() { callSchemaFetch = fetchSchema }()
+		registryUp := func(url, subject string, version int) (registeredSchema, error) {
+			return registeredSchema{Subject: subject, Version: version, Id: 42, Schema: CompatibleSchema}, nil
+		}
+		registryDown := func(url, subject string, version int) (registeredSchema, error) {
+			return registeredSchema{}, errors.New("registry unavailable")
+		}
+
+		Convey("When the registry is available, then the schema is cached", func() {
+			callSchemaFetch = registryUp
+			k := NewKafkaService()
+			So(k.Init(cfg), ShouldBeNil)
+
+			rs, err := readSchemaCache(cache)
+			So(err, ShouldBeNil)
+			So(rs, ShouldResemble, registeredSchema{Subject: SchemaName, Version: 3, Id: 42, Schema: CompatibleSchema})
+
+			Convey("Then the cached schema and id are used when the registry is unavailable at the next start", func() {
+				callSchemaFetch = registryDown
+				k := NewKafkaService()
+				So(k.Init(cfg), ShouldBeNil)
+				So(k.schema, ShouldEqual, CompatibleSchema)
+				So(k.schemaId, ShouldEqual, 42)
+			})
+
+			Convey("Then the cached schema isn't used if a different version is pinned", func() {
+				callSchemaFetch = registryDown
+				cfg.SchemaVersion = 4
+				k := NewKafkaService()
+				So(k.Init(cfg), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When nothing is cached and the registry is unavailable, then the embedded schema can't be used", func() {
+			callSchemaFetch = registryDown
+			k := NewKafkaService()
+			So(k.Init(cfg), ShouldNotBeNil)
+		})
+
+		Convey("When the cached schema isn't compatible, then an error is returned", func() {
+			So(writeSchemaCache(cache, registeredSchema{Subject: SchemaName, Version: 3, Id: 42, Schema: GoodSchema}), ShouldBeNil)
+			callSchemaFetch = registryDown
+			k := NewKafkaService()
+			So(k.Init(cfg), ShouldNotBeNil)
+		})
+
+		Convey("When the registry's schema isn't compatible, then no fallback is used", func() {
+			So(writeSchemaCache(cache, registeredSchema{Subject: SchemaName, Version: 3, Id: 42, Schema: CompatibleSchema}), ShouldBeNil)
+			callSchemaFetch = func(url, subject string, version int) (registeredSchema, error) {
+				return registeredSchema{Subject: subject, Version: version, Id: 42, Schema: GoodSchema}, nil
+			}
+			k := NewKafkaService()
+			So(k.Init(cfg), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given the cache can't be read, then the embedded schema is used", t, func() {
+		cfg := &config.Config{SchemaCacheFile: filepath.Join(t.TempDir(), "missing.json")}
+		rs, source, err := fallbackSchema(cfg)
+		So(err, ShouldBeNil)
+		So(source, ShouldEqual, "embedded")
+		So(rs.Schema, ShouldEqual, embeddedSchema)
+	})
+}
+
+// TestUnitRefreshSchema asserts that a fallback schema is reconciled with the registry once it can be reached.
+func TestUnitRefreshSchema(t *testing.T) {
+
+	Convey("Given a Kafka service started with the embedded schema", t, func() {
+		cache := filepath.Join(t.TempDir(), "chs-delta.json")
+		cfg := &config.Config{SchemaCacheFile: cache, SchemaWireFormat: true}
+		k := NewKafkaService()
+		k.schema = embeddedSchema
+		defer func() { callSchemaFetch = fetchSchema }()
+
+		Convey("When the registry is still unavailable, then the fallback is kept", func() {
+			callSchemaFetch = func(url, subject string, version int) (registeredSchema, error) {
+				return registeredSchema{}, errors.New("registry unavailable")
+			}
+			So(k.refreshSchema(cfg), ShouldBeFalse)
+			So(k.schema, ShouldEqual, embeddedSchema)
+		})
+
+		Convey("When the registry has a different schema, then it is used and cached", func() {
+			callSchemaFetch = func(url, subject string, version int) (registeredSchema, error) {
+				return registeredSchema{Subject: subject, Version: 5, Id: 42, Schema: CompatibleSchema}, nil
+			}
+			So(k.refreshSchema(cfg), ShouldBeTrue)
+			So(k.schema, ShouldEqual, CompatibleSchema)
+			So(k.schemaId, ShouldEqual, 42)
+			_, err := os.Stat(cache)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When the registry's schema isn't compatible, then the fallback is kept", func() {
+			callSchemaFetch = func(url, subject string, version int) (registeredSchema, error) {
+				return registeredSchema{Subject: subject, Version: 5, Id: 42, Schema: GoodSchema}, nil
+			}
+			So(k.refreshSchema(cfg), ShouldBeTrue)
+			So(k.schema, ShouldEqual, embeddedSchema)
+		})
+	})
+}
+
+// TestUnitSameSchema asserts that schemas are compared ignoring whitespace.
+func TestUnitSameSchema(t *testing.T) {
+	Convey("When I compare schemas, then whitespace is ignored", t, func() {
+		So(sameSchema(`{"type": "record"}`, "{\n  \"type\":\"record\"\n}"), ShouldBeTrue)
+		So(sameSchema(`{"type":"record"}`, `{"type":"enum"}`), ShouldBeFalse)
+	})
+}
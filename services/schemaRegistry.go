@@ -0,0 +1,163 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/companieshouse/chs-delta-api/models"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// confluentMagicByte starts every message in the Confluent wire format, ahead of the 4-byte schema id.
+const confluentMagicByte = 0
+
+// schemaRegistryTimeout limits how long fetching a schema from the registry can take.
+const schemaRegistryTimeout = 10 * time.Second
+
+// Used for unit testing. Allows the schema registry to be mocked.
+var (
+	callSchemaFetch = fetchSchema
+)
+
+// registeredSchema is a version of a subject's schema as returned by the schema registry.
+type registeredSchema struct {
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+	Id      int    `json:"id"`
+	Schema  string `json:"schema"`
+}
+
+// fetchSchema fetches a version of a subject's schema from the schema registry, along with its id. A version of 0
+// fetches the latest.
+func fetchSchema(registryURL, subject string, version int) (registeredSchema, error) {
+
+	v := "latest"
+	if version > 0 {
+		v = strconv.Itoa(version)
+	}
+	u := strings.TrimSuffix(registryURL, "/") + "/subjects/" + url.PathEscape(subject) + "/versions/" + v
+
+	var rs registeredSchema
+	client := http.Client{Timeout: schemaRegistryTimeout}
+	resp, err := client.Get(u)
+	if err != nil {
+		return rs, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return rs, fmt.Errorf("schema registry returned %s for %s version %s", resp.Status, subject, v)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rs); err != nil {
+		return rs, fmt.Errorf("error decoding %s version %s from schema registry: %w", subject, v, err)
+	}
+
+	return rs, nil
+}
+
+// toWireFormat prefixes Avro encoded bytes with the magic byte and big-endian schema id of the Confluent wire format,
+// so consumers can look up the schema which wrote them.
+func toWireFormat(schemaId int, avroBytes []byte) []byte {
+	b := make([]byte, 5, 5+len(avroBytes))
+	b[0] = confluentMagicByte
+	binary.BigEndian.PutUint32(b[1:5], uint32(schemaId))
+	return append(b, avroBytes...)
+}
+
+// avroTypes are the Avro primitive types Go kinds are written as.
+var avroTypes = map[reflect.Kind]string{
+	reflect.Bool:    "boolean",
+	reflect.Int32:   "int",
+	reflect.Int64:   "long",
+	reflect.Float32: "float",
+	reflect.Float64: "double",
+	reflect.String:  "string",
+}
+
+// avroField is a field of an Avro record schema.
+type avroField struct {
+	Name    string          `json:"name"`
+	Type    json.RawMessage `json:"type"`
+	Default json.RawMessage `json:"default"`
+}
+
+// checkChsDeltaCompatible returns an error unless models.ChsDelta can be written with the given Avro record schema:
+// every field of the chs-delta must be in the schema with a matching type, and every schema field without a default
+// must be in the chs-delta.
+func checkChsDeltaCompatible(schemaText string) error {
+
+	var record struct {
+		Type   string      `json:"type"`
+		Fields []avroField `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(schemaText), &record); err != nil {
+		return fmt.Errorf("error parsing schema: %w", err)
+	}
+	if record.Type != "record" {
+		return fmt.Errorf("schema is a %q, not a record", record.Type)
+	}
+
+	fields := make(map[string]avroField, len(record.Fields))
+	for _, f := range record.Fields {
+		fields[f.Name] = f
+	}
+
+	t := reflect.TypeOf(models.ChsDelta{})
+	provided := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("avro")
+		provided[name] = true
+
+		f, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("schema has no field %s", name)
+		}
+		want := avroTypes[t.Field(i).Type.Kind()]
+		if !avroTypeAccepts(f.Type, want) {
+			return fmt.Errorf("schema field %s is %s, not %s", name, f.Type, want)
+		}
+	}
+
+	for _, f := range record.Fields {
+		// A default of null is kept as the raw "null", so only a missing default is empty.
+		if !provided[f.Name] && len(f.Default) == 0 {
+			return fmt.Errorf("schema field %s has no default and isn't in the chs-delta", f.Name)
+		}
+	}
+
+	return nil
+}
+
+// avroTypeAccepts reports whether an Avro field type, which may be a name, a union of types or a type object, accepts
+// values of the given primitive type.
+func avroTypeAccepts(raw json.RawMessage, want string) bool {
+
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		return name == want
+	}
+
+	var union []json.RawMessage
+	if json.Unmarshal(raw, &union) == nil {
+		for _, u := range union {
+			if avroTypeAccepts(u, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var obj struct {
+		Type json.RawMessage `json:"type"`
+	}
+	if json.Unmarshal(raw, &obj) == nil && obj.Type != nil {
+		return avroTypeAccepts(obj.Type, want)
+	}
+
+	return false
+}
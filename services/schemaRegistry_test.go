@@ -0,0 +1,147 @@
+package services
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// CompatibleSchema is a chs-delta schema models.ChsDelta can be written with.
+const CompatibleSchema = `{"type":"record","name":"delta","fields":[
+{"name":"data","type":"string"},
+{"name":"attempt","type":"int","default":0},
+{"name":"context_id","type":"string"},
+{"name":"is_delete","type":["boolean","null"],"default":false},
+{"name":"note","type":["null","string"],"default":null}]}`
+
+// TestUnitFetchSchema asserts that schemas are fetched from the registry by version along with their id.
+func TestUnitFetchSchema(t *testing.T) {
+
+	Convey("Given a schema registry", t, func() {
+		var paths []string
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			if r.URL.Path == "/subjects/chs-delta/versions/9" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{"subject":"chs-delta","version":3,"id":42,"schema":"{}"}`))
+		}))
+		defer registry.Close()
+
+		Convey("When I fetch the latest schema, then it is returned with its id", func() {
+			rs, err := fetchSchema(registry.URL+"/", SchemaName, 0)
+			So(err, ShouldBeNil)
+			So(rs, ShouldResemble, registeredSchema{Subject: "chs-delta", Version: 3, Id: 42, Schema: "{}"})
+			So(paths, ShouldResemble, []string{"/subjects/chs-delta/versions/latest"})
+		})
+
+		Convey("When I fetch a pinned version, then that version is requested", func() {
+			_, err := fetchSchema(registry.URL, SchemaName, 3)
+			So(err, ShouldBeNil)
+			So(paths, ShouldResemble, []string{"/subjects/chs-delta/versions/3"})
+		})
+
+		Convey("When the version doesn't exist, then an error is returned", func() {
+			_, err := fetchSchema(registry.URL, SchemaName, 9)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitToWireFormat asserts that messages are prefixed with the magic byte and big-endian schema id.
+func TestUnitToWireFormat(t *testing.T) {
+	Convey("When I convert Avro bytes to the wire format, then the header is prepended", t, func() {
+		So(toWireFormat(0x01020304, []byte{0xAA, 0xBB}), ShouldResemble, []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0xAA, 0xBB})
+	})
+}
+
+// TestUnitCheckChsDeltaCompatible asserts that schemas models.ChsDelta can't be written with are rejected.
+func TestUnitCheckChsDeltaCompatible(t *testing.T) {
+	Convey("When I check schemas against the chs-delta", t, func() {
+		So(checkChsDeltaCompatible(CompatibleSchema), ShouldBeNil)
+
+		Convey("Then a schema missing a chs-delta field is rejected", func() {
+			So(checkChsDeltaCompatible(GoodSchema), ShouldNotBeNil)
+		})
+
+		Convey("Then a schema with a mismatched type is rejected", func() {
+			So(checkChsDeltaCompatible(`{"type":"record","fields":[{"name":"data","type":"string"},
+{"name":"attempt","type":"long"},{"name":"context_id","type":"string"},{"name":"is_delete","type":"boolean"}]}`), ShouldNotBeNil)
+		})
+
+		Convey("Then a schema with a required field the chs-delta doesn't have is rejected", func() {
+			So(checkChsDeltaCompatible(`{"type":"record","fields":[{"name":"data","type":"string"},
+{"name":"attempt","type":"int"},{"name":"context_id","type":{"type":"string"}},{"name":"is_delete","type":"boolean"},
+{"name":"extra","type":"string"}]}`), ShouldNotBeNil)
+		})
+
+		Convey("Then a schema which isn't a record is rejected", func() {
+			So(checkChsDeltaCompatible(`"string"`), ShouldNotBeNil)
+			So(checkChsDeltaCompatible(BadSchema), ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitKafkaServiceInitPinnedSchema asserts that a pinned schema version is fetched with its id and checked.
+func TestUnitKafkaServiceInitPinnedSchema(t *testing.T) {
+
+	Convey("Given a Kafka service configured with a pinned schema version and the wire format", t, func() {
+		cfg := &config.Config{SchemaRegistryURL: "registry", SchemaVersion: 3, SchemaWireFormat: true}
+		k := NewKafkaService()
+
+		callProducerNew = func(addrs []string, config *sarama.Config) (sarama.SyncProducer, error) {
+			return fakeProducer{}, nil
+		}
+		defer func() { callSchemaFetch = fetchSchema }()
+
+		Convey("When the version is compatible, then it is used with its id", func() {
+			var fetched int
+			callSchemaFetch = func(url, subject string, version int) (registeredSchema, error) {
+				fetched = version
+				return registeredSchema{Subject: subject, Version: version, Id: 42, Schema: CompatibleSchema}, nil
+			}
+
+			So(k.Init(cfg), ShouldBeNil)
+			So(fetched, ShouldEqual, 3)
+			So(k.schema, ShouldEqual, CompatibleSchema)
+			So(k.schemaId, ShouldEqual, 42)
+			So(k.wireFormat, ShouldBeTrue)
+		})
+
+		Convey("When the version isn't compatible, then an error is returned", func() {
+			callSchemaFetch = func(url, subject string, version int) (registeredSchema, error) {
+				return registeredSchema{Subject: subject, Version: version, Id: 42, Schema: GoodSchema}, nil
+			}
+
+			So(k.Init(cfg), ShouldNotBeNil)
+		})
+	})
+}
+
+// TestUnitSendMessageWireFormat asserts that messages are sent in the Confluent wire format when configured.
+func TestUnitSendMessageWireFormat(t *testing.T) {
+
+	Convey("Given a Kafka service using the wire format", t, func() {
+		k := NewKafkaService()
+		k.schema = CompatibleSchema
+		k.schemaId = 42
+		k.wireFormat = true
+
+		var sent *sarama.ProducerMessage
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			sent = msg
+			return 0, 0, nil
+		}
+
+		Convey("When I send a message, then it starts with the magic byte and schema id", func() {
+			So(k.SendMessage(Topic, Data, models.DeltaMetadata{ContextId: ContextId}), ShouldBeNil)
+			value, _ := sent.Value.Encode()
+			So(value[:5], ShouldResemble, []byte{0x00, 0x00, 0x00, 0x00, 0x2A})
+		})
+	})
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs-delta-api/models"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"testing"
+)
+
+const (
+	TraceId     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	TraceParent = "00-" + TraceId + "-00f067aa0ba902b7-01"
+)
+
+// spanAttributes returns the attributes of a recorded span by key.
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+// TestUnitSendMessageTracing asserts that sends are traced as children of the request's span, and that the send's
+// trace context is published in the record headers.
+func TestUnitSendMessageTracing(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	Convey("Given I have a Kafka service", t, func() {
+		k := NewKafkaService()
+		k.schema = GoodSchema
+
+		var sent *sarama.ProducerMessage
+		sendErr := error(nil)
+		callSend = func(k *KafkaServiceImpl, msg *sarama.ProducerMessage) (int32, int64, error) {
+			sent = msg
+			return int32(3), int64(42), sendErr
+		}
+		meta := models.DeltaMetadata{ContextId: ContextId, PrimaryIds: []string{"id-1", "id-2"}, TraceParent: TraceParent}
+
+		Convey("When I send a message with a trace context", func() {
+			So(k.SendMessage(Topic, Data, meta), ShouldBeNil)
+
+			spans := recorder.Ended()
+			span := spans[len(spans)-1]
+
+			Convey("Then the send is traced as a child of the request's span", func() {
+				So(span.Name(), ShouldEqual, Topic+" publish")
+				So(span.SpanKind(), ShouldEqual, trace.SpanKindProducer)
+				So(span.Parent().TraceID().String(), ShouldEqual, TraceId)
+				So(span.Parent().SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+
+				attrs := spanAttributes(span)
+				So(attrs["messaging.destination.name"].AsString(), ShouldEqual, Topic)
+				So(attrs["messaging.destination.partition.id"].AsString(), ShouldEqual, "3")
+				So(attrs["messaging.kafka.message.offset"].AsInt64(), ShouldEqual, 42)
+				So(attrs["messaging.kafka.message.key"].AsString(), ShouldEqual, "id-1")
+				So(attrs[AttributePrimaryIds].AsStringSlice(), ShouldResemble, []string{"id-1", "id-2"})
+			})
+
+			Convey("Then the send's trace context is published in the record headers", func() {
+				var traceParent string
+				for _, h := range sent.Headers {
+					if string(h.Key) == HeaderTraceParent {
+						traceParent = string(h.Value)
+					}
+				}
+				So(traceParent, ShouldEqual, "00-"+TraceId+"-"+span.SpanContext().SpanID().String()+"-01")
+			})
+		})
+
+		Convey("When the send fails", func() {
+			sendErr = errors.New("send failed")
+			So(k.SendMessage(Topic, Data, meta), ShouldNotBeNil)
+
+			Convey("Then the span records the error", func() {
+				spans := recorder.Ended()
+				So(spans[len(spans)-1].Status().Code, ShouldEqual, codes.Error)
+			})
+		})
+	})
+}
@@ -0,0 +1,107 @@
+// Package tracing configures OpenTelemetry so deltas can be traced from the HTTP request which delivered them to the
+// Kafka record they are published in.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/companieshouse/chs-delta-api/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServiceName is the name spans are reported under, unless OTEL_SERVICE_NAME is set.
+const ServiceName = "chs-delta-api"
+
+// FlushTimeout limits how long exporting the last spans can take when the service shuts down.
+const FlushTimeout = 5 * time.Second
+
+// instrumentationName identifies the spans created by this service.
+const instrumentationName = "github.com/companieshouse/chs-delta-api"
+
+// Names of the W3C trace context headers.
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// propagator reads and writes the W3C trace context. It is used whether or not spans are exported, so a caller's
+// trace context is passed on to Kafka either way.
+var propagator = propagation.TraceContext{}
+
+// Init sets the global tracer provider to export spans to the exporter chosen by TRACING_EXPORTER. The OTLP exporter
+// is configured with the standard OTEL_EXPORTER_OTLP_* environment variables. The returned function flushes and
+// stops the exporter, and should be called when the service shuts down.
+func Init(cfg *config.Config) (func(context.Context) error, error) {
+
+	otel.SetTextMapPropagator(propagator)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TracingExporter {
+	case "", config.TracingExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case config.TracingExporterOTLP:
+		exporter, err = otlptracehttp.New(context.Background())
+	case config.TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", cfg.TracingExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default service name.
+	if res, err = resource.Merge(res, resource.Environment()); err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer spans are started with.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Extract returns a copy of ctx holding the trace context read from an incoming request's headers, if it has one.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// TraceContext returns the traceparent and tracestate headers describing the span in ctx, which are empty if there
+// is no valid span.
+func TraceContext(ctx context.Context) (traceParent, traceState string) {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(HeaderTraceParent), carrier.Get(HeaderTraceState)
+}
+
+// WithTraceContext returns a copy of ctx holding the trace context described by traceparent and tracestate headers.
+func WithTraceContext(ctx context.Context, traceParent, traceState string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier{HeaderTraceParent: traceParent, HeaderTraceState: traceState})
+}
+
+// RecordError marks a span as failed with err.
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"github.com/companieshouse/chs-delta-api/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"net/http"
+	"testing"
+)
+
+const (
+	traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	traceState  = "congo=t61rcWkgMzE"
+)
+
+// TestUnitInit asserts that spans are only exported when an exporter is configured.
+func TestUnitInit(t *testing.T) {
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	Convey("When no exporter is configured", t, func() {
+		shutdown, err := Init(&config.Config{})
+
+		Convey("Then spans aren't recorded", func() {
+			So(err, ShouldBeNil)
+			_, span := Tracer().Start(context.Background(), "span")
+			So(span.IsRecording(), ShouldBeFalse)
+			So(shutdown(context.Background()), ShouldBeNil)
+		})
+	})
+
+	Convey("When the stdout exporter is configured", t, func() {
+		shutdown, err := Init(&config.Config{TracingExporter: config.TracingExporterStdout})
+
+		Convey("Then spans are recorded", func() {
+			So(err, ShouldBeNil)
+			_, span := Tracer().Start(context.Background(), "span")
+			So(span.IsRecording(), ShouldBeTrue)
+			So(shutdown(context.Background()), ShouldBeNil)
+		})
+	})
+
+	Convey("When an unknown exporter is configured, then an error is returned", t, func() {
+		_, err := Init(&config.Config{TracingExporter: "jaeger"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// TestUnitTraceContext asserts that the W3C trace context is read from request headers and written back out.
+func TestUnitTraceContext(t *testing.T) {
+	Convey("Given a request with a traceparent and tracestate", t, func() {
+		header := http.Header{}
+		header.Set(HeaderTraceParent, traceParent)
+		header.Set(HeaderTraceState, traceState)
+
+		Convey("When the trace context is extracted, then it is the caller's span", func() {
+			ctx := Extract(context.Background(), header)
+			sc := trace.SpanContextFromContext(ctx)
+			So(sc.TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(sc.SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+			So(sc.IsRemote(), ShouldBeTrue)
+
+			Convey("Then the same trace context is written back out", func() {
+				parent, state := TraceContext(ctx)
+				So(parent, ShouldEqual, traceParent)
+				So(state, ShouldEqual, traceState)
+				So(trace.SpanContextFromContext(WithTraceContext(context.Background(), parent, state)).Equal(sc), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given a request without a trace context, then none is written out", t, func() {
+		parent, state := TraceContext(Extract(context.Background(), http.Header{}))
+		So(parent, ShouldBeEmpty)
+		So(state, ShouldBeEmpty)
+	})
+
+	Convey("Given an invalid traceparent, then it is ignored", t, func() {
+		ctx := WithTraceContext(context.Background(), "not-a-traceparent", "")
+		So(trace.SpanContextFromContext(ctx).IsValid(), ShouldBeFalse)
+	})
+}
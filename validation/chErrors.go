@@ -0,0 +1,113 @@
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// Keys of the error_values of a CHError.
+const (
+	errorValueProperty   = "property"
+	errorValueConstraint = "constraint"
+	errorValueValue      = "value"
+)
+
+// errorTarget is the property of the request body a validation error is about.
+type errorTarget struct {
+	// path is the JSON path of the property, which is empty when the error is about the whole request body.
+	path []string
+	// property is the name of the property. Array items are named after the array holding them.
+	property string
+	// value is the value of the property, which is nil when it is missing.
+	value interface{}
+}
+
+// newErrorTarget returns the target of an error about the property at path.
+func newErrorTarget(path []string, value interface{}) errorTarget {
+	t := errorTarget{path: path, value: value}
+	for i := len(path) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(path[i]); err != nil {
+			t.property = path[i]
+			break
+		}
+	}
+	return t
+}
+
+// schemaErrorTarget returns the property a SchemaError is about. kin-openapi doesn't always locate errors at the
+// property at fault, nor give the property's own value, so both are corrected here.
+func schemaErrorTarget(se *openapi3.SchemaError) errorTarget {
+
+	path := se.JSONPointer()
+
+	switch se.SchemaField {
+	case "required":
+		// The missing property is located, but the value is the object missing it.
+		return newErrorTarget(path, nil)
+	case "properties":
+		// An unsupported property is located at the object holding it, so find the property named by the reason.
+		obj, _ := se.Value.(map[string]interface{})
+		for _, k := range propertyNames(obj) {
+			if se.Reason == fmt.Sprintf("property %q is unsupported", k) {
+				return newErrorTarget(append(path, k), obj[k])
+			}
+		}
+	case "maxProperties", "minProperties":
+		// The object is at fault, so name its properties rather than giving all of their values.
+		obj, _ := se.Value.(map[string]interface{})
+		return newErrorTarget(path, propertyNames(obj))
+	}
+
+	return newErrorTarget(path, se.Value)
+}
+
+// location returns the location of the target in the format of a CHError.
+func (t errorTarget) location() string {
+	if len(t.path) == 0 {
+		return "request-body"
+	}
+	return strings.Join(t.path, ".")
+}
+
+// errorValues returns the error_values of a CHError, holding the name of the property at fault, the constraint it broke
+// and its value. Each is left out when it doesn't apply.
+func (t errorTarget) errorValues(constraint interface{}) map[string]interface{} {
+	ev := make(map[string]interface{}, 3)
+	if t.property != "" {
+		ev[errorValueProperty] = t.property
+	}
+	if constraint != nil {
+		ev[errorValueConstraint] = constraint
+	}
+	if t.value != nil {
+		ev[errorValueValue] = t.value
+	}
+	return ev
+}
+
+// propertyNames returns the names of an object's properties in order.
+func propertyNames(obj map[string]interface{}) []string {
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// invalidRequestError returns a CHError for an error which isn't about a particular field of the request body.
+func invalidRequestError(code, reason, location string) models.CHError {
+	return models.CHError{
+		Error:        reason,
+		ErrorCode:    code,
+		ErrorValues:  map[string]interface{}{},
+		Location:     location,
+		LocationType: jsonPath,
+		Type:         chValidationType,
+	}
+}
@@ -0,0 +1,185 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// visitErrors validates value against schema, returning the CHErrors found.
+func visitErrors(schema *openapi3.Schema, value interface{}) []models.CHError {
+	var chErrs []models.CHError
+	var me openapi3.MultiError
+	if err := schema.VisitJSON(value, openapi3.MultiErrors()); errors.As(err, &me) {
+		for _, e := range me {
+			var se *openapi3.SchemaError
+			if errors.As(e, &se) {
+				chErrs = append(chErrs, handleSchemaError(se))
+			}
+		}
+	}
+	return chErrs
+}
+
+// TestUnitSchemaErrorTarget asserts that schema errors are located at, and name, the property at fault.
+func TestUnitSchemaErrorTarget(t *testing.T) {
+
+	Convey("Given an object with an array of objects", t, func() {
+
+		item := openapi3.NewObjectSchema().
+			WithProperty("status", openapi3.NewStringSchema()).
+			WithProperty("company_name", openapi3.NewStringSchema().WithMaxLength(4))
+		item.Required = []string{"status", "company_name"}
+		schema := openapi3.NewObjectSchema().WithProperty("officers", openapi3.NewArraySchema().WithItems(item))
+
+		Convey("When a property of an item is missing, then the missing property is named", func() {
+			errs := visitErrors(schema, map[string]interface{}{"officers": []interface{}{
+				map[string]interface{}{"status": "active", "company_name": "name"},
+				map[string]interface{}{"status": "active"},
+			}})
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Location, ShouldEqual, "officers.1.company_name")
+			So(errs[0].ErrorValues, ShouldResemble, map[string]interface{}{"property": "company_name"})
+		})
+
+		Convey("When a property of an item is invalid, then the property and its own value are given", func() {
+			errs := visitErrors(schema, map[string]interface{}{"officers": []interface{}{
+				map[string]interface{}{"status": "active", "company_name": "too long"},
+			}})
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Location, ShouldEqual, "officers.0.company_name")
+			So(errs[0].ErrorValues, ShouldResemble, map[string]interface{}{"property": "company_name", "constraint": uint64(4), "value": "too long"})
+		})
+
+		Convey("When an item has the wrong type, then it is named after the array", func() {
+			errs := visitErrors(schema, map[string]interface{}{"officers": []interface{}{"officer"}})
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Location, ShouldEqual, "officers.0")
+			So(errs[0].ErrorValues["property"], ShouldEqual, "officers")
+		})
+	})
+
+	Convey("Given an object which doesn't allow additional properties", t, func() {
+
+		schema := openapi3.NewObjectSchema().WithProperty("status", openapi3.NewStringSchema()).WithoutAdditionalProperties()
+		parent := openapi3.NewObjectSchema().WithProperty("identification", schema)
+
+		Convey("When it has unsupported properties, then each is located and named", func() {
+			errs := visitErrors(parent, map[string]interface{}{"identification": map[string]interface{}{
+				"status": "active", "extra": "a", "other": "b",
+			}})
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Location, ShouldEqual, "identification.extra")
+			So(errs[0].ErrorValues, ShouldResemble, map[string]interface{}{"property": "extra", "value": "a"})
+			So(errs[1].Location, ShouldEqual, "identification.other")
+			So(errs[1].ErrorValues, ShouldResemble, map[string]interface{}{"property": "other", "value": "b"})
+		})
+	})
+
+	Convey("Given an object with a maximum number of properties", t, func() {
+
+		schema := openapi3.NewObjectSchema().WithMaxProperties(1)
+		parent := openapi3.NewObjectSchema().WithProperty("identification", schema)
+
+		Convey("When it has too many properties, then the object is named along with its properties", func() {
+			errs := visitErrors(parent, map[string]interface{}{"identification": map[string]interface{}{
+				"non-eea": map[string]interface{}{}, "EEA": map[string]interface{}{},
+			}})
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Location, ShouldEqual, "identification")
+			So(errs[0].ErrorCode, ShouldEqual, ErrorCodeMaxProperties)
+			So(errs[0].ErrorValues, ShouldResemble, map[string]interface{}{
+				"property": "identification", "constraint": uint64(1), "value": []string{"EEA", "non-eea"},
+			})
+		})
+	})
+}
+
+// skeleton returns a request body holding every object and array of objects declared by schema, up to any maximum
+// number of properties, with one item in each array but none of their other properties. Validating it reports every
+// required property which isn't an object or array of objects, at every level of nesting.
+func skeleton(schema *openapi3.Schema, depth int) map[string]interface{} {
+	body := make(map[string]interface{})
+	if depth > 5 {
+		return body
+	}
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if schema.MaxProps != nil && uint64(len(body)) == *schema.MaxProps {
+			break
+		}
+		p := schema.Properties[name].Value
+		switch {
+		case p.Type.Is(openapi3.TypeObject) && len(p.Properties) > 0:
+			body[name] = skeleton(p, depth+1)
+		case p.Type.Is(openapi3.TypeArray) && p.Items != nil && p.Items.Value.Type.Is(openapi3.TypeObject):
+			body[name] = []interface{}{skeleton(p.Items.Value, depth+1)}
+		}
+	}
+	return body
+}
+
+// TestUnitErrorAttributionEverySpec asserts, for the request body of every delta route in every spec, that missing
+// required properties are located at and named after the missing property, however deeply they are nested.
+func TestUnitErrorAttributionEverySpec(t *testing.T) {
+
+	callFilepathAbs = filepath.Abs
+	callFindRoute = findRoute
+	callOpenApiFilterValidateRequest = openapi3filter.ValidateRequest
+	callGetCHErrors = getCHErrors
+
+	// Load the spec once, rather than for every route.
+	chv, err := NewCHValidator(apiSpecLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := chv.(*CHValidatorImpl).spec.Load().doc
+
+	Convey("Given the request body schema of every delta route", t, func() {
+
+		for _, route := range chv.GetDeltaRoutes() {
+			op := doc.Paths.Find(route.Path).Post
+			schema := op.RequestBody.Value.Content.Get("application/json").Schema.Value
+
+			Convey(fmt.Sprintf("When a skeleton request body is sent to %s", route.Path), func() {
+
+				body, _ := json.Marshal(skeleton(schema, 0))
+				req := httptest.NewRequest("POST", route.Path, bytes.NewBuffer(body))
+				req.Header.Set("Content-Type", "application/json")
+
+				valErrs, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+				So(err, ShouldBeNil)
+
+				var chErrs []models.CHError
+				So(json.Unmarshal(valErrs, &chErrs), ShouldBeNil)
+
+				Convey("Then every missing property is located at and named after itself", func() {
+					So(chErrs, ShouldNotBeEmpty)
+					for _, e := range chErrs {
+						So(e.ErrorCode, ShouldEqual, ErrorCodeRequired)
+
+						property, _ := e.ErrorValues["property"].(string)
+						So(e.Error, ShouldEqual, fmt.Sprintf("property '%s' is missing", property))
+						So(e.Location == property || strings.HasSuffix(e.Location, "."+property), ShouldBeTrue)
+						So(e.ErrorValues, ShouldNotContainKey, "value")
+					}
+				})
+			})
+		}
+	})
+}
@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/helpers"
 	"github.com/companieshouse/chs-delta-api/models"
 	"github.com/companieshouse/chs.go/log"
 	"github.com/getkin/kin-openapi/openapi3"
@@ -23,6 +27,12 @@ const (
 	chValidationType = "ch:validation"
 )
 
+// specValidationOptions are used when validating the OpenAPI spec. Each delta's path item file declares the schemas it
+// references under its own components section, alongside the operations.
+var specValidationOptions = []openapi3.ValidationOption{
+	openapi3.AllowExtraSiblingFields("components"),
+}
+
 // Variables used for unit testing and mocking external functions/methods.
 var (
 	callFilepathAbs                  = filepath.Abs
@@ -33,15 +43,49 @@ var (
 	callGetSchema                    = getSchema
 )
 
+// Errors returned when a request can't be validated because the spec doesn't declare how to validate it.
+var (
+	// ErrRouteNotInSpec is returned when the request's path isn't declared in the spec, e.g. because a reload removed it.
+	ErrRouteNotInSpec = errors.New("route is not declared in the Open API spec")
+	// ErrMethodNotInSpec is returned when the request's path is declared in the spec, but not for its method.
+	ErrMethodNotInSpec = errors.New("method is not declared in the Open API spec")
+	// ErrUnsupportedMediaType is returned when the request body's Content-Type isn't one the spec declares.
+	ErrUnsupportedMediaType = errors.New("content type is not declared in the Open API spec")
+)
+
 // CHValidator defines the interface for the CH Validator.
 type CHValidator interface {
 	ValidateRequestAgainstOpenApiSpec(httpReq *http.Request, contextId string) ([]byte, error)
+	ValidateDelta(httpReq *http.Request, body *helpers.DeltaBody, contextId string) ([]byte, error)
+	GetDeltaRoutes() []DeltaRoute
+	GetSpecVersion() string
+}
+
+// RuleUser is implemented by validators which can check deltas against business rules once they pass schema
+// validation.
+type RuleUser interface {
+	UseRules(rs *RuleSet)
 }
 
 // CHValidatorImpl is a concrete implementation of the CHValidator interface.
 type CHValidatorImpl struct {
-	doc         *openapi3.T
+	spec        atomic.Pointer[specVersion]
+	reloadErr   atomic.Pointer[error]
 	openApiSpec string
+	routes      []DeltaRoute
+	opts        *openapi3filter.Options
+	rules       *RuleSet
+	ruleDeltas  map[string]Delta
+}
+
+// specVersion is a loaded version of the OpenAPI spec. A version is never modified once loaded, so requests which
+// started validating against it are unaffected when a reload swaps in a new one.
+type specVersion struct {
+	doc      *openapi3.T
+	router   routers.Router
+	hash     string
+	files    []string
+	modTimes map[string]time.Time
 }
 
 // NewCHValidator creates a new CHValidator instance.
@@ -49,67 +93,275 @@ type CHValidatorImpl struct {
 func NewCHValidator(openApiSpec string) (CHValidator, error) {
 
 	ctx := context.Background()
-	doc, err := callGetSchema(ctx, openApiSpec)
+	spec, err := callGetSchema(ctx, openApiSpec)
 	if err != nil {
 		// Failed to retrieve the schema, so return an error.
 		return nil, err
 	}
 
+	// Read the delta routes declared in the spec, failing fast if any of them are incomplete.
+	routes, err := callLoadDeltaRoutes(openApiSpec)
+	if err != nil {
+		return nil, err
+	}
+
 	// Successfully created a CHValidator, so return the fully constructed object.
-	return &CHValidatorImpl{
-		doc:         doc,
+	chv := &CHValidatorImpl{
 		openApiSpec: openApiSpec,
-	}, nil
+		routes:      routes,
+		opts:        newValidationOptions(),
+	}
+	chv.spec.Store(spec)
+	log.Info("Activated Open API spec", log.Data{config.OpenApiSpecKey: openApiSpec, config.SpecHashKey: spec.hash})
+
+	return chv, nil
+}
+
+// UseRules checks deltas against the given business rules once they pass schema validation. It must be called before
+// any requests are validated.
+func (chv *CHValidatorImpl) UseRules(rs *RuleSet) {
+	chv.ruleDeltas = make(map[string]Delta, len(chv.routes))
+	for _, route := range chv.routes {
+		d, ok := config.FindDelta(route.Path)
+		if !ok {
+			continue
+		}
+		chv.ruleDeltas[route.Path] = Delta{Type: d.Name, Action: route.Action}
+	}
+	chv.rules = rs
+}
+
+// GetDeltaRoutes returns the delta routes declared in the OpenAPI specification.
+func (chv *CHValidatorImpl) GetDeltaRoutes() []DeltaRoute {
+	return chv.routes
+}
+
+// GetSpecVersion returns the hash identifying the currently active version of the OpenAPI specification.
+func (chv *CHValidatorImpl) GetSpecVersion() string {
+	return chv.spec.Load().hash
 }
 
 // ValidateRequestAgainstOpenApiSpec validates the HTTP request against the provided OpenAPI specification.
-// If errors are found, they are formatted and returned as JSON.
+// If errors are found, they are formatted and returned as JSON. The request body is read, and left to be read again
+// with any default values set by validation.
 func (chv *CHValidatorImpl) ValidateRequestAgainstOpenApiSpec(httpReq *http.Request, contextId string) ([]byte, error) {
 
+	var data []byte
+	if httpReq.Body != nil {
+		var err error
+		if data, err = io.ReadAll(httpReq.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	body := helpers.NewDeltaBody(string(data))
+	valErrs, err := chv.ValidateDelta(httpReq, body, contextId)
+	httpReq.Body = io.NopCloser(strings.NewReader(body.String()))
+
+	return valErrs, err
+}
+
+// ValidateDelta validates a delta request, whose body has already been read, against the provided OpenAPI
+// specification. If errors are found, they are formatted and returned as JSON. The body is decoded once, and the same
+// decoded value is validated, checked against business rules and used to extract primary ids. It is encoded again if
+// validation sets any default values.
+func (chv *CHValidatorImpl) ValidateDelta(httpReq *http.Request, body *helpers.DeltaBody, contextId string) ([]byte, error) {
+
 	ctx := context.Background()
 
-	// Initialize the router to later retrieve the matching routes.
-	r, err := callNewRouter(chv.doc)
-	if err != nil {
-		log.ErrorC(contextId, err, log.Data{config.MessageKey: "error occurred while initialising router for validation"})
-		return nil, err
-	}
+	// Take the current version of the spec once so a concurrent reload doesn't affect this request.
+	spec := chv.spec.Load()
 
-	// Find the route matching the given HTTP request.
-	route, pathParams, err := callFindRoute(r, httpReq)
+	// Find the route matching the given HTTP request using the router built when the spec was loaded.
+	route, pathParams, err := callFindRoute(spec.router, httpReq)
 	if err != nil {
 		log.ErrorC(contextId, err, log.Data{config.MessageKey: "error occurred while finding routes for given http request"})
+		switch {
+		case errors.Is(err, routers.ErrPathNotFound):
+			return nil, fmt.Errorf("%w: %s", ErrRouteNotInSpec, httpReq.URL.Path)
+		case errors.Is(err, routers.ErrMethodNotAllowed):
+			return nil, fmt.Errorf("%w: %s %s", ErrMethodNotInSpec, httpReq.Method, httpReq.URL.Path)
+		}
 		return nil, err
 	}
 
-	// Define options for the request validator. Enable MultiError so that all found errors are returned.
-	opts := &openapi3filter.Options{
-		MultiError:         true,
-		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc, // No-op as external middleware handles security.
+	// A body which isn't of a type the spec declares can't be validated, which is the caller's fault rather than the
+	// delta's.
+	if contentType := httpReq.Header.Get("Content-Type"); body.Len() != 0 && !declaresMediaType(route, contentType) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedMediaType, contentType)
 	}
 
+	// kin-openapi's security check reads the body too, even though authentication is left to middleware, so it is given
+	// a copy of the request without one.
+	bodyless := httpReq.WithContext(httpReq.Context())
+	bodyless.Body, bodyless.GetBody = http.NoBody, nil
+
 	requestValidationInput := &openapi3filter.RequestValidationInput{
-		Request:    httpReq,
+		Request:    bodyless,
 		PathParams: pathParams,
 		Route:      route,
-		Options:    opts,
+		Options:    chv.opts,
 	}
 
-	// Disable the addition of schema error details to the returned error to prevent the OpenAPI spec from being exposed.
-	openapi3.SchemaErrorDetailsDisabled = true
+	log.InfoC(contextId, "Validating request using: ", log.Data{config.OpenApiSpecKey: chv.openApiSpec, config.SpecHashKey: spec.hash})
 
-	log.InfoC(contextId, "Validating request using: ", log.Data{config.OpenApiSpecKey: chv.openApiSpec})
-	if err := callOpenApiFilterValidateRequest(ctx, requestValidationInput); err != nil {
-		// Validation errors found: format and return them.
+	// kin-openapi validates everything but the body, which it would otherwise read and decode again. As MultiError is
+	// set, its errors are a MultiError, which errors found in the body are added to.
+	var valErrs openapi3.MultiError
+	if err := callOpenApiFilterValidateRequest(ctx, requestValidationInput); err != nil && !errors.As(err, &valErrs) {
 		log.InfoC(contextId, "Request validated. Errors found.", nil)
 		return callGetCHErrors(contextId, err), nil
 	}
+	if err := validateBody(requestValidationInput, body); err != nil {
+		valErrs = append(valErrs, err)
+	}
+	if len(valErrs) > 0 {
+		// Validation errors found: format and return them.
+		log.InfoC(contextId, "Request validated. Errors found.", nil)
+		return callGetCHErrors(contextId, valErrs), nil
+	}
+
+	// Only deltas which match the spec are checked against business rules.
+	if violations, err := chv.checkRules(body, route.Path, contextId); err != nil || violations != nil {
+		return violations, err
+	}
 
 	// If no errors were found, return nil.
 	log.InfoC(contextId, "Request validated. No errors were found.", nil)
 	return nil, nil
 }
 
+// validateBody validates the decoded delta body against the request body schema of its route, returning errors in the
+// same form as kin-openapi's ValidateRequestBody. The media type has already been checked, and every delta body is
+// JSON. If validation sets any default values, the body is encoded again with them so they are published.
+func validateBody(input *openapi3filter.RequestValidationInput, body *helpers.DeltaBody) error {
+
+	op := input.Route.Operation
+	if op == nil || op.RequestBody == nil || op.RequestBody.Value == nil {
+		return nil
+	}
+	requestBody := op.RequestBody.Value
+
+	if body.Len() == 0 {
+		if requestBody.Required {
+			return &openapi3filter.RequestError{Input: input, RequestBody: requestBody, Err: openapi3filter.ErrInvalidRequired}
+		}
+		return nil
+	}
+
+	mediaType := requestBody.Content.Get(input.Request.Header.Get("Content-Type"))
+	if mediaType == nil || mediaType.Schema == nil {
+		return nil
+	}
+
+	value, err := body.Decode()
+	if err != nil {
+		return &openapi3filter.RequestError{
+			Input:       input,
+			RequestBody: requestBody,
+			Reason:      "failed to decode request body",
+			Err:         &openapi3filter.ParseError{Kind: openapi3filter.KindInvalidFormat, Cause: err},
+		}
+	}
+
+	defaultsSet := false
+	if err := mediaType.Schema.Value.VisitJSON(value, schemaValidationOptions(func() { defaultsSet = true })...); err != nil {
+		schemaId := mediaType.Schema.Ref
+		if schemaId == "" {
+			schemaId = mediaType.Schema.Value.Title
+		}
+		return &openapi3filter.RequestError{
+			Input:       input,
+			RequestBody: requestBody,
+			Reason:      strings.TrimSpace("doesn't match schema " + schemaId),
+			Err:         err,
+		}
+	}
+
+	if defaultsSet {
+		if err := body.Encode(); err != nil {
+			return &openapi3filter.RequestError{Input: input, RequestBody: requestBody, Reason: "rewriting failed", Err: err}
+		}
+	}
+
+	return nil
+}
+
+// checkRules checks the decoded body against the business rules of the delta sent to the spec path, returning the
+// violations as JSON, or nil if there are none.
+func (chv *CHValidatorImpl) checkRules(body *helpers.DeltaBody, path, contextId string) ([]byte, error) {
+
+	d, ok := chv.ruleDeltas[path]
+	if chv.rules == nil || !ok {
+		return nil, nil
+	}
+
+	var err error
+	if d.Body, err = body.Decode(); err != nil {
+		return nil, err
+	}
+
+	violations := chv.rules.Check(d)
+	if len(violations) == 0 {
+		return nil, nil
+	}
+
+	log.InfoC(contextId, "Request validated. Business rules broken.", log.Data{config.DeltaTypeKey: d.Type})
+	return marshalCHErrors(contextId, violations), nil
+}
+
+// newValidationOptions returns the options used by the request validator. The options are only read by kin-openapi, so
+// a single instance is shared by all requests.
+func newValidationOptions() *openapi3filter.Options {
+
+	// Enable MultiError so that all found errors are returned. The body is validated by validateBody, from the value
+	// already decoded.
+	opts := &openapi3filter.Options{
+		MultiError:         true,
+		ExcludeRequestBody: true,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc, // No-op as external middleware handles security.
+	}
+
+	// Leave schema error details out of error messages to prevent the OpenAPI spec from being exposed.
+	opts.WithCustomSchemaErrorFunc(schemaErrorMessage)
+
+	return opts
+}
+
+// schemaValidationOptions returns the options used to validate a delta body against its schema, matching those
+// kin-openapi derives from the request validator's options. defaultsSet is called if any default values are set.
+func schemaValidationOptions(defaultsSet func()) []openapi3.SchemaValidationOption {
+	return []openapi3.SchemaValidationOption{
+		openapi3.VisitAsRequest(),
+		openapi3.MultiErrors(),
+		openapi3.SetSchemaErrorMessageCustomizer(schemaErrorMessage),
+		openapi3.DefaultsSet(defaultsSet),
+	}
+}
+
+// schemaErrorMessage formats a SchemaError in the same way as kin-openapi, but without appending the schema and value
+// which failed validation.
+func schemaErrorMessage(se *openapi3.SchemaError) string {
+
+	// Errors wrapping another error are already formatted without details, so use the default message.
+	if se.Origin != nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	if pointer := se.JSONPointer(); len(pointer) > 0 {
+		sb.WriteString(fmt.Sprintf("Error at \"/%s\": ", strings.Join(pointer, "/")))
+	}
+
+	if se.Reason == "" {
+		sb.WriteString(fmt.Sprintf("Doesn't match schema \"%s\"", se.SchemaField))
+	} else {
+		sb.WriteString(se.Reason)
+	}
+
+	return sb.String()
+}
+
 // getCHErrors formats the validation errors into JSON using CHError.
 func getCHErrors(contextId string, err error) []byte {
 
@@ -122,15 +374,15 @@ func getCHErrors(contextId string, err error) []byte {
 		errorsArr = handleMultiError(contextId, &mea, errorsArr)
 	} else {
 		// Fallback for non-MultiError errors: add a generic validation error.
-		errorsArr = append(errorsArr, models.CHError{
-			Error:        err.Error(),
-			ErrorValues:  nil,
-			Location:     "request-body",
-			LocationType: jsonPath,
-			Type:         chValidationType,
-		})
+		errorsArr = append(errorsArr, invalidRequestError(ErrorCodeInvalid, err.Error(), "request-body"))
 	}
 
+	return marshalCHErrors(contextId, errorsArr)
+}
+
+// marshalCHErrors logs and formats an array of CHError as JSON.
+func marshalCHErrors(contextId string, errorsArr []models.CHError) []byte {
+
 	// Log all errors for debugging purposes.
 	var errSB strings.Builder
 	for _, e := range errorsArr {
@@ -174,13 +426,7 @@ func handleMultiError(contextId string, mea *openapi3.MultiError, errsArray []mo
 		}
 
 		// Fallback for unexpected error types.
-		errsArray = append(errsArray, models.CHError{
-			Error:        e.Error(),
-			ErrorValues:  nil,
-			Location:     "unknown",
-			LocationType: jsonPath,
-			Type:         chValidationType,
-		})
+		errsArray = append(errsArray, invalidRequestError(ErrorCodeInvalid, e.Error(), "unknown"))
 	}
 
 	return errsArray
@@ -211,24 +457,12 @@ func handleRequestError(contextId string, re *openapi3filter.RequestError, errsA
 
 	// If a required field is missing.
 	if errors.Is(re.Err, openapi3filter.ErrInvalidRequired) {
-		errsArray = append(errsArray, models.CHError{
-			Error:        re.Err.Error(),
-			ErrorValues:  nil,
-			Location:     "request-body",
-			LocationType: jsonPath,
-			Type:         chValidationType,
-		})
+		errsArray = append(errsArray, invalidRequestError(ErrorCodeRequired, re.Err.Error(), "request-body"))
 		return errsArray
 	}
 
 	// Fallback – append a generic error.
-	errsArray = append(errsArray, models.CHError{
-		Error:        re.Err.Error(),
-		ErrorValues:  nil,
-		Location:     "request-body",
-		LocationType: jsonPath,
-		Type:         chValidationType,
-	})
+	errsArray = append(errsArray, invalidRequestError(ErrorCodeInvalid, re.Err.Error(), "request-body"))
 	return errsArray
 }
 
@@ -238,30 +472,14 @@ func handleSchemaError(se *openapi3.SchemaError) models.CHError {
 	// Replace double quotes in the error reason with single quotes.
 	reason := strings.Replace(se.Reason, "\"", "'", -1)
 
-	// Get the error path from JSON pointer; if empty, use default values.
-	pointerParts := se.JSONPointer()
-	path := ""
-	fieldName := ""
-	if len(pointerParts) > 0 {
-		path = strings.Join(pointerParts, ".")
-		fieldName = pointerParts[len(pointerParts)-1]
-	} else {
-		path = "request-body"
-		fieldName = "unknown"
-	}
-
-	// Handle special case for a required error.
-	fieldValue := ""
-	if se.SchemaField == "required" {
-		fieldValue = ""
-	} else {
-		fieldValue = fmt.Sprintf("%v", se.Value)
-	}
+	// Find the property at fault, which isn't always the one kin-openapi located the error at.
+	target := schemaErrorTarget(se)
 
 	return models.CHError{
 		Error:        reason,
-		ErrorValues:  map[string]interface{}{fieldName: fieldValue},
-		Location:     path,
+		ErrorCode:    schemaErrorCode(se),
+		ErrorValues:  target.errorValues(schemaConstraint(se)),
+		Location:     target.location(),
 		LocationType: jsonPath,
 		Type:         chValidationType,
 	}
@@ -270,35 +488,35 @@ func handleSchemaError(se *openapi3.SchemaError) models.CHError {
 // handleParseError processes a ParseError (e.g., malformed JSON) and returns a formatted CHError.
 func handleParseError(pe *openapi3filter.ParseError) models.CHError {
 
-	return models.CHError{
-		Error:        pe.Cause.Error(),
-		ErrorValues:  map[string]interface{}{},
-		Location:     "request-body",
-		LocationType: jsonPath,
-		Type:         chValidationType,
-	}
+	return invalidRequestError(ErrorCodeMalformedJSON, pe.Cause.Error(), "request-body")
 }
 
 // getSchema retrieves and validates the OpenAPI3 specification.
-func getSchema(ctx context.Context, openApiSpec string) (*openapi3.T, error) {
+func getSchema(ctx context.Context, openApiSpec string) (*specVersion, error) {
 
 	log.Info("Retrieving openAPI3 spec")
-	doc, err := loadSchemaFromFile(ctx, openApiSpec)
+	spec, err := loadSchemaFromFile(ctx, openApiSpec)
 	if err != nil {
 		log.Error(err, log.Data{config.OpenApiSpecKey: openApiSpec, config.MessageKey: "unable to open Open API spec"})
 	} else {
-		if errValidate := doc.Validate(ctx); errValidate != nil {
+		if errValidate := spec.doc.Validate(ctx, specValidationOptions...); errValidate != nil {
 			log.Error(errValidate, log.Data{config.MessageKey: "error occurred while validating the Open API spec"})
 		}
 	}
 
-	return doc, err
+	return spec, err
 }
 
-// loadSchemaFromFile loads the OpenAPI3 schema from the filesystem using an absolute path.
-func loadSchemaFromFile(ctx context.Context, openApiSpec string) (*openapi3.T, error) {
+// loadSchemaFromFile loads the OpenAPI3 schema from the filesystem using an absolute path. Every file read while
+// resolving references is recorded so that the spec can be hashed and watched for changes.
+func loadSchemaFromFile(ctx context.Context, openApiSpec string) (*specVersion, error) {
 
-	loader := &openapi3.Loader{Context: ctx, IsExternalRefsAllowed: true}
+	sr := &specReader{files: make(map[string][]byte)}
+	loader := &openapi3.Loader{
+		Context:               ctx,
+		IsExternalRefsAllowed: true,
+		ReadFromURIFunc:       openapi3.URIMapCache(sr.read),
+	}
 	abs, err := callFilepathAbs(openApiSpec)
 	if err != nil {
 		log.Error(err, log.Data{config.MessageKey: "error occurred while retrieving absolute path of validation schema file"})
@@ -306,7 +524,30 @@ func loadSchemaFromFile(ctx context.Context, openApiSpec string) (*openapi3.T, e
 	}
 	log.Info(fmt.Sprintf("Retrieved absolute path of validation schema"), log.Data{config.SchemaAbsolutePathKey: abs})
 
-	return loader.LoadFromFile(abs)
+	doc, err := loader.LoadFromFile(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the router once per version of the spec. It is only read from while finding routes, so it is safe to
+	// share across concurrent requests.
+	r, err := callNewRouter(doc)
+	if err != nil {
+		log.Error(err, log.Data{config.MessageKey: "error occurred while initialising router for validation"})
+		return nil, err
+	}
+
+	hash, files := sr.summary()
+	return &specVersion{doc: doc, router: r, hash: hash, files: files, modTimes: getModTimes(files)}, nil
+}
+
+// declaresMediaType reports whether the route's operation declares a request body of the given Content-Type. Routes
+// without a request body accept any.
+func declaresMediaType(route *routers.Route, contentType string) bool {
+	if route.Operation == nil || route.Operation.RequestBody == nil || route.Operation.RequestBody.Value == nil {
+		return true
+	}
+	return route.Operation.RequestBody.Value.Content.Get(contentType) != nil
 }
 
 // findRoute provides an abstraction layer to allow for easier unit testing.
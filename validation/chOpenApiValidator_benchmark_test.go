@@ -0,0 +1,209 @@
+package validation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/companieshouse/chs-delta-api/helpers"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	router "github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+const (
+	officersRequestBodyLocation = "schema_testing/officers/request_bodies/ok_request_body"
+	officersPrimaryId           = "$.officers[*].internal_id"
+	largeOfficersCount          = 200
+)
+
+// newBenchmarkValidator returns a validator loaded from the real spec, undoing any stubs left behind by unit tests.
+func newBenchmarkValidator(b *testing.B) *CHValidatorImpl {
+	callFilepathAbs = filepath.Abs
+	callNewRouter = router.NewRouter
+	callFindRoute = findRoute
+	callOpenApiFilterValidateRequest = openapi3filter.ValidateRequest
+	callGetCHErrors = getCHErrors
+
+	chv, err := NewCHValidator(apiSpecLocation)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return chv.(*CHValidatorImpl)
+}
+
+// newOfficersRequest returns a valid officers delta request.
+func newOfficersRequest(body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/delta/officers", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// BenchmarkValidateRequestRebuildingRouter measures validating a request when the router is rebuilt for every
+// request, as the validator previously did.
+func BenchmarkValidateRequestRebuildingRouter(b *testing.B) {
+	chv := newBenchmarkValidator(b)
+	body, _ := os.ReadFile(officersRequestBodyLocation)
+	doc := chv.spec.Load().doc
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := newOfficersRequest(body)
+
+		r, err := router.NewRouter(doc)
+		if err != nil {
+			b.Fatal(err)
+		}
+		route, pathParams, err := r.FindRoute(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = openapi3filter.ValidateRequest(context.Background(), &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkValidateRequest measures validating a request using the router built when the spec was loaded.
+func BenchmarkValidateRequest(b *testing.B) {
+	chv := newBenchmarkValidator(b)
+	body, _ := os.ReadFile(officersRequestBodyLocation)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		valErrs, err := chv.ValidateRequestAgainstOpenApiSpec(newOfficersRequest(body), contextId)
+		if err != nil || valErrs != nil {
+			b.Fatalf("unexpected validation result: %s, %v", valErrs, err)
+		}
+	}
+}
+
+// BenchmarkValidateRequestParallel measures validating requests concurrently using the shared router.
+func BenchmarkValidateRequestParallel(b *testing.B) {
+	chv := newBenchmarkValidator(b)
+	body, _ := os.ReadFile(officersRequestBodyLocation)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := chv.ValidateRequestAgainstOpenApiSpec(newOfficersRequest(body), contextId); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// newLargeOfficersBody returns a valid officers delta holding largeOfficersCount officers, which passes both schema
+// validation and the business rules.
+func newLargeOfficersBody(b *testing.B) []byte {
+	data, err := os.ReadFile(officersRequestBodyLocation)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var delta map[string]interface{}
+	if err := json.Unmarshal(data, &delta); err != nil {
+		b.Fatal(err)
+	}
+	officer := delta["officers"].([]interface{})[0]
+	officers := make([]interface{}, largeOfficersCount)
+	for i := range officers {
+		officers[i] = officer
+	}
+	delta["officers"] = officers
+	delta["delta_at"] = "20240101120000000000"
+
+	body, err := json.Marshal(delta)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return body
+}
+
+// BenchmarkHandleLargeDeltaBaseline measures handling a large delta as the baseline service did: the router is built
+// and kin-openapi reads and decodes the body to validate it, then the handler reads the body again and matches the
+// primary id with a regular expression compiled for the request.
+func BenchmarkHandleLargeDeltaBaseline(b *testing.B) {
+	chv := newBenchmarkValidator(b)
+	doc := chv.spec.Load().doc
+	body := newLargeOfficersBody(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := newOfficersRequest(body)
+
+		r, err := router.NewRouter(doc)
+		if err != nil {
+			b.Fatal(err)
+		}
+		route, pathParams, err := r.FindRoute(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = openapi3filter.ValidateRequest(context.Background(), &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			b.Fatal(err)
+		}
+		regex := regexp.MustCompile(fmt.Sprintf("(?m)%s\"\\s*:\\s*\"([a-zA-Z0-9_-]+)\"", "internal_id"))
+		if !regex.MatchString(string(data)) {
+			b.Fatal("failed to match regex")
+		}
+	}
+}
+
+// BenchmarkHandleLargeDelta measures handling a large delta now that the body is read and decoded once, and the
+// decoded value is validated and used to extract its primary ids. Business rules aren't checked, as the baseline had
+// none.
+func BenchmarkHandleLargeDelta(b *testing.B) {
+	chv := newBenchmarkValidator(b)
+	body := newLargeOfficersBody(b)
+	primaryId, _ := helpers.ParseIdPath(officersPrimaryId)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := newOfficersRequest(body)
+
+		data, _ := io.ReadAll(req.Body)
+		delta := helpers.NewDeltaBody(string(data))
+		valErrs, err := chv.ValidateDelta(req, delta, contextId)
+		if err != nil || valErrs != nil {
+			b.Fatalf("unexpected validation result: %s, %v", valErrs, err)
+		}
+		if _, err := primaryId.ExtractBody(delta); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"github.com/companieshouse/chs-delta-api/helpers"
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/getkin/kin-openapi/openapi3filter"
 	"github.com/getkin/kin-openapi/routers"
 	router "github.com/getkin/kin-openapi/routers/gorillamux"
 	. "github.com/smartystreets/goconvey/convey"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -29,6 +35,11 @@ func TestUnitNewCHValidator(t *testing.T) {
 			So(chv, ShouldNotBeNil)
 			So(err, ShouldBeNil)
 		})
+
+		Convey("Then the spec version is the hash of the active spec", func() {
+			So(chv.GetSpecVersion(), ShouldEqual, chv.(*CHValidatorImpl).spec.Load().hash)
+			So(chv.GetSpecVersion(), ShouldHaveLength, 64)
+		})
 	})
 }
 
@@ -73,27 +84,24 @@ func TestUnitValidateRequestAgainstOpenApiSpecFailsFileOpen(t *testing.T) {
 	})
 }
 
-// TestUnitValidateRequestAgainstOpenApiSpecFailsToCreateRouter asserts that all errors are handled correctly when
-// creating of the Gorilla MUX router fails.
-func TestUnitValidateRequestAgainstOpenApiSpecFailsToCreateRouter(t *testing.T) {
-	Convey("When I call to validate a request", t, func() {
+// TestUnitNewCHValidatorFailsToCreateRouter asserts that all errors are handled correctly when creating of the
+// Gorilla MUX router fails.
+func TestUnitNewCHValidatorFailsToCreateRouter(t *testing.T) {
+	Convey("When I call to get a new CHValidator", t, func() {
 
 		callFilepathAbs = func(path string) (string, error) {
 			return apiSpecLocation, nil
 		}
 
-		chv, _ := NewCHValidator(apiSpecLocation)
-
-		req := httptest.NewRequest("POST", "/dummy/target", bytes.NewBuffer([]byte(requestBody)))
-
 		callNewRouter = func(doc *openapi3.T) (routers.Router, error) {
 			return nil, errors.New("error creating router")
 		}
+		defer func() { callNewRouter = router.NewRouter }()
 
-		valErrs, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+		chv, err := NewCHValidator(apiSpecLocation)
 
-		Convey("Then the failure to get the ABS path to spec is handled correctly", func() {
-			So(valErrs, ShouldBeNil)
+		Convey("Then the failure to create the router is handled correctly", func() {
+			So(chv, ShouldBeNil)
 			So(err, ShouldNotBeNil)
 		})
 	})
@@ -111,8 +119,6 @@ func TestUnitValidateRequestAgainstOpenApiSpecNoErrors(t *testing.T) {
 
 		req := httptest.NewRequest("POST", "/dummy/target", bytes.NewBuffer([]byte(requestBody)))
 
-		callNewRouter = router.NewRouter
-
 		callFindRoute = func(r routers.Router, req *http.Request) (route *routers.Route, pathParams map[string]string, err error) {
 			return &routers.Route{}, make(map[string]string, 1), nil
 		}
@@ -143,8 +149,6 @@ func TestUnitValidateRequestAgainstOpenApiSpecFindsValErrors(t *testing.T) {
 
 		req := httptest.NewRequest("POST", "/dummy/delta", bytes.NewBuffer([]byte(requestBody)))
 
-		callNewRouter = router.NewRouter
-
 		callFindRoute = func(r routers.Router, req *http.Request) (route *routers.Route, pathParams map[string]string, err error) {
 			return &routers.Route{}, make(map[string]string, 1), nil
 		}
@@ -165,3 +169,266 @@ func TestUnitValidateRequestAgainstOpenApiSpecFindsValErrors(t *testing.T) {
 		})
 	})
 }
+
+// TestUnitValidateRequestDoesNotExposeSchema asserts that the validation options leave schema details out of error
+// messages, without changing kin-openapi's package level settings.
+func TestUnitValidateRequestDoesNotExposeSchema(t *testing.T) {
+
+	Convey("Given a request body which fails validation against the spec", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		chv, _ := NewCHValidator(apiSpecLocation)
+
+		data, _ := os.ReadFile("schema_testing/officers/request_bodies/type_error_request_body")
+		req := httptest.NewRequest("POST", "/delta/officers", nil)
+		req.Header.Set("Content-Type", "application/json")
+		route, _, err := findRoute(chv.(*CHValidatorImpl).spec.Load().router, req)
+		So(err, ShouldBeNil)
+		schema := route.Operation.RequestBody.Value.Content.Get("application/json").Schema.Value
+
+		Convey("When it is validated with the default options, then the error messages contain the schema", func() {
+			value, _ := helpers.NewDeltaBody(string(data)).Decode()
+			err := schema.VisitJSON(value, openapi3.VisitAsRequest(), openapi3.MultiErrors())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Schema:")
+		})
+
+		Convey("When it is validated with the validator's options, then the error messages contain no schema details", func() {
+			err := validateBody(&openapi3filter.RequestValidationInput{Request: req, Route: route}, helpers.NewDeltaBody(string(data)))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "Schema:")
+			So(openapi3.SchemaErrorDetailsDisabled, ShouldBeFalse)
+		})
+	})
+}
+
+// TestUnitSchemaErrorMessage asserts that schema errors are formatted without the schema and value.
+func TestUnitSchemaErrorMessage(t *testing.T) {
+
+	Convey("When I format a schema error", t, func() {
+
+		se := &openapi3.SchemaError{
+			Value:       "value",
+			Schema:      openapi3.NewStringSchema(),
+			SchemaField: "maxLength",
+			Reason:      "maximum string length is 2",
+		}
+
+		Convey("Then only the reason is included", func() {
+			So(schemaErrorMessage(se), ShouldEqual, "maximum string length is 2")
+		})
+
+		Convey("Then a missing reason names the schema field", func() {
+			se.Reason = ""
+			So(schemaErrorMessage(se), ShouldEqual, `Doesn't match schema "maxLength"`)
+		})
+
+		Convey("Then errors wrapping another error use the default message", func() {
+			se.Origin = errors.New("origin")
+			So(schemaErrorMessage(se), ShouldEqual, "")
+		})
+	})
+}
+
+// TestUnitValidateRequestConcurrently hammers a single validator from many goroutines, while the spec is reloaded, to
+// assert that validation is safe for concurrent use. Run with -race.
+func TestUnitValidateRequestConcurrently(t *testing.T) {
+
+	Convey("Given a validator shared by many goroutines", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		callFindRoute = findRoute
+		callOpenApiFilterValidateRequest = openapi3filter.ValidateRequest
+		callGetCHErrors = getCHErrors
+
+		chv, _ := NewCHValidator(apiSpecLocation)
+		okBody, _ := os.ReadFile("schema_testing/officers/request_bodies/ok_request_body")
+		badBody, _ := os.ReadFile("schema_testing/officers/request_bodies/type_error_request_body")
+
+		const goroutines = 32
+		const requests = 20
+
+		var wg sync.WaitGroup
+		var failures atomic.Int32
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < requests; i++ {
+					body, wantErrs := okBody, false
+					if (g+i)%2 == 0 {
+						body, wantErrs = badBody, true
+					}
+					req := httptest.NewRequest("POST", "/delta/officers", bytes.NewBuffer(body))
+					req.Header.Set("Content-Type", "application/json")
+
+					valErrs, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+					if err != nil || (valErrs != nil) != wantErrs {
+						failures.Add(1)
+					}
+				}
+			}(g)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				_ = chv.(*CHValidatorImpl).Reload(context.Background())
+			}
+		}()
+
+		wg.Wait()
+
+		Convey("Then every request is validated correctly", func() {
+			So(failures.Load(), ShouldEqual, 0)
+		})
+	})
+}
+
+// TestUnitValidateRequestNotInSpec asserts that requests the spec doesn't declare how to validate return errors which
+// say why, rather than validation errors.
+func TestUnitValidateRequestNotInSpec(t *testing.T) {
+
+	Convey("Given a validator", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		callFindRoute = findRoute
+		callOpenApiFilterValidateRequest = openapi3filter.ValidateRequest
+		callGetCHErrors = getCHErrors
+
+		chv, _ := NewCHValidator(apiSpecLocation)
+		body, _ := os.ReadFile("schema_testing/officers/request_bodies/ok_request_body")
+
+		Convey("When the path isn't declared in the spec, then ErrRouteNotInSpec is returned", func() {
+			req := httptest.NewRequest("POST", "/delta/unknown", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			valErrs, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+			So(valErrs, ShouldBeNil)
+			So(errors.Is(err, ErrRouteNotInSpec), ShouldBeTrue)
+		})
+
+		Convey("When the method isn't declared in the spec, then ErrMethodNotInSpec is returned", func() {
+			req := httptest.NewRequest("PUT", "/delta/officers", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			_, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+			So(errors.Is(err, ErrMethodNotInSpec), ShouldBeTrue)
+		})
+
+		Convey("When the body isn't JSON, then ErrUnsupportedMediaType is returned", func() {
+			req := httptest.NewRequest("POST", "/delta/officers", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "text/plain")
+			_, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+			So(errors.Is(err, ErrUnsupportedMediaType), ShouldBeTrue)
+		})
+
+		Convey("When the body is JSON with parameters, then it is validated", func() {
+			req := httptest.NewRequest("POST", "/delta/officers", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			valErrs, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+			So(err, ShouldBeNil)
+			So(valErrs, ShouldBeNil)
+		})
+	})
+}
+
+// TestUnitValidateBody asserts that delta bodies are encoded again when validation sets default values, and published
+// as they were sent otherwise.
+func TestUnitValidateBody(t *testing.T) {
+
+	Convey("Given a route whose request body has a default value", t, func() {
+
+		schema := openapi3.NewObjectSchema().
+			WithProperty("status", openapi3.NewStringSchema()).
+			WithProperty("kind", openapi3.NewStringSchema().WithDefault("officer"))
+		requestBody := openapi3.NewRequestBody().WithRequired(true).WithJSONSchema(schema)
+		route := &routers.Route{Operation: &openapi3.Operation{RequestBody: &openapi3.RequestBodyRef{Value: requestBody}}}
+
+		validate := func(body *helpers.DeltaBody) error {
+			req := httptest.NewRequest("POST", "/delta/test", nil)
+			req.Header.Set("Content-Type", "application/json")
+			return validateBody(&openapi3filter.RequestValidationInput{Request: req, Route: route}, body)
+		}
+
+		Convey("When the default is missing, then it is set in the decoded body and the body to be published", func() {
+			body := helpers.NewDeltaBody(`{"status":"active"}`)
+			So(validate(body), ShouldBeNil)
+			So(body.String(), ShouldEqual, `{"kind":"officer","status":"active"}`)
+			value, _ := body.Decode()
+			So(value, ShouldResemble, map[string]interface{}{"kind": "officer", "status": "active"})
+		})
+
+		Convey("When nothing needs setting, then the body is published as it was sent", func() {
+			body := helpers.NewDeltaBody(`{ "status": "active", "kind": "corporate" }`)
+			So(validate(body), ShouldBeNil)
+			So(body.String(), ShouldEqual, `{ "status": "active", "kind": "corporate" }`)
+		})
+
+		Convey("When the body isn't valid JSON, then a parse error is returned", func() {
+			var pe *openapi3filter.ParseError
+			So(errors.As(validate(helpers.NewDeltaBody(`{"status":`)), &pe), ShouldBeTrue)
+		})
+
+		Convey("When the body is empty, then it is reported as missing", func() {
+			So(errors.Is(validate(helpers.NewDeltaBody("")), openapi3filter.ErrInvalidRequired), ShouldBeTrue)
+		})
+	})
+}
+
+// failingReader fails every read, so tests can assert a body isn't read.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("body read again")
+}
+
+// TestUnitValidateDeltaDoesNotReadBodyAgain asserts that a delta whose body has already been read is validated
+// without reading the request body again.
+func TestUnitValidateDeltaDoesNotReadBodyAgain(t *testing.T) {
+
+	Convey("Given a valid delta whose request body can't be read again", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		callFindRoute = findRoute
+		callOpenApiFilterValidateRequest = openapi3filter.ValidateRequest
+		callGetCHErrors = getCHErrors
+
+		chv, _ := NewCHValidator(apiSpecLocation)
+		data, _ := os.ReadFile("schema_testing/officers/request_bodies/ok_request_body")
+		req := httptest.NewRequest("POST", "/delta/officers", failingReader{})
+		req.Header.Set("Content-Type", "application/json")
+
+		Convey("When it is validated, then it passes using the body already read", func() {
+			valErrs, err := chv.ValidateDelta(req, helpers.NewDeltaBody(string(data)), contextId)
+			So(err, ShouldBeNil)
+			So(valErrs, ShouldBeNil)
+		})
+	})
+}
+
+// TestUnitValidateRequestLeavesBody asserts that the request body can be read again once the request is validated.
+func TestUnitValidateRequestLeavesBody(t *testing.T) {
+
+	Convey("Given a validator and a valid request", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		callFindRoute = findRoute
+		callOpenApiFilterValidateRequest = openapi3filter.ValidateRequest
+		callGetCHErrors = getCHErrors
+
+		chv, _ := NewCHValidator(apiSpecLocation)
+		body, _ := os.ReadFile("schema_testing/officers/request_bodies/ok_request_body")
+		req := httptest.NewRequest("POST", "/delta/officers", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		Convey("When it is validated, then its body can be read again", func() {
+			valErrs, err := chv.ValidateRequestAgainstOpenApiSpec(req, contextId)
+			So(err, ShouldBeNil)
+			So(valErrs, ShouldBeNil)
+
+			data, err := io.ReadAll(req.Body)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, string(body))
+		})
+	})
+}
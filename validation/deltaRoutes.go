@@ -0,0 +1,154 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs-delta-api/helpers"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/oasdiff/yaml"
+)
+
+// Delta actions which can be declared against a path using the x-delta-action vendor extension.
+const (
+	ActionUpsert   = "upsert"
+	ActionDelete   = "delete"
+	ActionValidate = "validate"
+)
+
+const (
+	deltaPathPrefix = "/delta/"
+	extKafkaTopic   = "x-kafka-topic"
+	extPrimaryId    = "x-primary-id"
+	extDeltaAction  = "x-delta-action"
+	extMaxBodyBytes = "x-max-body-bytes"
+)
+
+// Variables used for unit testing and mocking external functions/methods.
+var (
+	callReadFile        = os.ReadFile
+	callLoadDeltaRoutes = loadDeltaRoutes
+)
+
+// DeltaRoute describes a delta endpoint declared in the OpenAPI spec using vendor extensions.
+type DeltaRoute struct {
+	Path   string
+	Action string
+	Topic  string
+	// PrimaryId is the JSON path of the primary ids in the request body, e.g. $.officers[*].internal_id.
+	PrimaryId string
+	// MaxBodyBytes is the largest request body the path accepts, or 0 if it uses the configured default.
+	MaxBodyBytes int64
+}
+
+// loadDeltaRoutes reads the x-delta-action, x-kafka-topic, x-primary-id and x-max-body-bytes extensions of every /delta path declared in
+// the root OpenAPI spec. The extensions sit alongside the $ref of each path item, which the kin-openapi loader discards
+// once the reference is resolved, so the root document is read again without resolving any references.
+func loadDeltaRoutes(openApiSpec string) ([]DeltaRoute, error) {
+
+	abs, err := callFilepathAbs(openApiSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := callReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc openapi3.T
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	routes := make([]DeltaRoute, 0)
+	for path, pathItem := range doc.Paths.Map() {
+		if !strings.HasPrefix(path, deltaPathPrefix) {
+			continue
+		}
+
+		route, err := newDeltaRoute(path, pathItem)
+		if err != nil {
+			log.Error(err, log.Data{config.OpenApiSpecKey: openApiSpec, config.MessageKey: "invalid delta path in Open API spec"})
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	// Sort the routes so they are registered in a stable order.
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+
+	return routes, nil
+}
+
+// newDeltaRoute builds a DeltaRoute from the vendor extensions of a path item, checking that all extensions needed
+// by its action are present.
+func newDeltaRoute(path string, pathItem *openapi3.PathItem) (DeltaRoute, error) {
+
+	route := DeltaRoute{
+		Path:      path,
+		Action:    getExtension(pathItem, extDeltaAction),
+		Topic:     getExtension(pathItem, extKafkaTopic),
+		PrimaryId: getExtension(pathItem, extPrimaryId),
+	}
+
+	maxBodyBytes, err := getSizeExtension(pathItem, extMaxBodyBytes)
+	if err != nil {
+		return route, fmt.Errorf("path %s has an invalid %s extension: %w", path, extMaxBodyBytes, err)
+	}
+	route.MaxBodyBytes = maxBodyBytes
+
+	switch route.Action {
+	case ActionUpsert, ActionDelete:
+		if route.Topic == "" {
+			return route, fmt.Errorf("path %s is missing the %s extension", path, extKafkaTopic)
+		}
+		if route.PrimaryId == "" {
+			return route, fmt.Errorf("path %s is missing the %s extension", path, extPrimaryId)
+		}
+		if _, err := helpers.ParseIdPath(route.PrimaryId); err != nil {
+			return route, fmt.Errorf("path %s has an invalid %s extension: %w", path, extPrimaryId, err)
+		}
+	case ActionValidate:
+	case "":
+		return route, fmt.Errorf("path %s is missing the %s extension", path, extDeltaAction)
+	default:
+		return route, fmt.Errorf("path %s has unknown %s '%s'", path, extDeltaAction, route.Action)
+	}
+
+	return route, nil
+}
+
+// getExtension returns the string value of a path item vendor extension, or an empty string if it is not set.
+func getExtension(pathItem *openapi3.PathItem, name string) string {
+	if pathItem == nil {
+		return ""
+	}
+	v, _ := pathItem.Extensions[name].(string)
+	return v
+}
+
+// getSizeExtension returns the value of a path item vendor extension holding a size in bytes, or 0 if it is not set.
+// Sizes must be whole numbers greater than zero.
+func getSizeExtension(pathItem *openapi3.PathItem, name string) (int64, error) {
+	if pathItem == nil {
+		return 0, nil
+	}
+	v, ok := pathItem.Extensions[name]
+	if !ok {
+		return 0, nil
+	}
+	// Numbers are decoded from the spec as float64.
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || f <= 0 || f > math.MaxInt64 {
+		return 0, fmt.Errorf("%v is not a whole number of bytes greater than zero", v)
+	}
+	return int64(f), nil
+}
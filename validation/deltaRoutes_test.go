@@ -0,0 +1,127 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestUnitLoadDeltaRoutes asserts that every delta path in the spec is read along with its vendor extensions.
+func TestUnitLoadDeltaRoutes(t *testing.T) {
+
+	Convey("When I load the delta routes from the spec", t, func() {
+
+		callFilepathAbs = func(path string) (string, error) {
+			return apiSpecLocation, nil
+		}
+
+		routes, err := loadDeltaRoutes(apiSpecLocation)
+
+		Convey("Then every delta path is returned with its extensions", func() {
+			So(err, ShouldBeNil)
+			So(routes, ShouldNotBeEmpty)
+
+			byPath := make(map[string]DeltaRoute, len(routes))
+			for _, r := range routes {
+				byPath[r.Path] = r
+			}
+			So(byPath["/delta/officers"], ShouldResemble, DeltaRoute{Path: "/delta/officers", Action: ActionUpsert, Topic: "${OFFICER_DELTA_TOPIC}", PrimaryId: "$.officers[*].internal_id"})
+			So(byPath["/delta/charges/delete"], ShouldResemble, DeltaRoute{Path: "/delta/charges/delete", Action: ActionDelete, Topic: "${CHARGES_DELTA_TOPIC}", PrimaryId: "$.charges_id"})
+			So(byPath["/delta/acsp/validate"].Action, ShouldEqual, ActionValidate)
+		})
+	})
+}
+
+// TestUnitLoadDeltaRoutesFailsReadFile asserts that errors reading the spec are returned.
+func TestUnitLoadDeltaRoutesFailsReadFile(t *testing.T) {
+
+	Convey("When reading the spec fails", t, func() {
+
+		readFile := callReadFile
+		defer func() { callReadFile = readFile }()
+
+		errReturned := errors.New("error reading file")
+		callReadFile = func(name string) ([]byte, error) {
+			return nil, errReturned
+		}
+
+		routes, err := loadDeltaRoutes(apiSpecLocation)
+
+		Convey("Then the error is returned", func() {
+			So(routes, ShouldBeNil)
+			So(err, ShouldEqual, errReturned)
+		})
+	})
+}
+
+// TestUnitNewDeltaRoute asserts that paths missing the extensions required by their action are rejected.
+func TestUnitNewDeltaRoute(t *testing.T) {
+
+	Convey("Given a delta path item", t, func() {
+
+		pathItem := &openapi3.PathItem{Extensions: map[string]any{
+			extDeltaAction: ActionDelete,
+			extKafkaTopic:  "${TOPIC}",
+			extPrimaryId:   "$.company_number",
+		}}
+
+		Convey("When all extensions are present, then a route is returned", func() {
+			route, err := newDeltaRoute("/delta/example/delete", pathItem)
+			So(err, ShouldBeNil)
+			So(route.PrimaryId, ShouldEqual, "$.company_number")
+		})
+
+		Convey("When the action is missing, then an error is returned", func() {
+			delete(pathItem.Extensions, extDeltaAction)
+			_, err := newDeltaRoute("/delta/example/delete", pathItem)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the action is unknown, then an error is returned", func() {
+			pathItem.Extensions[extDeltaAction] = "publish"
+			_, err := newDeltaRoute("/delta/example/delete", pathItem)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the topic is missing, then an error is returned", func() {
+			delete(pathItem.Extensions, extKafkaTopic)
+			_, err := newDeltaRoute("/delta/example/delete", pathItem)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the primary id is missing, then an error is returned", func() {
+			delete(pathItem.Extensions, extPrimaryId)
+			_, err := newDeltaRoute("/delta/example/delete", pathItem)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the primary id isn't a valid JSON path, then an error is returned", func() {
+			pathItem.Extensions[extPrimaryId] = "company_number"
+			_, err := newDeltaRoute("/delta/example/delete", pathItem)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When a body limit is set, then it is returned", func() {
+			pathItem.Extensions[extMaxBodyBytes] = float64(2097152)
+			route, err := newDeltaRoute("/delta/example/delete", pathItem)
+			So(err, ShouldBeNil)
+			So(route.MaxBodyBytes, ShouldEqual, 2097152)
+		})
+
+		Convey("When a body limit isn't a whole number of bytes greater than zero, then an error is returned", func() {
+			for _, limit := range []any{float64(0), float64(-1), 1.5, "2MiB"} {
+				pathItem.Extensions[extMaxBodyBytes] = limit
+				_, err := newDeltaRoute("/delta/example/delete", pathItem)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("When a validate path has no topic or primary id, then a route is returned", func() {
+			pathItem.Extensions = map[string]any{extDeltaAction: ActionValidate}
+			_, err := newDeltaRoute("/delta/example/validate", pathItem)
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,126 @@
+package validation
+
+import (
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// Error codes identify the kind of each validation error, so CHIPS can act on errors without parsing their messages.
+// Unlike the messages, which are copied from kin-openapi, the codes don't change when the library is upgraded.
+const (
+	ErrorCodeRequired              = "required"
+	ErrorCodeMaxLength             = "max_length"
+	ErrorCodeMinLength             = "min_length"
+	ErrorCodeMaxItems              = "max_items"
+	ErrorCodeMinItems              = "min_items"
+	ErrorCodeUniqueItems           = "unique_items"
+	ErrorCodeMaxProperties         = "max_properties"
+	ErrorCodeMinProperties         = "min_properties"
+	ErrorCodeMaximum               = "maximum"
+	ErrorCodeMinimum               = "minimum"
+	ErrorCodeMultipleOf            = "multiple_of"
+	ErrorCodeEnum                  = "enum"
+	ErrorCodePattern               = "pattern"
+	ErrorCodeFormat                = "format"
+	ErrorCodeType                  = "type"
+	ErrorCodeUnsupportedProperty   = "unsupported_property"
+	ErrorCodeSchema                = "schema"
+	ErrorCodeMalformedJSON         = "malformed_json"
+	ErrorCodeInFuture              = "in_future"
+	ErrorCodeCompanyNumberMismatch = "company_number_mismatch"
+	ErrorCodeInvalid               = "invalid"
+)
+
+// schemaErrorCodes maps the schema field a kin-openapi SchemaError failed on to its error code. Schema fields which
+// aren't listed have the code ErrorCodeInvalid.
+var schemaErrorCodes = map[string]string{
+	"required":         ErrorCodeRequired,
+	"maxLength":        ErrorCodeMaxLength,
+	"minLength":        ErrorCodeMinLength,
+	"maxItems":         ErrorCodeMaxItems,
+	"minItems":         ErrorCodeMinItems,
+	"uniqueItems":      ErrorCodeUniqueItems,
+	"maxProperties":    ErrorCodeMaxProperties,
+	"minProperties":    ErrorCodeMinProperties,
+	"maximum":          ErrorCodeMaximum,
+	"exclusiveMaximum": ErrorCodeMaximum,
+	"minimum":          ErrorCodeMinimum,
+	"exclusiveMinimum": ErrorCodeMinimum,
+	"multipleOf":       ErrorCodeMultipleOf,
+	"enum":             ErrorCodeEnum,
+	"pattern":          ErrorCodePattern,
+	"format":           ErrorCodeFormat,
+	"type":             ErrorCodeType,
+	"nullable":         ErrorCodeType,
+	"properties":       ErrorCodeUnsupportedProperty,
+	"oneOf":            ErrorCodeSchema,
+	"anyOf":            ErrorCodeSchema,
+	"allOf":            ErrorCodeSchema,
+	"not":              ErrorCodeSchema,
+}
+
+// schemaErrorCode returns the error code of a SchemaError.
+func schemaErrorCode(se *openapi3.SchemaError) string {
+	if code, ok := schemaErrorCodes[se.SchemaField]; ok {
+		return code
+	}
+	return ErrorCodeInvalid
+}
+
+// schemaConstraint returns the value of the schema field a SchemaError failed on, or nil if it has none worth
+// reporting.
+func schemaConstraint(se *openapi3.SchemaError) interface{} {
+
+	s := se.Schema
+	if s == nil {
+		return nil
+	}
+
+	switch se.SchemaField {
+	case "maxLength":
+		if s.MaxLength != nil {
+			return *s.MaxLength
+		}
+	case "minLength":
+		return s.MinLength
+	case "maxItems":
+		if s.MaxItems != nil {
+			return *s.MaxItems
+		}
+	case "minItems":
+		return s.MinItems
+	case "maxProperties":
+		if s.MaxProps != nil {
+			return *s.MaxProps
+		}
+	case "minProperties":
+		return s.MinProps
+	case "maximum", "exclusiveMaximum":
+		if s.Max != nil {
+			return *s.Max
+		}
+	case "minimum", "exclusiveMinimum":
+		if s.Min != nil {
+			return *s.Min
+		}
+	case "multipleOf":
+		if s.MultipleOf != nil {
+			return *s.MultipleOf
+		}
+	case "enum":
+		return s.Enum
+	case "pattern":
+		return s.Pattern
+	case "format":
+		return s.Format
+	case "type", "nullable":
+		switch types := s.Type.Slice(); len(types) {
+		case 0:
+			return nil
+		case 1:
+			return types[0]
+		default:
+			return types
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestUnitHandleSchemaErrorCodes asserts that schema errors are given an error code and error values holding the
+// constraint and the value which broke it.
+func TestUnitHandleSchemaErrorCodes(t *testing.T) {
+
+	Convey("Given schema errors from kin-openapi", t, func() {
+
+		Convey("When a string is too long, then the maximum length is reported", func() {
+			e := handleSchemaError(&openapi3.SchemaError{
+				Value:       "123456789",
+				Schema:      openapi3.NewStringSchema().WithMaxLength(8),
+				SchemaField: "maxLength",
+				Reason:      "maximum string length is 8",
+			})
+			So(e.ErrorCode, ShouldEqual, ErrorCodeMaxLength)
+			So(e.ErrorValues, ShouldResemble, map[string]interface{}{"constraint": uint64(8), "value": "123456789"})
+		})
+
+		Convey("When a value isn't allowed, then the allowed values are reported", func() {
+			e := handleSchemaError(&openapi3.SchemaError{
+				Value:       "X",
+				Schema:      openapi3.NewStringSchema().WithEnum("Y", "N"),
+				SchemaField: "enum",
+			})
+			So(e.ErrorCode, ShouldEqual, ErrorCodeEnum)
+			So(e.ErrorValues, ShouldResemble, map[string]interface{}{"constraint": []interface{}{"Y", "N"}, "value": "X"})
+		})
+
+		Convey("When a value has the wrong type, then the expected type is reported", func() {
+			e := handleSchemaError(&openapi3.SchemaError{Value: 1.0, Schema: openapi3.NewStringSchema(), SchemaField: "type"})
+			So(e.ErrorCode, ShouldEqual, ErrorCodeType)
+			So(e.ErrorValues, ShouldResemble, map[string]interface{}{"constraint": "string", "value": 1.0})
+		})
+
+		Convey("When a string doesn't match a pattern or format, then the pattern or format is reported", func() {
+			e := handleSchemaError(&openapi3.SchemaError{Value: "a", Schema: openapi3.NewStringSchema().WithPattern("^[0-9]$"), SchemaField: "pattern"})
+			So(e.ErrorCode, ShouldEqual, ErrorCodePattern)
+			So(e.ErrorValues["constraint"], ShouldEqual, "^[0-9]$")
+
+			e = handleSchemaError(&openapi3.SchemaError{Value: "a", Schema: openapi3.NewStringSchema().WithFormat(FormatDate8), SchemaField: "format"})
+			So(e.ErrorCode, ShouldEqual, ErrorCodeFormat)
+			So(e.ErrorValues["constraint"], ShouldEqual, FormatDate8)
+		})
+
+		Convey("When a property is missing, then the object missing it isn't reported", func() {
+			e := handleSchemaError(&openapi3.SchemaError{
+				Value:       map[string]interface{}{"other": "value"},
+				Schema:      openapi3.NewObjectSchema(),
+				SchemaField: "required",
+				Reason:      `property "status" is missing`,
+			})
+			So(e.ErrorCode, ShouldEqual, ErrorCodeRequired)
+			So(e.Error, ShouldEqual, "property 'status' is missing")
+			So(e.ErrorValues, ShouldBeEmpty)
+		})
+
+		Convey("When the schema field isn't known, then the error is invalid", func() {
+			e := handleSchemaError(&openapi3.SchemaError{Value: "a", SchemaField: "discriminator"})
+			So(e.ErrorCode, ShouldEqual, ErrorCodeInvalid)
+			So(e.ErrorValues, ShouldResemble, map[string]interface{}{"value": "a"})
+		})
+	})
+}
+
+// TestUnitHandleRequestErrorCodes asserts that errors which aren't about a field of the request body are given error
+// codes.
+func TestUnitHandleRequestErrorCodes(t *testing.T) {
+
+	Convey("Given request errors from kin-openapi", t, func() {
+
+		Convey("When the body isn't valid JSON, then it is malformed", func() {
+			re := &openapi3filter.RequestError{Err: &openapi3filter.ParseError{Cause: errors.New("unexpected EOF")}}
+			e := handleRequestError(contextId, re, nil)
+			So(e, ShouldHaveLength, 1)
+			So(e[0].ErrorCode, ShouldEqual, ErrorCodeMalformedJSON)
+			So(e[0].Error, ShouldEqual, "unexpected EOF")
+		})
+
+		Convey("When the body is missing, then it is required", func() {
+			e := handleRequestError(contextId, &openapi3filter.RequestError{Err: openapi3filter.ErrInvalidRequired}, nil)
+			So(e, ShouldHaveLength, 1)
+			So(e[0].ErrorCode, ShouldEqual, ErrorCodeRequired)
+			So(e[0].Location, ShouldEqual, "request-body")
+		})
+
+		Convey("When the error is of any other kind, then it is invalid", func() {
+			e := handleRequestError(contextId, &openapi3filter.RequestError{Err: errors.New("other")}, nil)
+			So(e, ShouldHaveLength, 1)
+			So(e[0].ErrorCode, ShouldEqual, ErrorCodeInvalid)
+		})
+	})
+}
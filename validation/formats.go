@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// Names of the string formats CHIPS values can be declared with in the OpenAPI spec, using the format keyword.
+const (
+	FormatTimestamp14   = "ch-timestamp-14"
+	FormatDeltaAt       = "ch-delta-at"
+	FormatDate8         = "ch-date-8"
+	FormatCompanyNumber = "company-number"
+	FormatTransactionId = "transaction-id"
+)
+
+// Layouts of the CHIPS date and timestamp formats.
+const (
+	date8Layout       = "20060102"
+	timestamp14Layout = "20060102150405"
+)
+
+var (
+	// companyNumberPattern matches a company number: 8 digits, or a 2 character prefix followed by 6 digits.
+	companyNumberPattern = regexp.MustCompile(`^(?:[0-9]{8}|([A-Z][A-Z0-9])[0-9]{6})$`)
+	// transactionIdPattern matches a CHIPS transaction id of up to 10 digits.
+	transactionIdPattern = regexp.MustCompile(`^[0-9]{1,10}$`)
+)
+
+// companyNumberPrefixes are the prefixes of company numbers which aren't entirely numeric, identifying the type of
+// entity and the jurisdiction it is registered in.
+var companyNumberPrefixes = map[string]bool{
+	"AC": true, "CE": true, "CS": true, "ES": true, "FC": true, "FE": true, "GE": true, "GN": true, "GS": true,
+	"IC": true, "IP": true, "LP": true, "NA": true, "NC": true, "NF": true, "NI": true, "NL": true, "NO": true,
+	"NP": true, "NR": true, "NV": true, "NZ": true, "OC": true, "PC": true, "R0": true, "RC": true, "RS": true,
+	"SA": true, "SC": true, "SE": true, "SF": true, "SG": true, "SI": true, "SL": true, "SO": true, "SP": true,
+	"SR": true, "SZ": true, "ZC": true,
+}
+
+// The formats are registered with kin-openapi when the package is loaded, so they can be used by every spec file.
+func init() {
+	openapi3.DefineStringFormatValidator(FormatTimestamp14, openapi3.NewCallbackValidator(validateTimestamp14))
+	openapi3.DefineStringFormatValidator(FormatDeltaAt, openapi3.NewCallbackValidator(validateDeltaAt))
+	openapi3.DefineStringFormatValidator(FormatDate8, openapi3.NewCallbackValidator(validateDate8))
+	openapi3.DefineStringFormatValidator(FormatCompanyNumber, openapi3.NewCallbackValidator(validateCompanyNumber))
+	openapi3.DefineStringFormatValidator(FormatTransactionId, openapi3.NewCallbackValidator(validateTransactionId))
+}
+
+// validateTimestamp14 checks a yyyyMMddHHmmss timestamp is a real date and time.
+func validateTimestamp14(s string) error {
+	if _, err := time.Parse(timestamp14Layout, s); err != nil {
+		return errors.New("must be a valid timestamp of the form yyyyMMddHHmmss")
+	}
+	return nil
+}
+
+// validateDeltaAt checks a delta_at is a real date and time of the form yyyyMMddHHmmss followed by microseconds.
+func validateDeltaAt(s string) error {
+	if _, err := parseDeltaAt(s); err != nil {
+		return errors.New("must be a valid timestamp of the form yyyyMMddHHmmssSSSSSS")
+	}
+	return nil
+}
+
+// validateDate8 checks a yyyyMMdd date is a real date.
+func validateDate8(s string) error {
+	if _, err := time.Parse(date8Layout, s); err != nil {
+		return errors.New("must be a valid date of the form yyyyMMdd")
+	}
+	return nil
+}
+
+// validateCompanyNumber checks a company number is 8 digits, or a known prefix followed by 6 digits.
+func validateCompanyNumber(s string) error {
+	m := companyNumberPattern.FindStringSubmatch(s)
+	if m == nil {
+		return errors.New("must be 8 digits, or a 2 character prefix followed by 6 digits")
+	}
+	if m[1] != "" && !companyNumberPrefixes[m[1]] {
+		return fmt.Errorf("has an unknown prefix %s", m[1])
+	}
+	return nil
+}
+
+// validateTransactionId checks a transaction id is between 1 and 10 digits.
+func validateTransactionId(s string) error {
+	if !transactionIdPattern.MatchString(s) {
+		return errors.New("must be between 1 and 10 digits")
+	}
+	return nil
+}
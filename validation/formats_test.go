@@ -0,0 +1,159 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/companieshouse/chs-delta-api/helpers"
+	"github.com/companieshouse/chs-delta-api/models"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// formatSpec is a spec with a single delta route whose company_number must be a company number.
+const formatSpec = `openapi: 3.0.3
+info:
+  title: Format test
+  version: "1.0"
+paths:
+  /delta/test:
+    x-delta-action: validate
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                company_number:
+                  type: string
+                  format: company-number
+      responses:
+        '200':
+          description: Valid delta.
+`
+
+// TestUnitFormatsRegistered asserts that every CHIPS format is registered with kin-openapi.
+func TestUnitFormatsRegistered(t *testing.T) {
+	Convey("Given the validation package has been loaded", t, func() {
+
+		Convey("Then every CHIPS format is registered", func() {
+			for _, format := range []string{FormatTimestamp14, FormatDeltaAt, FormatDate8, FormatCompanyNumber, FormatTransactionId} {
+				So(openapi3.SchemaStringFormats, ShouldContainKey, format)
+			}
+		})
+	})
+}
+
+// TestUnitTimestampFormats asserts that dates and timestamps must be real calendar dates and times.
+func TestUnitTimestampFormats(t *testing.T) {
+	Convey("Given CHIPS dates and timestamps", t, func() {
+
+		Convey("When they are real dates and times, then they are valid", func() {
+			So(validateDate8("20240229"), ShouldBeNil)
+			So(validateTimestamp14("20240229235959"), ShouldBeNil)
+			So(validateDeltaAt("20241102053919015835"), ShouldBeNil)
+		})
+
+		Convey("When they aren't real dates, then they are invalid", func() {
+			for _, date := range []string{"20230229", "20241301", "20240431", "2024010", "202401011", "2024-1-1", "string"} {
+				So(validateDate8(date), ShouldNotBeNil)
+			}
+		})
+
+		Convey("When they aren't real timestamps, then they are invalid", func() {
+			for _, timestamp := range []string{"20230229000000", "20240101240000", "20240101006000", "2024010100000", "20120604053919990"} {
+				So(validateTimestamp14(timestamp), ShouldNotBeNil)
+			}
+			for _, deltaAt := range []string{"20230229000000000000", "2024110205391901583", "2024110205391901583599", "2024110205391901583x"} {
+				So(validateDeltaAt(deltaAt), ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+// TestUnitCompanyNumberFormat asserts that company numbers must be 8 digits, or a known prefix followed by 6 digits.
+func TestUnitCompanyNumberFormat(t *testing.T) {
+	Convey("Given company numbers", t, func() {
+
+		Convey("When they are 8 digits or have a known prefix, then they are valid", func() {
+			for _, number := range []string{"12345678", "00006400", "SC123456", "NI000001", "OC300001", "R0000123"} {
+				So(validateCompanyNumber(number), ShouldBeNil)
+			}
+		})
+
+		Convey("When they have an unknown prefix, then the prefix is reported", func() {
+			So(validateCompanyNumber("XX123456").Error(), ShouldEqual, "has an unknown prefix XX")
+		})
+
+		Convey("When they are the wrong length or shape, then they are invalid", func() {
+			for _, number := range []string{"1234567", "1234567890", "sc123456", "SC12345", "S1234567", "SC12345A", ""} {
+				So(validateCompanyNumber(number), ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+// TestUnitTransactionIdFormat asserts that transaction ids must be between 1 and 10 digits.
+func TestUnitTransactionIdFormat(t *testing.T) {
+	Convey("Given transaction ids", t, func() {
+
+		Convey("When they are between 1 and 10 digits, then they are valid", func() {
+			So(validateTransactionId("1"), ShouldBeNil)
+			So(validateTransactionId("1234567890"), ShouldBeNil)
+		})
+
+		Convey("When they are empty, too long or not digits, then they are invalid", func() {
+			for _, id := range []string{"", "12345678901", "12a", "-1"} {
+				So(validateTransactionId(id), ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+// TestUnitFormatInSpec asserts that a spec declaring a field with a CHIPS format rejects deltas whose value doesn't
+// match it, with a format error naming the format.
+func TestUnitFormatInSpec(t *testing.T) {
+
+	Convey("Given a validator for a spec with a company number field", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		callFindRoute = findRoute
+		callOpenApiFilterValidateRequest = openapi3filter.ValidateRequest
+		callGetCHErrors = getCHErrors
+
+		spec := filepath.Join(t.TempDir(), "api-spec.yml")
+		So(os.WriteFile(spec, []byte(formatSpec), 0o600), ShouldBeNil)
+		chv, err := NewCHValidator(spec)
+		So(err, ShouldBeNil)
+
+		validate := func(data string) []byte {
+			req := httptest.NewRequest("POST", "/delta/test", bytes.NewBufferString(data))
+			req.Header.Set("Content-Type", "application/json")
+			valErrs, err := chv.ValidateDelta(req, helpers.NewDeltaBody(data), contextId)
+			So(err, ShouldBeNil)
+			return valErrs
+		}
+
+		Convey("When the company number is valid, then there are no errors", func() {
+			So(validate(`{"company_number":"SC123456"}`), ShouldBeNil)
+		})
+
+		Convey("When the company number doesn't match the format, then a format error is returned", func() {
+			var chErrs []models.CHError
+			So(json.Unmarshal(validate(`{"company_number":"XX123456"}`), &chErrs), ShouldBeNil)
+			So(chErrs, ShouldHaveLength, 1)
+			So(chErrs[0].ErrorCode, ShouldEqual, ErrorCodeFormat)
+			So(chErrs[0].Location, ShouldEqual, "company_number")
+			So(chErrs[0].Error, ShouldEqual, "string doesn't match the format 'company-number' (has an unknown prefix XX)")
+			So(chErrs[0].ErrorValues["constraint"], ShouldEqual, FormatCompanyNumber)
+			So(chErrs[0].ErrorValues["value"], ShouldEqual, "XX123456")
+		})
+	})
+}
@@ -5,6 +5,8 @@
 package mocks
 
 import (
+	helpers "github.com/companieshouse/chs-delta-api/helpers"
+	validation "github.com/companieshouse/chs-delta-api/validation"
 	gomock "github.com/golang/mock/gomock"
 	http "net/http"
 	reflect "reflect"
@@ -47,3 +49,46 @@ func (mr *MockCHValidatorMockRecorder) ValidateRequestAgainstOpenApiSpec(httpReq
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateRequestAgainstOpenApiSpec", reflect.TypeOf((*MockCHValidator)(nil).ValidateRequestAgainstOpenApiSpec), httpReq, contextId)
 }
+
+// ValidateDelta mocks base method
+func (m *MockCHValidator) ValidateDelta(httpReq *http.Request, body *helpers.DeltaBody, contextId string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ValidateDelta", httpReq, body, contextId)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ValidateDelta indicates an expected call of ValidateDelta
+func (mr *MockCHValidatorMockRecorder) ValidateDelta(httpReq, body, contextId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateDelta", reflect.TypeOf((*MockCHValidator)(nil).ValidateDelta), httpReq, body, contextId)
+}
+
+// GetDeltaRoutes mocks base method
+func (m *MockCHValidator) GetDeltaRoutes() []validation.DeltaRoute {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeltaRoutes")
+	ret0, _ := ret[0].([]validation.DeltaRoute)
+	return ret0
+}
+
+// GetDeltaRoutes indicates an expected call of GetDeltaRoutes
+func (mr *MockCHValidatorMockRecorder) GetDeltaRoutes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeltaRoutes", reflect.TypeOf((*MockCHValidator)(nil).GetDeltaRoutes))
+}
+
+// GetSpecVersion mocks base method
+func (m *MockCHValidator) GetSpecVersion() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSpecVersion")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetSpecVersion indicates an expected call of GetSpecVersion
+func (mr *MockCHValidatorMockRecorder) GetSpecVersion() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSpecVersion", reflect.TypeOf((*MockCHValidator)(nil).GetSpecVersion))
+}
@@ -0,0 +1,159 @@
+package validation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/companieshouse/chs-delta-api/config"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// Variables used for unit testing and mocking external functions/methods.
+var (
+	callStat = os.Stat
+)
+
+// SpecWatcher is implemented by validators which can reload their OpenAPI spec while the service is running.
+type SpecWatcher interface {
+	Watch(ctx context.Context, interval time.Duration)
+}
+
+// ReloadStatuser is implemented by validators which report whether the last attempt to reload their OpenAPI spec
+// failed.
+type ReloadStatuser interface {
+	LastReloadError() error
+}
+
+// specReader reads the files making up an OpenAPI spec, recording the contents of each one.
+type specReader struct {
+	files map[string][]byte
+}
+
+// read reads a local file referenced by the spec and records its contents.
+func (sr *specReader) read(loader *openapi3.Loader, location *url.URL) ([]byte, error) {
+	data, err := openapi3.ReadFromFile(loader, location)
+	if err != nil {
+		return nil, err
+	}
+	sr.files[location.Path] = data
+	return data, nil
+}
+
+// summary returns a SHA-256 hash over every file read, along with the sorted list of those files.
+func (sr *specReader) summary() (string, []string) {
+	files := make([]string, 0, len(sr.files))
+	for f := range sr.files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, f := range files {
+		h.Write([]byte(f))
+		h.Write(sr.files[f])
+	}
+	return hex.EncodeToString(h.Sum(nil)), files
+}
+
+// Reload loads the OpenAPI spec from disk again and, if it is valid, atomically swaps it in for the current version.
+// Requests already being validated continue to use the version they started with. Routes are registered once at
+// startup, so a spec which changes the delta routes is rejected and requires a restart.
+func (chv *CHValidatorImpl) Reload(ctx context.Context) error {
+
+	spec, err := loadSchemaFromFile(ctx, chv.openApiSpec)
+	if err != nil {
+		return err
+	}
+
+	if err := spec.doc.Validate(ctx, specValidationOptions...); err != nil {
+		return err
+	}
+
+	routes, err := callLoadDeltaRoutes(chv.openApiSpec)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(routes, chv.routes) {
+		return errors.New("delta routes in Open API spec have changed, a restart is required to apply them")
+	}
+
+	if chv.spec.Load().hash == spec.hash {
+		log.Info("Open API spec unchanged", log.Data{config.SpecHashKey: spec.hash})
+		return nil
+	}
+
+	chv.spec.Store(spec)
+	log.Info("Activated Open API spec", log.Data{config.OpenApiSpecKey: chv.openApiSpec, config.SpecHashKey: spec.hash})
+
+	return nil
+}
+
+// Watch reloads the OpenAPI spec whenever the process receives SIGHUP and, if interval is greater than zero, whenever
+// one of the files making up the spec is modified. It blocks until ctx is done.
+func (chv *CHValidatorImpl) Watch(ctx context.Context, interval time.Duration) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	modTimes := chv.spec.Load().modTimes
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("Received SIGHUP, reloading Open API spec")
+		case <-tick:
+			latest := getModTimes(chv.spec.Load().files)
+			if reflect.DeepEqual(latest, modTimes) {
+				continue
+			}
+			log.Info("Open API spec modified, reloading")
+		}
+
+		err := chv.Reload(ctx)
+		if err != nil {
+			log.Error(err, log.Data{config.OpenApiSpecKey: chv.openApiSpec, config.MessageKey: "failed to reload Open API spec, keeping the current version"})
+		}
+		chv.reloadErr.Store(&err)
+		modTimes = getModTimes(chv.spec.Load().files)
+	}
+}
+
+// LastReloadError returns the error from the last attempt to reload the OpenAPI spec, or nil if it succeeded or there
+// hasn't been one.
+func (chv *CHValidatorImpl) LastReloadError() error {
+	if err := chv.reloadErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// getModTimes returns the modification time of each of the given files. Files which can't be read are omitted.
+func getModTimes(files []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if fi, err := callStat(f); err == nil {
+			modTimes[f] = fi.ModTime()
+		}
+	}
+	return modTimes
+}
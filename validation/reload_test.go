@@ -0,0 +1,143 @@
+package validation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const apiSpecDir = "../ecs-image-build/apispec"
+
+// copySpec copies the OpenAPI spec files into a temporary directory so they can be modified by a test.
+func copySpec(t *testing.T) string {
+	dir := t.TempDir()
+	entries, _ := os.ReadDir(apiSpecDir)
+	for _, e := range entries {
+		data, _ := os.ReadFile(filepath.Join(apiSpecDir, e.Name()))
+		_ = os.WriteFile(filepath.Join(dir, e.Name()), data, 0o644)
+	}
+	return filepath.Join(dir, "api-spec.yml")
+}
+
+// editSpecFile replaces old with new in one of the spec files.
+func editSpecFile(t *testing.T, spec, file, old, new string) {
+	name := filepath.Join(filepath.Dir(spec), file)
+	data, _ := os.ReadFile(name)
+	if !strings.Contains(string(data), old) {
+		t.Fatalf("%s does not contain %q", file, old)
+	}
+	_ = os.WriteFile(name, []byte(strings.Replace(string(data), old, new, 1)), 0o644)
+}
+
+// TestUnitReload asserts that a valid spec is swapped in on reload, while an invalid one is rejected.
+func TestUnitReload(t *testing.T) {
+
+	Convey("Given a validator loaded from a spec on disk", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		spec := copySpec(t)
+
+		chv, err := NewCHValidator(spec)
+		So(err, ShouldBeNil)
+		impl := chv.(*CHValidatorImpl)
+		original := impl.spec.Load()
+
+		Convey("When a referenced file is changed and the spec reloaded, then the new version is activated", func() {
+			editSpecFile(t, spec, "officer-delta-spec.yml", "CreatedTime:\n          type: string", "CreatedTime:\n          type: string\n          maxLength: 30")
+
+			err := impl.Reload(context.Background())
+
+			So(err, ShouldBeNil)
+			So(impl.spec.Load().hash, ShouldNotEqual, original.hash)
+			So(original.doc, ShouldNotEqual, impl.spec.Load().doc)
+		})
+
+		Convey("When nothing has changed and the spec reloaded, then the current version is kept", func() {
+			err := impl.Reload(context.Background())
+
+			So(err, ShouldBeNil)
+			So(impl.spec.Load(), ShouldEqual, original)
+		})
+
+		Convey("When the spec no longer validates, then the reload fails and the current version is kept", func() {
+			editSpecFile(t, spec, "officer-delta-spec.yml", "CreatedTime:\n          type: string", "CreatedTime:\n          type: not-a-type")
+
+			err := impl.Reload(context.Background())
+
+			So(err, ShouldNotBeNil)
+			So(impl.spec.Load(), ShouldEqual, original)
+		})
+
+		Convey("When the spec can't be parsed, then the reload fails and the current version is kept", func() {
+			editSpecFile(t, spec, "api-spec.yml", "paths:", "paths: [")
+
+			err := impl.Reload(context.Background())
+
+			So(err, ShouldNotBeNil)
+			So(impl.spec.Load(), ShouldEqual, original)
+		})
+
+		Convey("When the delta routes change, then the reload fails and the current version is kept", func() {
+			editSpecFile(t, spec, "api-spec.yml", "x-primary-id: '$.officers[*].internal_id'", "x-primary-id: '$.officers[*].officer_id'")
+
+			err := impl.Reload(context.Background())
+
+			So(err, ShouldNotBeNil)
+			So(impl.spec.Load(), ShouldEqual, original)
+		})
+	})
+}
+
+// TestUnitWatch asserts that a change to a spec file on disk is picked up while watching.
+func TestUnitWatch(t *testing.T) {
+
+	Convey("Given a validator watching a spec on disk", t, func() {
+
+		callFilepathAbs = filepath.Abs
+		spec := copySpec(t)
+
+		chv, err := NewCHValidator(spec)
+		So(err, ShouldBeNil)
+		impl := chv.(*CHValidatorImpl)
+		original := impl.spec.Load()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go impl.Watch(ctx, 10*time.Millisecond)
+
+		Convey("When a referenced file is modified, then the new version is activated", func() {
+			name := filepath.Join(filepath.Dir(spec), "officer-delta-spec.yml")
+			editSpecFile(t, spec, "officer-delta-spec.yml", "CreatedTime:\n          type: string", "CreatedTime:\n          type: string\n          maxLength: 30")
+			later := time.Now().Add(time.Second)
+			_ = os.Chtimes(name, later, later)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for impl.spec.Load() == original && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			So(impl.spec.Load().hash, ShouldNotEqual, original.hash)
+			So(impl.LastReloadError(), ShouldBeNil)
+		})
+
+		Convey("When a referenced file is modified so the spec no longer validates, then the reload error is reported", func() {
+			name := filepath.Join(filepath.Dir(spec), "officer-delta-spec.yml")
+			editSpecFile(t, spec, "officer-delta-spec.yml", "CreatedTime:\n          type: string", "CreatedTime:\n          type: not-a-type")
+			later := time.Now().Add(time.Second)
+			_ = os.Chtimes(name, later, later)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for impl.LastReloadError() == nil && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			So(impl.LastReloadError(), ShouldNotBeNil)
+			So(impl.spec.Load(), ShouldEqual, original)
+		})
+	})
+}
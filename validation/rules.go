@@ -0,0 +1,193 @@
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/companieshouse/chs-delta-api/models"
+)
+
+// Variables used for unit testing and mocking external functions/methods.
+var (
+	callNow = time.Now
+)
+
+// chipsLocation is the time zone CHIPS writes delta_at timestamps in.
+var chipsLocation, _ = time.LoadLocation("Europe/London")
+
+// Delta is a request body which has passed validation against the OpenAPI spec, ready to be checked against business
+// rules.
+type Delta struct {
+	// Type is the name of the delta, e.g. officer-delta.
+	Type string
+	// Action is the action of the route the delta was sent to: ActionUpsert, ActionDelete or ActionValidate.
+	Action string
+	// Body is the decoded JSON request body.
+	Body interface{}
+}
+
+// Rule checks a delta against a business rule which can't be expressed in the OpenAPI spec, returning a CHError for
+// every violation found.
+type Rule func(d Delta) []models.CHError
+
+// RuleSet holds the business rules deltas are checked against once they have passed schema validation. Rules can apply
+// to every delta or only to deltas of a given type.
+type RuleSet struct {
+	all    []Rule
+	byType map[string][]Rule
+}
+
+// NewRuleSet returns a RuleSet without any rules.
+func NewRuleSet() *RuleSet {
+	return &RuleSet{byType: make(map[string][]Rule)}
+}
+
+// DefaultRules returns the business rules of CHIPS deltas.
+func DefaultRules() *RuleSet {
+	rs := NewRuleSet()
+	rs.Use(DeltaAtRule, DeleteActionRule)
+	rs.Register("officer-delta", SameCompanyNumberRule("officers"))
+	return rs
+}
+
+// Use adds rules which every delta is checked against.
+func (rs *RuleSet) Use(rules ...Rule) {
+	rs.all = append(rs.all, rules...)
+}
+
+// Register adds rules which only deltas of the given type are checked against.
+func (rs *RuleSet) Register(deltaType string, rules ...Rule) {
+	rs.byType[deltaType] = append(rs.byType[deltaType], rules...)
+}
+
+// Check returns the violations of every rule which applies to the delta.
+func (rs *RuleSet) Check(d Delta) []models.CHError {
+	var violations []models.CHError
+	for _, rule := range rs.all {
+		violations = append(violations, rule(d)...)
+	}
+	for _, rule := range rs.byType[d.Type] {
+		violations = append(violations, rule(d)...)
+	}
+	return violations
+}
+
+// DeltaAtRule checks that every delta_at in the delta is a 20-digit CHIPS timestamp, of the form yyyyMMddHHmmss
+// followed by microseconds, which isn't in the future.
+func DeltaAtRule(d Delta) []models.CHError {
+	var violations []models.CHError
+	walkFields(d.Body, nil, func(path []string, field string, value interface{}) {
+		if field != "delta_at" {
+			return
+		}
+		s, ok := value.(string)
+		if !ok {
+			return
+		}
+		deltaAt, err := parseDeltaAt(s)
+		if err != nil {
+			violations = append(violations, ruleViolation(path, field, ErrorCodeFormat, FormatDeltaAt, s,
+				"delta_at must be a 20 digit timestamp of the form yyyyMMddHHmmssSSSSSS"))
+		} else if deltaAt.After(callNow()) {
+			violations = append(violations, ruleViolation(path, field, ErrorCodeInFuture, nil, s, "delta_at must not be in the future"))
+		}
+	})
+	return violations
+}
+
+// parseDeltaAt parses a CHIPS delta_at timestamp: a yyyyMMddHHmmss timestamp followed by 6 digits of microseconds.
+func parseDeltaAt(s string) (time.Time, error) {
+	if len(s) != 20 {
+		return time.Time{}, fmt.Errorf("delta_at %q is not 20 digits", s)
+	}
+	micros, err := strconv.Atoi(s[14:])
+	if err != nil || strings.ContainsAny(s[14:], "+-") {
+		return time.Time{}, fmt.Errorf("delta_at %q does not end in microseconds", s)
+	}
+	t, err := time.ParseInLocation(timestamp14Layout, s[:14], chipsLocation)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(time.Duration(micros) * time.Microsecond), nil
+}
+
+// DeleteActionRule checks that deltas sent to a delete route have an action of DELETE.
+func DeleteActionRule(d Delta) []models.CHError {
+	if d.Action != ActionDelete {
+		return nil
+	}
+	body, _ := d.Body.(map[string]interface{})
+	if action, _ := body["action"].(string); action != "DELETE" {
+		return []models.CHError{ruleViolation(nil, "action", ErrorCodeEnum, []interface{}{"DELETE"}, body["action"],
+			"delete deltas must have an action of DELETE")}
+	}
+	return nil
+}
+
+// SameCompanyNumberRule returns a rule checking that every item of the named array has the same company_number as
+// the first.
+func SameCompanyNumberRule(array string) Rule {
+	return func(d Delta) []models.CHError {
+		body, _ := d.Body.(map[string]interface{})
+		items, _ := body[array].([]interface{})
+
+		var violations []models.CHError
+		var first interface{}
+		for i, item := range items {
+			obj, _ := item.(map[string]interface{})
+			number, ok := obj["company_number"]
+			if !ok {
+				continue
+			}
+			if first == nil {
+				first = number
+				continue
+			}
+			if number != first {
+				violations = append(violations, ruleViolation([]string{array, strconv.Itoa(i)}, "company_number",
+					ErrorCodeCompanyNumberMismatch, first, number, fmt.Sprintf("every item of %s must have the same company_number", array)))
+			}
+		}
+		return violations
+	}
+}
+
+// walkFields calls fn for every field of every object in a decoded JSON value, with the path of the object holding it.
+func walkFields(value interface{}, path []string, fn func(path []string, field string, value interface{})) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// Visit fields in order so violations are reported in the same order every time.
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fv := v[field]
+			fn(path, field, fv)
+			walkFields(fv, append(path[:len(path):len(path)], field), fn)
+		}
+	case []interface{}:
+		for i, item := range v {
+			walkFields(item, append(path[:len(path):len(path)], strconv.Itoa(i)), fn)
+		}
+	}
+}
+
+// ruleViolation returns a CHError describing a field which breaks a business rule, located and coded in the same way as
+// schema validation errors.
+func ruleViolation(path []string, field, code string, constraint, value interface{}, reason string) models.CHError {
+	target := newErrorTarget(append(path[:len(path):len(path)], field), value)
+	return models.CHError{
+		Error:        reason,
+		ErrorCode:    code,
+		ErrorValues:  target.errorValues(constraint),
+		Location:     target.location(),
+		LocationType: jsonPath,
+		Type:         chValidationType,
+	}
+}
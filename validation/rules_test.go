@@ -0,0 +1,126 @@
+package validation
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/chs-delta-api/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// decode decodes a JSON body as the rules receive it.
+func decode(body string) interface{} {
+	var v interface{}
+	_ = json.Unmarshal([]byte(body), &v)
+	return v
+}
+
+// TestUnitDeltaAtRule asserts that delta_at timestamps must be 20 digits and not in the future.
+func TestUnitDeltaAtRule(t *testing.T) {
+
+	callNow = func() time.Time { return time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC) }
+	defer func() { callNow = time.Now }()
+
+	Convey("Given deltas with delta_at timestamps", t, func() {
+
+		Convey("When delta_at is a past timestamp, then there are no violations", func() {
+			So(DeltaAtRule(Delta{Body: decode(`{"delta_at":"20240701125959999999"}`)}), ShouldBeEmpty)
+		})
+
+		Convey("When delta_at is in the future, then it is a violation", func() {
+			// 13:00 BST is 12:00 UTC, so a microsecond later is in the future.
+			v := DeltaAtRule(Delta{Body: decode(`{"delta_at":"20240701130000000001"}`)})
+			So(v, ShouldHaveLength, 1)
+			So(v[0].Error, ShouldEqual, "delta_at must not be in the future")
+			So(v[0].ErrorCode, ShouldEqual, ErrorCodeInFuture)
+			So(v[0].Location, ShouldEqual, "delta_at")
+		})
+
+		Convey("When delta_at isn't a 20 digit timestamp, then it is a violation", func() {
+			for _, deltaAt := range []string{"string", "2024070112000000000", "2024070112000000000x", "20241301120000000000", "20240701120000-00000"} {
+				v := DeltaAtRule(Delta{Body: decode(`{"delta_at":"` + deltaAt + `"}`)})
+				So(v, ShouldHaveLength, 1)
+				So(v[0].ErrorCode, ShouldEqual, ErrorCodeFormat)
+				So(v[0].ErrorValues, ShouldResemble, map[string]interface{}{"property": "delta_at", "constraint": FormatDeltaAt, "value": deltaAt})
+			}
+		})
+
+		Convey("When delta_at is nested in an array, then it is located by its path", func() {
+			v := DeltaAtRule(Delta{Body: decode(`{"charges":[{"delta_at":"20240101000000000000"},{"delta_at":"bad"}]}`)})
+			So(v, ShouldHaveLength, 1)
+			So(v[0].Location, ShouldEqual, "charges.1.delta_at")
+		})
+	})
+}
+
+// TestUnitDeleteActionRule asserts that delete deltas must have an action of DELETE.
+func TestUnitDeleteActionRule(t *testing.T) {
+	Convey("Given a delete delta", t, func() {
+
+		Convey("When its action is DELETE, then there are no violations", func() {
+			So(DeleteActionRule(Delta{Action: ActionDelete, Body: decode(`{"action":"DELETE"}`)}), ShouldBeEmpty)
+		})
+
+		Convey("When it has no action, then it is a violation", func() {
+			v := DeleteActionRule(Delta{Action: ActionDelete, Body: decode(`{}`)})
+			So(v, ShouldResemble, []models.CHError{{
+				Error:        "delete deltas must have an action of DELETE",
+				ErrorCode:    ErrorCodeEnum,
+				ErrorValues:  map[string]interface{}{"property": "action", "constraint": []interface{}{"DELETE"}},
+				Location:     "action",
+				LocationType: jsonPath,
+				Type:         chValidationType,
+			}})
+		})
+	})
+
+	Convey("Given an upsert delta without an action, then there are no violations", t, func() {
+		So(DeleteActionRule(Delta{Action: ActionUpsert, Body: decode(`{}`)}), ShouldBeEmpty)
+	})
+}
+
+// TestUnitSameCompanyNumberRule asserts that every item of an array must have the same company number.
+func TestUnitSameCompanyNumberRule(t *testing.T) {
+	Convey("Given a rule for officers", t, func() {
+		rule := SameCompanyNumberRule("officers")
+
+		Convey("When every officer has the same company number, then there are no violations", func() {
+			So(rule(Delta{Body: decode(`{"officers":[{"company_number":"1"},{"company_number":"1"}]}`)}), ShouldBeEmpty)
+		})
+
+		Convey("When officers have different company numbers, then each differing officer is a violation", func() {
+			v := rule(Delta{Body: decode(`{"officers":[{"company_number":"1"},{"company_number":"2"},{"company_number":"1"},{"company_number":"3"}]}`)})
+			So(v, ShouldHaveLength, 2)
+			So(v[0].Location, ShouldEqual, "officers.1.company_number")
+			So(v[1].Location, ShouldEqual, "officers.3.company_number")
+			So(v[1].ErrorCode, ShouldEqual, ErrorCodeCompanyNumberMismatch)
+			So(v[1].ErrorValues, ShouldResemble, map[string]interface{}{"property": "company_number", "constraint": "1", "value": "3"})
+		})
+
+		Convey("When the body has no officers, then there are no violations", func() {
+			So(rule(Delta{Body: decode(`{}`)}), ShouldBeEmpty)
+			So(rule(Delta{Body: nil}), ShouldBeEmpty)
+		})
+	})
+}
+
+// TestUnitRuleSet asserts that rules are applied to every delta or only to deltas of their type.
+func TestUnitRuleSet(t *testing.T) {
+	Convey("Given a rule set with a rule for every delta and a rule for officers", t, func() {
+		violation := func(location string) Rule {
+			return func(d Delta) []models.CHError { return []models.CHError{{Location: location}} }
+		}
+		rs := NewRuleSet()
+		rs.Use(violation("all"))
+		rs.Register("officer-delta", violation("officers"))
+
+		Convey("Then officer deltas are checked against both rules", func() {
+			So(rs.Check(Delta{Type: "officer-delta"}), ShouldResemble, []models.CHError{{Location: "all"}, {Location: "officers"}})
+		})
+
+		Convey("Then other deltas are only checked against the rule for every delta", func() {
+			So(rs.Check(Delta{Type: "charges-delta"}), ShouldResemble, []models.CHError{{Location: "all"}})
+		})
+	})
+}
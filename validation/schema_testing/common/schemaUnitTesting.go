@@ -43,7 +43,8 @@ func ReadRequestBody(fl string) []byte {
 
 // CompareActualToExpected takes actual and expected json (as byte arrays) and compares them to see if they match. Ordering of response
 // isn't always guaranteed when calling the kin-openAPI validator so using this function allows you to match the response
-// the library gives you with an expected response without worrying about ordering.
+// the library gives you with an expected response without worrying about ordering. Errors are matched on every field,
+// including all of their error values.
 func CompareActualToExpected(actual, expected []byte) bool {
 
 	// Define 2 model CHError arrays to hold actual and expected responses.
@@ -59,27 +60,35 @@ func CompareActualToExpected(actual, expected []byte) bool {
 		return false
 	}
 
-	// create a map of CHError.Location (string) -> int to compare if both arrays are completely equal.
+	// create a map of CHError (string) -> int to compare if both arrays are completely equal.
 	diff := make(map[string]int, len(*expectedErrArr))
 
 	// Range over the expected response array and add them to the newly created map.
 	for _, ee := range *expectedErrArr {
 		// 0 value for int is 0, so just increment a counter for the string
-		diff[ee.Location]++
+		diff[errorKey(ee)]++
 	}
 
 	// Finally range over the actual response array errors and check that they exist in the expected errors map.
 	for _, ae := range *actualErrArr {
 		// If the error is not in diff bail out early as they can't be a match.
-		if _, ok := diff[ae.Location]; !ok {
+		key := errorKey(ae)
+		if _, ok := diff[key]; !ok {
 			return false
 		}
-		diff[ae.Location] -= 1
-		if diff[ae.Location] == 0 {
-			delete(diff, ae.Location)
+		diff[key] -= 1
+		if diff[key] == 0 {
+			delete(diff, key)
 		}
 	}
 
 	// Return if length of remaining map values is equal to 0. If it is, we have a match.
 	return len(diff) == 0
 }
+
+// errorKey identifies a CHError by all of its fields. Error values are encoded with their keys sorted, so the key
+// doesn't depend on the order they were written in.
+func errorKey(e models.CHError) string {
+	key, _ := json.Marshal(e)
+	return string(key)
+}
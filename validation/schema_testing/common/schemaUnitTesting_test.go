@@ -0,0 +1,37 @@
+package common
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+const (
+	maxLengthError = `{"error":"maximum string length is 8","error_code":"max_length","error_values":{"constraint":8,"property":"company_number","value":"123456789"},"location":"company_number","location_type":"json-path","type":"ch:validation"}`
+	requiredError  = `{"error":"property 'delta_at' is missing","error_code":"required","error_values":{"property":"delta_at"},"location":"delta_at","location_type":"json-path","type":"ch:validation"}`
+)
+
+// TestUnitCompareActualToExpected asserts that errors are compared on every field, ignoring their order.
+func TestUnitCompareActualToExpected(t *testing.T) {
+
+	Convey("Given an expected array of errors", t, func() {
+		expected := []byte(`[` + maxLengthError + `,` + requiredError + `]`)
+
+		Convey("When the actual errors are the same in a different order, then they match", func() {
+			So(CompareActualToExpected([]byte(`[`+requiredError+`,`+maxLengthError+`]`), expected), ShouldBeTrue)
+		})
+
+		Convey("When an actual error has a different constraint, then they don't match", func() {
+			actual := `{"error":"maximum string length is 8","error_code":"max_length","error_values":{"constraint":9,"property":"company_number","value":"123456789"},"location":"company_number","location_type":"json-path","type":"ch:validation"}`
+			So(CompareActualToExpected([]byte(`[`+actual+`,`+requiredError+`]`), expected), ShouldBeFalse)
+		})
+
+		Convey("When an actual error has a different message, then they don't match", func() {
+			actual := `{"error":"property 'delta_at' is absent","error_code":"required","error_values":{"property":"delta_at"},"location":"delta_at","location_type":"json-path","type":"ch:validation"}`
+			So(CompareActualToExpected([]byte(`[`+maxLengthError+`,`+actual+`]`), expected), ShouldBeFalse)
+		})
+
+		Convey("When an error is missing, then they don't match", func() {
+			So(CompareActualToExpected([]byte(`[`+maxLengthError+`]`), expected), ShouldBeFalse)
+		})
+	})
+}
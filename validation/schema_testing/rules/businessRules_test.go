@@ -0,0 +1,96 @@
+package rules
+
+import (
+	"bytes"
+	"github.com/companieshouse/chs-delta-api/validation"
+	"github.com/companieshouse/chs-delta-api/validation/schema_testing/common"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	requestBodiesLocation                 = "./request_bodies/"
+	okRequestBodyLocation                 = requestBodiesLocation + "ok_request_body"
+	deltaAtFormatErrorRequestBodyLocation = requestBodiesLocation + "delta_at_format_error_request_body"
+	deltaAtFutureErrorRequestBodyLocation = requestBodiesLocation + "delta_at_future_error_request_body"
+	companyNumberErrorRequestBodyLocation = requestBodiesLocation + "company_number_error_request_body"
+
+	responseBodiesLocation                 = "./response_bodies/"
+	deltaAtFormatErrorResponseBodyLocation = responseBodiesLocation + "delta_at_format_error_response_body"
+	deltaAtFutureErrorResponseBodyLocation = responseBodiesLocation + "delta_at_future_error_response_body"
+	companyNumberErrorResponseBodyLocation = responseBodiesLocation + "company_number_error_response_body"
+
+	officersEndpoint         = "/delta/officers"
+	officersValidateEndpoint = "/delta/officers/validate"
+	apiSpecLocation          = "../../../ecs-image-build/apispec/api-spec.yml"
+	contextId                = "contextId"
+	methodPost               = "POST"
+)
+
+// newValidator returns a validator which checks deltas against the default business rules.
+func newValidator() validation.CHValidator {
+	chv, _ := validation.NewCHValidator(apiSpecLocation)
+	chv.(validation.RuleUser).UseRules(validation.DefaultRules())
+	return chv
+}
+
+// TestUnitBusinessRulesNoErrors asserts that when a request body is given which matches the schema and every business
+// rule, then no errors are returned.
+func TestUnitBusinessRulesNoErrors(t *testing.T) {
+
+	Convey("Given I want to test the officers-delta business rules", t, func() {
+
+		okRequestBody := common.ReadRequestBody(okRequestBodyLocation)
+
+		r := httptest.NewRequest(methodPost, officersEndpoint, bytes.NewBuffer(okRequestBody))
+		r = common.SetHeaders(r)
+
+		Convey("When I call to validate the request body, providing a valid request", func() {
+
+			validationErrs, err := newValidator().ValidateRequestAgainstOpenApiSpec(r, contextId)
+
+			Convey("Then I am given a nil response as no validation errors are returned", func() {
+				So(err, ShouldBeNil)
+				So(validationErrs, ShouldBeNil)
+			})
+		})
+	})
+}
+
+// TestUnitBusinessRulesErrors asserts that when a request body matches the schema but breaks a business rule, then an
+// errors array describing the broken rule is returned, for both the delta and validate endpoints.
+func TestUnitBusinessRulesErrors(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		request  string
+		response string
+	}{
+		{"a delta_at which isn't a 20 digit timestamp", deltaAtFormatErrorRequestBodyLocation, deltaAtFormatErrorResponseBodyLocation},
+		{"a delta_at in the future", deltaAtFutureErrorRequestBodyLocation, deltaAtFutureErrorResponseBodyLocation},
+		{"officers of different companies", companyNumberErrorRequestBodyLocation, companyNumberErrorResponseBodyLocation},
+	}
+
+	for _, c := range cases {
+		for _, endpoint := range []string{officersEndpoint, officersValidateEndpoint} {
+
+			Convey("Given I want to test the officers-delta business rules with "+c.name+" via "+endpoint, t, func() {
+
+				r := httptest.NewRequest(methodPost, endpoint, bytes.NewBuffer(common.ReadRequestBody(c.request)))
+				r = common.SetHeaders(r)
+
+				Convey("When I call to validate the request body", func() {
+
+					validationErrs, err := newValidator().ValidateRequestAgainstOpenApiSpec(r, contextId)
+
+					Convey("Then I am given an errors array response describing the broken rule", func() {
+						So(err, ShouldBeNil)
+						So(validationErrs, ShouldNotBeNil)
+						So(common.CompareActualToExpected(validationErrs, common.ReadRequestBody(c.response)), ShouldBeTrue)
+					})
+				})
+			})
+		}
+	}
+}